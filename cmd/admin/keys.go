@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/auth"
+	"ismacaulay/procrast-api/pkg/db"
+)
+
+// Running apis reload their keys every minute and start signing with a new
+// key two minutes after it was generated, once they all know it. To rotate,
+// generate a new key and retire the old one once the access tokens it signed
+// have expired.
+func keys(conn db.Conn, args []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	now := time.Now().UTC().Unix()
+	switch args[0] {
+	case "list":
+		keys, err := db.RetrieveSigningKeys(conn)
+		if err != nil {
+			fmt.Println("Failed to load signing keys", err)
+			os.Exit(1)
+		}
+
+		for _, key := range keys {
+			status := "active"
+			if key.Retired != 0 {
+				status = "retired " + time.Unix(key.Retired, 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%s  %-6s  %s  %s\n",
+				key.Kid, key.Algorithm, time.Unix(key.Created, 0).UTC().Format(time.RFC3339), status)
+		}
+	case "generate":
+		alg := auth.AlgorithmEdDSA
+		if len(args) > 1 {
+			alg = args[1]
+		}
+
+		key, err := auth.GenerateSigningKey(alg, now)
+		if err != nil {
+			fmt.Println("Failed to generate signing key", err)
+			os.Exit(1)
+		}
+
+		if err := db.CreateSigningKey(conn, key); err != nil {
+			fmt.Println("Failed to create signing key", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Created signing key: ", key.Kid)
+	case "retire":
+		if len(args) < 2 {
+			usage()
+			os.Exit(1)
+		}
+
+		if err := db.RetireSigningKey(conn, args[1], now); err != nil {
+			fmt.Println("Failed to retire signing key", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Retired signing key: ", args[1])
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
@@ -1,19 +1,22 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"ismacaulay/procrast-api/pkg/models"
 	"os"
-	"strings"
-	"time"
-
-	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 
 	"ismacaulay/procrast-api/pkg/db"
 )
 
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  admin [user]                      create a user")
+	fmt.Println("  admin keys list                   list signing keys")
+	fmt.Println("  admin keys generate [RS256|EdDSA] generate a new signing key")
+	fmt.Println("  admin keys retire <kid>           stop signing and verifying with a key")
+	fmt.Println("  admin replay [-schema name] [-repair] <email|uuid>")
+	fmt.Println("                                    rebuild lists and items from history and report drift")
+}
+
 func main() {
 	userDbConfig := db.PostgresConfig{
 		Host:     os.Getenv("USERDB_HOST"),
@@ -22,50 +25,31 @@ func main() {
 		Password: os.Getenv("POSTGRES_PASSWORD"),
 		Name:     os.Getenv("POSTGRES_DB"),
 	}
-	userDb := db.NewPostgresDatabase(userDbConfig)
-
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Enter email: ")
-	email, _ := reader.ReadString('\n')
-	email = strings.TrimSpace(email)
-	if email == "" {
-		fmt.Println("No email entered")
-		os.Exit(1)
-	}
-
-	fmt.Print("Enter password: ")
-	password, _ := reader.ReadString('\n')
-	password = strings.Trim(password, "\n")
-	if password == "" {
-		fmt.Println("No password entered")
-		os.Exit(1)
-	}
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
-	if err != nil {
-		fmt.Println("Failed to generate password", err)
-		os.Exit(1)
-	}
-
-	uuid, err := uuid.NewRandom()
-	if err != nil {
-		fmt.Println("Failed to generate uuid", err)
-		os.Exit(1)
+	procrastDbConfig := db.PostgresConfig{
+		Host:     os.Getenv("PROCRASTDB_HOST"),
+		Port:     os.Getenv("PROCRASTDB_PORT"),
+		User:     os.Getenv("POSTGRES_USER"),
+		Password: os.Getenv("POSTGRES_PASSWORD"),
+		Name:     os.Getenv("POSTGRES_DB"),
 	}
 
-	now := time.Now().UTC().Unix()
-	user := models.User{
-		UUID:     uuid,
-		Email:    email,
-		PassHash: passHash,
-		Created:  now,
-		Modified: now,
+	cmd := "user"
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
 	}
 
-	if err := db.CreateUser(userDb.Conn, user); err != nil {
-		fmt.Println("Failed to create user", err)
+	switch cmd {
+	case "user":
+		userDb := db.NewPostgresDatabase(userDbConfig)
+		createUser(userDb.Conn)
+	case "keys":
+		userDb := db.NewPostgresDatabase(userDbConfig)
+		keys(userDb.Conn, os.Args[2:])
+	case "replay":
+		replay(procrastDbConfig, userDbConfig, os.Args[2:])
+	default:
+		usage()
 		os.Exit(1)
 	}
-
-	fmt.Println("Created user: ", uuid)
 }
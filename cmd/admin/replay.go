@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/lib/pq"
+
+	"ismacaulay/procrast-api/pkg/api"
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/migrate"
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+var schemaName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// replay rebuilds a user's lists and items from their history and reports
+// where the live tables have drifted. The rebuild happens in memory unless a
+// scratch schema is given, in which case the schema is recreated from scratch
+// and left behind for inspection.
+func replay(procrastDbConfig, userDbConfig db.PostgresConfig, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	schema := flags.String("schema", "", "scratch schema to rebuild into (default: in memory)")
+	repair := flags.Bool("repair", false, "update the live tables to match the history")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if *schema == "public" || (*schema != "" && !schemaName.MatchString(*schema)) {
+		fmt.Println("Invalid scratch schema:", *schema)
+		os.Exit(1)
+	}
+
+	userDb := db.NewPostgresDatabase(userDbConfig)
+	user, err := db.FindUserByEmail(userDb.Conn, flags.Arg(0))
+	if err != nil {
+		user, err = db.FindUserByUUID(userDb.Conn, flags.Arg(0))
+	}
+	if err != nil {
+		fmt.Println("Unknown user:", flags.Arg(0))
+		os.Exit(1)
+	}
+	userId := user.UUID.String()
+
+	procrastDb := db.NewPostgresDatabase(procrastDbConfig)
+	live := db.NewPostgresStore(procrastDb.Conn, userDb.Conn)
+
+	history, err := db.GetHistorySince(procrastDb.Conn, userId, 0)
+	if err != nil {
+		fmt.Println("Failed to load history", err)
+		os.Exit(1)
+	}
+
+	var scratch db.Store
+	if *schema == "" {
+		scratch = db.NewMemoryStore()
+	} else {
+		scratch = newScratchStore(procrastDb.Conn, userDb.Conn, procrastDbConfig, *schema)
+	}
+
+	skipped, err := api.Replay(scratch, userId, history)
+	if err != nil {
+		fmt.Println("Failed to replay history", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Replayed %d history entries, %d skipped\n", len(history), skipped)
+
+	drift, err := api.DiffState(userId, live, scratch)
+	if err != nil {
+		fmt.Println("Failed to compare state", err)
+		os.Exit(1)
+	}
+
+	if drift.Empty() {
+		fmt.Println("No drift")
+		return
+	}
+
+	for _, d := range drift.Lists {
+		fmt.Printf("list %s: %s\n", d.UUID, describeDrift(listState(d.Live), listState(d.Replayed)))
+	}
+
+	for _, d := range drift.Items {
+		fmt.Printf("item %s: %s\n", d.UUID, describeDrift(itemState(d.Live), itemState(d.Replayed)))
+	}
+
+	if !*repair {
+		os.Exit(2)
+	}
+
+	if err := api.Repair(live, userId, drift); err != nil {
+		fmt.Println("Failed to repair", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Repaired %d lists and %d items\n", len(drift.Lists), len(drift.Items))
+}
+
+// newScratchStore recreates the schema in the procrast database for a rebuild.
+// Only the procrast tables are rebuilt, lookups in the user database go to
+// the live one.
+func newScratchStore(conn, userConn db.Conn, config db.PostgresConfig, schema string) db.Store {
+	quoted := pq.QuoteIdentifier(schema)
+	if _, err := conn.Exec(`DROP SCHEMA IF EXISTS ` + quoted + ` CASCADE`); err != nil {
+		fmt.Println("Failed to drop scratch schema", err)
+		os.Exit(1)
+	}
+
+	if _, err := conn.Exec(`CREATE SCHEMA ` + quoted); err != nil {
+		fmt.Println("Failed to create scratch schema", err)
+		os.Exit(1)
+	}
+
+	config.Schema = schema
+	scratchDb := db.NewPostgresDatabase(config)
+	migrator, err := migrate.New(scratchDb.Conn, migrate.ProcrastMigrations)
+	if err != nil {
+		fmt.Println("Invalid migrations", err)
+		os.Exit(1)
+	}
+
+	if err := migrator.Up(migrator.Latest()); err != nil {
+		fmt.Println("Failed to migrate scratch schema", err)
+		os.Exit(1)
+	}
+
+	return db.NewPostgresStore(scratchDb.Conn, userConn)
+}
+
+func listState(list *models.List) string {
+	if list == nil {
+		return ""
+	}
+	return fmt.Sprintf("title=%q description=%q position=%q modified=%d", list.Title, list.Description, list.Position, list.Modified)
+}
+
+func itemState(item *models.Item) string {
+	if item == nil {
+		return ""
+	}
+	return fmt.Sprintf("title=%q description=%q state=%d due=%d start=%d rrule=%q position=%q modified=%d list=%s parent=%s",
+		item.Title, item.Description, item.State, item.Due, item.Start, item.RRule, item.Position, item.Modified, item.ListUUID, item.ParentUUID)
+}
+
+func describeDrift(live, replayed string) string {
+	switch {
+	case live == "":
+		return "missing, history has " + replayed
+	case replayed == "":
+		return "deleted in history, live has " + live
+	default:
+		return "live has " + live + ", history has " + replayed
+	}
+}
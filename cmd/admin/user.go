@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+func createUser(conn db.Conn) {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Enter email: ")
+	email, _ := reader.ReadString('\n')
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		fmt.Println("No email entered")
+		os.Exit(1)
+	}
+
+	fmt.Print("Enter password: ")
+	password, _ := reader.ReadString('\n')
+	password = strings.Trim(password, "\n")
+	if password == "" {
+		fmt.Println("No password entered")
+		os.Exit(1)
+	}
+
+	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		fmt.Println("Failed to generate password", err)
+		os.Exit(1)
+	}
+
+	uuid, err := uuid.NewRandom()
+	if err != nil {
+		fmt.Println("Failed to generate uuid", err)
+		os.Exit(1)
+	}
+
+	now := time.Now().UTC().Unix()
+	user := models.User{
+		UUID:     uuid,
+		Email:    email,
+		PassHash: passHash,
+		Created:  now,
+		Modified: now,
+		Verified: now,
+	}
+
+	if err := db.CreateUser(conn, user); err != nil {
+		fmt.Println("Failed to create user", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Created user: ", uuid)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/migrate"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: migrate [flags] up|down|status")
+	flag.PrintDefaults()
+}
+
+func main() {
+	database := flag.String("db", "procrast", "database to migrate: procrast or user")
+	target := flag.Int("target", -1, "version to migrate to (default: latest for up, previous for down)")
+	dryRun := flag.Bool("dry-run", false, "print the statements instead of executing them")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	var config db.PostgresConfig
+	var migrations []migrate.Migration
+	switch *database {
+	case "procrast":
+		config = db.PostgresConfig{
+			Host:     os.Getenv("PROCRASTDB_HOST"),
+			Port:     os.Getenv("PROCRASTDB_PORT"),
+			User:     os.Getenv("POSTGRES_USER"),
+			Password: os.Getenv("POSTGRES_PASSWORD"),
+			Name:     os.Getenv("POSTGRES_DB"),
+		}
+		migrations = migrate.ProcrastMigrations
+	case "user":
+		config = db.PostgresConfig{
+			Host:     os.Getenv("USERDB_HOST"),
+			Port:     os.Getenv("USERDB_PORT"),
+			User:     os.Getenv("POSTGRES_USER"),
+			Password: os.Getenv("POSTGRES_PASSWORD"),
+			Name:     os.Getenv("POSTGRES_DB"),
+		}
+		migrations = migrate.UserMigrations
+	default:
+		fmt.Println("Unknown database:", *database)
+		os.Exit(1)
+	}
+
+	conn := db.NewPostgresDatabase(config)
+	migrator, err := migrate.New(conn.Conn, migrations)
+	if err != nil {
+		fmt.Println("Invalid migrations:", err)
+		os.Exit(1)
+	}
+	migrator.DryRun = *dryRun
+	migrator.Out = os.Stdout
+
+	current, err := migrator.Current()
+	if err != nil {
+		fmt.Println("Failed to load schema version:", err)
+		os.Exit(1)
+	}
+
+	switch flag.Arg(0) {
+	case "status":
+		fmt.Printf("current: %d\nlatest: %d\n", current, migrator.Latest())
+		if current > migrator.Latest() {
+			fmt.Println(migrate.ErrSchemaAhead)
+			os.Exit(1)
+		}
+	case "up":
+		if *target < 0 {
+			*target = migrator.Latest()
+		}
+		if err := migrator.Up(*target); err != nil {
+			fmt.Println("Failed to migrate:", err)
+			os.Exit(1)
+		}
+	case "down":
+		if *target < 0 {
+			*target = current - 1
+		}
+		if err := migrator.Down(*target); err != nil {
+			fmt.Println("Failed to migrate:", err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
@@ -1,13 +1,97 @@
 package main
 
 import (
+	"log"
 	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 
 	"ismacaulay/procrast-api/pkg/api"
+	"ismacaulay/procrast-api/pkg/auth"
 	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/mail"
+	"ismacaulay/procrast-api/pkg/migrate"
+	"ismacaulay/procrast-api/pkg/models"
 )
 
 func main() {
+	var store db.Store
+	switch os.Getenv("PROCRAST_STORE") {
+	case "memory":
+		store = newMemoryStore()
+	case "", "postgres":
+		store = newPostgresStore()
+	default:
+		log.Fatalf("Unknown store: %s", os.Getenv("PROCRAST_STORE"))
+	}
+
+	loadSigningKeys(store)
+	go auth.Keys.Refresh(store, auth.KeyRefreshInterval)
+
+	api := api.New(store, newMailer())
+	if retention := os.Getenv("TRASH_RETENTION"); retention != "" {
+		d, err := time.ParseDuration(retention)
+		if err != nil {
+			log.Fatalf("Invalid TRASH_RETENTION: %s", err)
+		}
+		api.TrashRetention = d
+	}
+	api.Run()
+}
+
+// loadSigningKeys loads the keyring, creating a key with JWT_ALGORITHM
+// (default EdDSA) if there is no active key yet.
+func loadSigningKeys(store db.KeyStore) {
+	if err := auth.Keys.Load(store); err != nil {
+		log.Fatalf("Unable to load signing keys: %s", err)
+	}
+
+	if _, err := auth.Keys.SigningKey(); err == nil {
+		return
+	}
+
+	alg := os.Getenv("JWT_ALGORITHM")
+	if alg == "" {
+		alg = auth.AlgorithmEdDSA
+	}
+
+	key, err := auth.GenerateSigningKey(alg, time.Now().UTC().Unix())
+	if err != nil {
+		log.Fatalf("Unable to generate signing key: %s", err)
+	}
+
+	if err := store.CreateSigningKey(key); err != nil {
+		log.Fatalf("Unable to store signing key: %s", err)
+	}
+	log.Println("Created signing key:", key.Kid)
+
+	if err := auth.Keys.Load(store); err != nil {
+		log.Fatalf("Unable to load signing keys: %s", err)
+	}
+}
+
+func newMailer() mail.Mailer {
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		return mail.LogMailer{}
+	case "file":
+		return mail.FileMailer{Dir: os.Getenv("MAILER_DIR")}
+	case "smtp":
+		return mail.SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			From:     os.Getenv("SMTP_FROM"),
+			User:     os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	default:
+		log.Fatalf("Unknown mailer: %s", os.Getenv("MAILER"))
+	}
+	return nil
+}
+
+func newPostgresStore() db.Store {
 	dbConfig := db.PostgresConfig{
 		Host:     os.Getenv("PROCRASTDB_HOST"),
 		Port:     os.Getenv("PROCRASTDB_PORT"),
@@ -16,6 +100,7 @@ func main() {
 		Name:     os.Getenv("POSTGRES_DB"),
 	}
 	dataDb := db.NewPostgresDatabase(dbConfig)
+	migrateDatabase(dataDb.Conn, migrate.ProcrastMigrations)
 
 	userDbConfig := db.PostgresConfig{
 		Host:     os.Getenv("USERDB_HOST"),
@@ -25,7 +110,76 @@ func main() {
 		Name:     os.Getenv("POSTGRES_DB"),
 	}
 	userDb := db.NewPostgresDatabase(userDbConfig)
+	migrateDatabase(userDb.Conn, migrate.UserMigrations)
 
-	api := api.New(dataDb.Conn, userDb.Conn)
-	api.Run()
+	return db.NewPostgresStore(dataDb.Conn, userDb.Conn)
+}
+
+// migrateDatabase refuses to start if the schema is newer than the binary.
+// Pending migrations are applied when MIGRATE_ON_START is set, without it the
+// api refuses to start until they are applied with the migrate command.
+func migrateDatabase(conn db.DB, migrations []migrate.Migration) {
+	migrator, err := migrate.New(conn, migrations)
+	if err != nil {
+		log.Fatalf("Invalid migrations: %s", err)
+	}
+
+	if err := migrator.Check(); err != nil {
+		log.Fatalf("Unable to start: %s", err)
+	}
+
+	if os.Getenv("MIGRATE_ON_START") == "" {
+		current, err := migrator.Current()
+		if err != nil {
+			log.Fatalf("Unable to load schema version: %s", err)
+		}
+
+		if current < migrator.Latest() {
+			log.Fatalf("Unable to start: schema version %d is behind %d, run migrate up", current, migrator.Latest())
+		}
+		return
+	}
+
+	if err := migrator.Up(migrator.Latest()); err != nil {
+		log.Fatalf("Unable to migrate: %s", err)
+	}
+}
+
+// newMemoryStore creates an empty in-memory store. If MEMORY_USER_EMAIL and
+// MEMORY_USER_PASSWORD are set a user is created so the api can be logged into.
+func newMemoryStore() db.Store {
+	log.Println("Initializing memory store")
+	store := db.NewMemoryStore()
+
+	email := os.Getenv("MEMORY_USER_EMAIL")
+	password := os.Getenv("MEMORY_USER_PASSWORD")
+	if email == "" || password == "" {
+		return store
+	}
+
+	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		log.Fatalf("Failed to generate password: %s", err)
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		log.Fatalf("Failed to generate uuid: %s", err)
+	}
+
+	now := time.Now().UTC().Unix()
+	user := models.User{
+		UUID:     id,
+		Email:    email,
+		PassHash: passHash,
+		Created:  now,
+		Modified: now,
+		Verified: now,
+	}
+	if err := store.CreateUser(user); err != nil {
+		log.Fatalf("Failed to create user: %s", err)
+	}
+
+	log.Println("Created user:", email)
+	return store
 }
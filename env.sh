@@ -11,4 +11,4 @@ export USERDB_USER=postgres
 export USERDB_PASSWORD=supersecret
 export USERDB_DB=postgres
 
-export JWT_SECRET=supersecretjwt
+export JWT_ALGORITHM=EdDSA
@@ -1,6 +1,6 @@
 module ismacaulay/procrast-api
 
-go 1.14
+go 1.16
 
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
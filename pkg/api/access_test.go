@@ -0,0 +1,167 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// accessFixture is a victim with a list, an item and a list and item in the
+// trash, and an attacker with a list and item of their own.
+type accessFixture struct {
+	*testApi
+	victim, attacker testUser
+
+	list, trashedList, own models.List
+	item, trashedItem      models.Item
+	ownItem                models.Item
+}
+
+func newAccessFixture(t *testing.T) *accessFixture {
+	a := newTestApi(t)
+	f := &accessFixture{testApi: a, victim: a.newUser("victim@example.com"), attacker: a.newUser("attacker@example.com")}
+
+	f.list = a.createList(f.victim, "victim")
+	f.item = a.createItem(f.victim, f.list, "victim")
+	f.trashedItem = a.createItem(f.victim, f.list, "trashed")
+	a.must(f.victim, http.StatusNoContent, "DELETE", "/items/"+f.trashedItem.UUID.String(), nil, nil)
+	f.trashedList = a.createList(f.victim, "trashed")
+	a.must(f.victim, http.StatusNoContent, "DELETE", "/lists/"+f.trashedList.UUID.String(), nil, nil)
+
+	f.own = a.createList(f.attacker, "attacker")
+	f.ownItem = a.createItem(f.attacker, f.own, "attacker")
+	return f
+}
+
+// victimState is everything of the victim's that a write could change.
+type victimState struct {
+	Lists        []models.List
+	Items        map[uuid.UUID][]models.Item
+	TrashedLists []models.List
+	TrashedItems []models.Item
+	History      []models.History
+}
+
+func (f *accessFixture) victimState() victimState {
+	user := f.victim.id
+	state := victimState{Items: make(map[uuid.UUID][]models.Item)}
+	state.Lists, _ = f.store.RetrieveAllLists(user)
+	for _, list := range state.Lists {
+		state.Items[list.UUID], _ = f.store.RetrieveAllItems(user, list.UUID.String())
+	}
+	state.TrashedLists, _ = f.store.RetrieveTrashedLists(user)
+	state.TrashedItems, _ = f.store.RetrieveTrashedItems(user)
+	state.History, _ = f.store.GetHistorySince(user, 0)
+	return state
+}
+
+func (f *accessFixture) expectUnchanged(t *testing.T, before victimState) {
+	t.Helper()
+	if after := f.victimState(); !reflect.DeepEqual(before, after) {
+		t.Errorf("the victim's data changed\nbefore: %+v\nafter:  %+v", before, after)
+	}
+}
+
+func TestRestRejectsForeignIds(t *testing.T) {
+	f := newAccessFixture(t)
+	list, trashedList, own := f.list.UUID.String(), f.trashedList.UUID.String(), f.own.UUID.String()
+	item, trashedItem, ownItem := f.item.UUID.String(), f.trashedItem.UUID.String(), f.ownItem.UUID.String()
+
+	notFound := []int{http.StatusNotFound}
+	// ids in the body that can't be found are unprocessable instead
+	unprocessable := []int{http.StatusNotFound, http.StatusUnprocessableEntity}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status []int
+	}{
+		{"update list", "PATCH", "/lists/" + list, map[string]interface{}{"title": "x"}, notFound},
+		{"delete list", "DELETE", "/lists/" + list, nil, notFound},
+		{"restore list", "POST", "/lists/" + trashedList + "/restore", nil, notFound},
+		{"move list", "POST", "/lists/" + list + "/move", map[string]interface{}{"after": own}, notFound},
+		{"create item", "POST", "/lists/" + list + "/items", map[string]interface{}{"title": "x"}, notFound},
+		{"move items out", "POST", "/lists/" + list + "/items/move", map[string]interface{}{"state": 0, "list_uuid": own}, notFound},
+		{"move items in", "POST", "/lists/" + own + "/items/move", map[string]interface{}{"state": 0, "list_uuid": list}, unprocessable},
+		{"update item", "PATCH", "/items/" + item, map[string]interface{}{"title": "x"}, notFound},
+		{"update item into list", "PATCH", "/items/" + ownItem, map[string]interface{}{"list_uuid": list}, unprocessable},
+		{"update item under parent", "PATCH", "/items/" + ownItem, map[string]interface{}{"parent_uuid": item}, unprocessable},
+		{"delete item", "DELETE", "/items/" + item, nil, notFound},
+		{"restore item", "POST", "/items/" + trashedItem + "/restore", nil, notFound},
+		{"move item out", "POST", "/items/" + item + "/move", map[string]interface{}{"after": ownItem}, notFound},
+		{"move item in", "POST", "/items/" + ownItem + "/move", map[string]interface{}{"after": item}, unprocessable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := f.victimState()
+			status := f.do(f.attacker, test.method, test.path, test.body, nil)
+
+			allowed := false
+			for _, s := range test.status {
+				allowed = allowed || s == status
+			}
+			if !allowed {
+				t.Errorf("%s %s: expected one of %v, got %d", test.method, test.path, test.status, status)
+			}
+			f.expectUnchanged(t, before)
+		})
+	}
+}
+
+func TestHistoryRejectsForeignIds(t *testing.T) {
+	f := newAccessFixture(t)
+	now := time.Now().UTC().Unix() + 1
+
+	tests := []struct {
+		name  string
+		entry models.History
+		code  string
+	}{
+		{"list create", historyEntry(CmdListCreate, models.List{UUID: f.list.UUID, Title: "x"}, now), ErrCodeForbidden},
+		{"list update", historyEntry(CmdListUpdate, map[string]interface{}{"uuid": f.list.UUID, "title": "x"}, now), ErrCodeListNotFound},
+		{"list delete", historyEntry(CmdListDelete, uuidState{UUID: f.list.UUID}, now), ErrCodeListNotFound},
+		{"list restore", historyEntry(CmdListRestore, uuidState{UUID: f.trashedList.UUID}, now), ErrCodeListNotFound},
+		{"list move", historyEntry(CmdListMove, positionState{UUID: f.list.UUID, Position: "a"}, now), ErrCodeListNotFound},
+		{"item create in list", historyEntry(CmdItemCreate, models.Item{UUID: uuid.New(), Title: "x", ListUUID: f.list.UUID}, now), ErrCodeListNotFound},
+		{"item create with id", historyEntry(CmdItemCreate, models.Item{UUID: f.item.UUID, Title: "x", ListUUID: f.own.UUID}, now), ErrCodeForbidden},
+		{"item create under parent", historyEntry(CmdItemCreate, models.Item{UUID: uuid.New(), Title: "x", ListUUID: f.own.UUID, ParentUUID: f.item.UUID}, now), ErrCodeItemNotFound},
+		{"item update", historyEntry(CmdItemUpdate, map[string]interface{}{"uuid": f.item.UUID, "title": "x"}, now), ErrCodeItemNotFound},
+		{"item delete", historyEntry(CmdItemDelete, uuidState{UUID: f.item.UUID}, now), ErrCodeItemNotFound},
+		{"item restore", historyEntry(CmdItemRestore, uuidState{UUID: f.trashedItem.UUID}, now), ErrCodeItemNotFound},
+		{"item move", historyEntry(CmdItemMove, itemMoveState{UUID: f.item.UUID, ListUUID: f.own.UUID, Position: "a"}, now), ErrCodeItemNotFound},
+		{"item move into list", historyEntry(CmdItemMove, itemMoveState{UUID: f.ownItem.UUID, ListUUID: f.list.UUID, Position: "a"}, now), ErrCodeListNotFound},
+		{"item move under parent", historyEntry(CmdItemMove, itemMoveState{UUID: f.ownItem.UUID, ListUUID: f.own.UUID, ParentUUID: f.item.UUID, Position: "a"}, now), ErrCodeItemNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := f.victimState()
+			result := f.sync(f.attacker, test.entry)
+			if result.Status != StatusFailed || result.Error != test.code {
+				t.Errorf("expected failed with %s, got %s %s", test.code, result.Status, result.Error)
+			}
+			f.expectUnchanged(t, before)
+		})
+	}
+}
+
+// The attacker's own rows are left alone too, moving their item into the
+// victim's list must not take it out of their list.
+func TestForeignMovesKeepOwnItems(t *testing.T) {
+	f := newAccessFixture(t)
+	now := time.Now().UTC().Unix() + 1
+	f.sync(f.attacker, historyEntry(CmdItemMove, itemMoveState{UUID: f.ownItem.UUID, ListUUID: f.list.UUID, Position: "a"}, now))
+
+	item, err := f.store.RetrieveItem(f.attacker.id, f.ownItem.UUID.String())
+	if err != nil || item.ListUUID != f.own.UUID {
+		t.Errorf("the attacker's item was moved: %+v %v", item, err)
+	}
+}
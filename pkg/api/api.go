@@ -3,18 +3,24 @@ package api
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"ismacaulay/procrast-api/pkg/auth"
 	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/mail"
 
 	"github.com/go-chi/chi"
 )
 
 type Api struct {
 	router *chi.Mux
+	store  db.Store
+
+	// TrashRetention is how long deleted lists and items are kept
+	TrashRetention time.Duration
 }
 
-func New(db, userDb db.DB) *Api {
+func New(store db.Store, mailer mail.Mailer) *Api {
 	r := chi.NewRouter()
 
 	r.Get("/heartbeat", func(w http.ResponseWriter, r *http.Request) {
@@ -22,50 +28,168 @@ func New(db, userDb db.DB) *Api {
 	})
 
 	r.Route("/auth/v1", func(r chi.Router) {
-		r.Post("/login", postLoginHandler(userDb))
+		r.Get("/.well-known/jwks.json", getJWKSHandler())
+		r.Post("/login", postLoginHandler(store))
+		r.Post("/register", postRegisterHandler(store, mailer))
+		r.Post("/verify", postVerifyHandler(store))
+		r.Post("/password/forgot", postForgotPasswordHandler(store, mailer))
+		r.Post("/password/reset", postResetPasswordHandler(store))
+		r.Post("/refresh", postRefreshHandler(store))
+		r.With(auth.TokenSecurity(store)).Post("/logout", postLogoutHandler(store))
+	})
+
+	// anyone with the token of a share link can read the list
+	r.Route("/public/v1", func(r chi.Router) {
+		r.Get("/lists/{token}", getPublicListHandler(store))
 	})
 
 	r.Route("/procrast/v1", func(r chi.Router) {
-		r.Use(auth.TokenSecurity)
-		r.Use(auth.UserValidation(userDb))
+		r.Use(auth.TokenSecurity(store))
+		r.Use(auth.UserValidation(store))
 
 		r.Route("/lists", func(r chi.Router) {
-			r.Get("/", getListsHandler(db))
-			r.Post("/", postListHandler(db))
+			r.Get("/", getListsHandler(store))
+			r.Post("/", postListHandler(store))
 
 			r.Route("/{listId}", func(r chi.Router) {
 				r.Use(validateUUIDParameterMiddleware("listId"))
 
-				r.Get("/", getListHandler(db))
-				r.Patch("/", patchListHandler(db))
-				r.Delete("/", deleteListHandler(db))
+				r.Get("/", getListHandler(store))
+				r.Patch("/", patchListHandler(store))
+				r.Delete("/", deleteListHandler(store))
+				r.Post("/restore", postRestoreListHandler(store))
+				r.Post("/move", postMoveListHandler(store))
+
+				r.Get("/items", getItemsHandler(store))
+				r.Get("/items/tree", getItemTreeHandler(store))
+				r.Post("/items", postItemHandler(store))
+				r.Post("/items/move", postMoveItemsHandler(store))
+
+				r.Get("/board", getBoardHandler(store))
+				r.Post("/board/move", postMoveCardHandler(store))
+
+				r.Get("/members", getMembersHandler(store))
+				r.With(validateUUIDParameterMiddleware("userId")).Patch("/members/{userId}", patchMemberHandler(store))
+				r.With(validateUUIDParameterMiddleware("userId")).Delete("/members/{userId}", deleteMemberHandler(store))
+
+				r.Get("/invitations", getListInvitationsHandler(store))
+				r.Post("/invitations", postInvitationHandler(store, mailer))
+				r.With(validateUUIDParameterMiddleware("invitationId")).Delete("/invitations/{invitationId}", deleteListInvitationHandler(store))
+
+				r.Get("/links", getShareLinksHandler(store))
+				r.Post("/links", postShareLinkHandler(store))
+				r.With(validateUUIDParameterMiddleware("linkId")).Delete("/links/{linkId}", deleteShareLinkHandler(store))
+			})
+		})
+
+		r.Route("/invitations", func(r chi.Router) {
+			r.Get("/", getInvitationsHandler(store))
+
+			r.Route("/{invitationId}", func(r chi.Router) {
+				r.Use(validateUUIDParameterMiddleware("invitationId"))
+
+				r.Delete("/", deleteInvitationHandler(store))
+				r.Post("/accept", postAcceptInvitationHandler(store))
+			})
+		})
+
+		r.Route("/workspaces", func(r chi.Router) {
+			r.Get("/", getWorkspacesHandler(store))
+			r.Post("/", postWorkspaceHandler(store))
+
+			r.Route("/{workspaceId}", func(r chi.Router) {
+				r.Use(validateUUIDParameterMiddleware("workspaceId"))
+
+				r.Get("/", getWorkspaceHandler(store))
+				r.Patch("/", patchWorkspaceHandler(store))
 
-				r.Get("/items", getItemsHandler(db))
-				r.Post("/items", postItemHandler(db))
+				r.Get("/members", getWorkspaceMembersHandler(store))
+				r.Post("/members", postWorkspaceMemberHandler(store))
+				r.With(validateUUIDParameterMiddleware("userId")).Patch("/members/{userId}", patchWorkspaceMemberHandler(store))
+				r.With(validateUUIDParameterMiddleware("userId")).Delete("/members/{userId}", deleteWorkspaceMemberHandler(store))
 			})
 		})
 
 		r.Route("/items", func(r chi.Router) {
+			r.Get("/", getTaggedItemsHandler(store))
+
 			r.Route("/{itemId}", func(r chi.Router) {
 				r.Use(validateUUIDParameterMiddleware("itemId"))
 
-				r.Get("/", getItemHandler(db))
-				r.Patch("/", patchItemHandler(db))
-				r.Delete("/", deleteItemHandler(db))
+				r.Get("/", getItemHandler(store))
+				r.Patch("/", patchItemHandler(store))
+				r.Delete("/", deleteItemHandler(store))
+				r.Post("/restore", postRestoreItemHandler(store))
+				r.Post("/move", postMoveItemHandler(store))
+
+				r.Get("/tags", getItemTagsHandler(store))
+				r.With(validateUUIDParameterMiddleware("tagId")).Put("/tags/{tagId}", putItemTagHandler(store))
+				r.With(validateUUIDParameterMiddleware("tagId")).Delete("/tags/{tagId}", deleteItemTagHandler(store))
+
+				r.Get("/graph", getItemGraphHandler(store))
+				r.With(validateUUIDParameterMiddleware("blockerId")).Put("/blockers/{blockerId}", putItemBlockerHandler(store))
+				r.With(validateUUIDParameterMiddleware("blockerId")).Delete("/blockers/{blockerId}", deleteItemBlockerHandler(store))
+
+				r.With(validateUUIDParameterMiddleware("userId")).Put("/assignee/{userId}", putItemAssigneeHandler(store))
+				r.Delete("/assignee", deleteItemAssigneeHandler(store))
+
+				r.Get("/comments", getCommentsHandler(store))
+				r.Post("/comments", postCommentHandler(store))
+				r.With(validateUUIDParameterMiddleware("commentId")).Patch("/comments/{commentId}", patchCommentHandler(store))
+				r.With(validateUUIDParameterMiddleware("commentId")).Delete("/comments/{commentId}", deleteCommentHandler(store))
+			})
+		})
+
+		r.Route("/tags", func(r chi.Router) {
+			r.Get("/", getTagsHandler(store))
+			r.Post("/", postTagHandler(store))
+
+			r.Route("/{tagId}", func(r chi.Router) {
+				r.Use(validateUUIDParameterMiddleware("tagId"))
+
+				r.Get("/", getTagHandler(store))
+				r.Patch("/", patchTagHandler(store))
+				r.Delete("/", deleteTagHandler(store))
 			})
 		})
 
+		r.Get("/trash", getTrashHandler(store))
+
+		r.Route("/views", func(r chi.Router) {
+			r.Get("/today", getTodayHandler(store))
+			r.Get("/overdue", getOverdueHandler(store))
+			r.Get("/upcoming", getUpcomingHandler(store))
+			r.Get("/assigned", getAssignedHandler(store))
+		})
+
+		r.Get("/settings", getSettingsHandler(store))
+		r.Patch("/settings", patchSettingsHandler(store))
+
 		r.Route("/history", func(r chi.Router) {
-			r.Get("/", getHistoryHandler(db))
-			r.Post("/", postHistoryHandler(db))
+			r.Get("/", getHistoryHandler(store))
+			r.Post("/", postHistoryHandler(store))
 		})
 	})
 
-	return &Api{router: r}
+	return &Api{router: r, store: store, TrashRetention: DefaultTrashRetention}
+}
+
+func (api *Api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.router.ServeHTTP(w, r)
 }
 
 func (api *Api) Run() {
 	log.Println("Starting...")
+	go api.purgeExpiredTokens()
+	go api.purgeTrash()
 	log.Fatal(http.ListenAndServe(":8080", api.router))
 	log.Println("Shutting down")
 }
+
+func (api *Api) purgeExpiredTokens() {
+	for range time.Tick(time.Hour) {
+		if err := api.store.PurgeExpiredTokens(time.Now().UTC().Unix()); err != nil {
+			log.Println("Failed to purge expired tokens:", err)
+		}
+	}
+}
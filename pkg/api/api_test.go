@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/auth"
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/mail"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// The api tests run the router against a MemoryStore, as users that log in
+// with access tokens signed by a key of their own.
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+
+	keys := db.NewMemoryStore()
+	key, err := auth.GenerateSigningKey(auth.AlgorithmEdDSA, time.Now().UTC().Unix())
+	if err == nil {
+		err = keys.CreateSigningKey(key)
+	}
+	if err == nil {
+		err = auth.Keys.Load(keys)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+type testApi struct {
+	t     *testing.T
+	api   *Api
+	store db.Store
+}
+
+type testUser struct {
+	id    string
+	token string
+}
+
+func newTestApi(t *testing.T) *testApi {
+	store := db.NewMemoryStore()
+	return &testApi{t: t, api: New(store, mail.LogMailer{}), store: store}
+}
+
+func (a *testApi) newUser(email string) testUser {
+	now := time.Now().UTC().Unix()
+	user := models.User{UUID: uuid.New(), Email: email, Created: now, Modified: now, Verified: now}
+	if err := a.store.CreateUser(user); err != nil {
+		a.t.Fatalf("Failed to create user: %s", err)
+	}
+
+	token, err := auth.GenerateToken(user.UUID.String(), "")
+	if err != nil {
+		a.t.Fatalf("Failed to generate token: %s", err)
+	}
+	return testUser{id: user.UUID.String(), token: token}
+}
+
+// do sends the request as the user and decodes the response into out unless
+// it is nil. It returns the status.
+func (a *testApi) do(user testUser, method, path string, body, out interface{}) int {
+	a.t.Helper()
+
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			a.t.Fatalf("Failed to encode request: %s", err)
+		}
+	}
+
+	r := httptest.NewRequest(method, "/procrast/v1"+path, bytes.NewReader(encoded))
+	r.Header.Set("Authorization", "Bearer "+user.token)
+	w := httptest.NewRecorder()
+	a.api.ServeHTTP(w, r)
+
+	if out != nil && w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			a.t.Fatalf("Failed to decode response to %s %s: %s", method, path, err)
+		}
+	}
+	return w.Code
+}
+
+// must is do for requests that are expected to succeed with status.
+func (a *testApi) must(user testUser, status int, method, path string, body, out interface{}) {
+	a.t.Helper()
+	if got := a.do(user, method, path, body, out); got != status {
+		a.t.Fatalf("%s %s: expected %d, got %d", method, path, status, got)
+	}
+}
+
+func (a *testApi) createList(user testUser, title string) models.List {
+	a.t.Helper()
+	var list models.List
+	a.must(user, http.StatusCreated, "POST", "/lists", map[string]interface{}{"title": title}, &list)
+	return list
+}
+
+func (a *testApi) createItem(user testUser, list models.List, title string) models.Item {
+	a.t.Helper()
+	var item models.Item
+	a.must(user, http.StatusCreated, "POST", "/lists/"+list.UUID.String()+"/items", map[string]interface{}{"title": title}, &item)
+	return item
+}
+
+// historyResponse is the response to POST /history.
+type historyResponse struct {
+	Processed []uuid.UUID     `json:"processed"`
+	Results   []historyResult `json:"results"`
+}
+
+func historyEntry(command string, state interface{}, ts int64) models.History {
+	encoded, _ := json.Marshal(state)
+	return models.History{UUID: uuid.New(), Command: command, State: encoded, Timestamp: ts}
+}
+
+// sync submits a single history entry and returns its result.
+func (a *testApi) sync(user testUser, entry models.History) historyResult {
+	a.t.Helper()
+	var response historyResponse
+	a.do(user, "POST", "/history", map[string]interface{}{"history": []models.History{entry}}, &response)
+	if len(response.Results) != 1 {
+		a.t.Fatalf("%s: expected a result, got %+v", entry.Command, response)
+	}
+	return response.Results[0]
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// assigneeState is the state of ITEM ASSIGN and ITEM UNASSIGN history
+// entries, AssigneeUUID is uuid.Nil when the item is unassigned.
+type assigneeState struct {
+	UUID         uuid.UUID `json:"uuid"`
+	AssigneeUUID uuid.UUID `json:"assignee_uuid"`
+}
+
+// command returns the history command that leaves the item in this state.
+func (s assigneeState) command() string {
+	if s.AssigneeUUID == uuid.Nil {
+		return CmdItemUnassign
+	}
+	return CmdItemAssign
+}
+
+// assigneeClock is when the item's assignee was last changed. Items that
+// have never been assigned have no entry, any assignment is newer.
+func assigneeClock(item models.Item) int64 {
+	return item.Clock.Get("assignee", 0)
+}
+
+// isMember reports whether member is a member of the list.
+func isMember(tx db.Store, user string, list, member uuid.UUID) (bool, error) {
+	members, err := tx.RetrieveMembers(user, list.String())
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range members {
+		if m.UserUUID == member {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// assignItem assigns the item to assignee, or unassigns it for uuid.Nil, and
+// records it in the history of the list's members.
+func assignItem(tx db.Store, user string, item models.Item, assignee uuid.UUID, now int64) error {
+	item.Assign(assignee)
+	item.Clock.Set("assignee", now)
+	if err := tx.UpdateItem(user, item); err != nil {
+		return err
+	}
+
+	state := assigneeState{UUID: item.UUID, AssigneeUUID: assignee}
+	return createHistoryForState(tx, state.command(), user, now, state, item.ListUUID)
+}
+
+// unassignLeaving records that the items of the lists assigned to a member
+// who is leaving them are unassigned, which the store does when the member
+// is removed. It has to be called while they are still a member.
+func unassignLeaving(tx db.Store, user, member string, lists []uuid.UUID, now int64) error {
+	for _, list := range lists {
+		items, err := tx.RetrieveAllItems(member, list.String())
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if item.Assignee().String() != member {
+				continue
+			}
+
+			state := assigneeState{UUID: item.UUID}
+			if err := createHistoryForState(tx, CmdItemUnassign, user, now, state, list); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// putItemAssigneeHandler assigns the item to a member of its list.
+func putItemAssigneeHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+		assignee, _ := uuid.Parse(chi.URLParam(r, "userId"))
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if !canEdit(store, user, item.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		member, err := isMember(store, user, item.ListUUID, assignee)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		if !member {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		if item.Assignee() != assignee {
+			now := time.Now().UTC().Unix()
+			err = store.Transaction(func(tx db.Store) error {
+				return assignItem(tx, user, item, assignee, now)
+			})
+
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func deleteItemAssigneeHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if !canEdit(store, user, item.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		if item.Assignee() == uuid.Nil {
+			// the item is not assigned
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			return assignItem(tx, user, item, uuid.Nil, now)
+		})
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+func TestAssigneeLeftOutForNobody(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	list := a.createList(user, "list")
+	item := a.createItem(user, list, "item")
+	path := "/items/" + item.UUID.String()
+
+	assignee := func() (interface{}, bool) {
+		t.Helper()
+		var fields map[string]interface{}
+		a.must(user, http.StatusOK, "GET", path, nil, &fields)
+		value, ok := fields["assignee_uuid"]
+		return value, ok
+	}
+
+	if value, ok := assignee(); ok {
+		t.Errorf("unassigned item has an assignee: %v", value)
+	}
+
+	a.must(user, http.StatusNoContent, "PUT", path+"/assignee/"+user.id, nil, nil)
+	if value, _ := assignee(); value != user.id {
+		t.Errorf("expected the item to be assigned to %s, got %v", user.id, value)
+	}
+
+	a.must(user, http.StatusNoContent, "DELETE", path+"/assignee", nil, nil)
+	if value, ok := assignee(); ok {
+		t.Errorf("unassigned item has an assignee: %v", value)
+	}
+
+	// older clients send the nil uuid for nobody
+	id := uuid.New()
+	state := map[string]interface{}{"uuid": id, "title": "x", "list_uuid": list.UUID, "assignee_uuid": uuid.Nil}
+	if result := a.sync(user, historyEntry(CmdItemCreate, state, time.Now().UTC().Unix())); result.Status != StatusApplied {
+		t.Fatalf("item with the nil assignee was not applied: %+v", result)
+	}
+
+	var stored models.Item
+	a.must(user, http.StatusOK, "GET", "/items/"+id.String(), nil, &stored)
+	if stored.AssigneeUUID != nil {
+		t.Errorf("expected nobody to be assigned, got %s", stored.AssigneeUUID)
+	}
+}
@@ -2,15 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
 	"ismacaulay/procrast-api/pkg/auth"
 	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/mail"
+	"ismacaulay/procrast-api/pkg/models"
 )
 
-func postLoginHandler(conn db.DB) http.HandlerFunc {
+func postLoginHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request struct {
 			Email    *string `json:"email,omitempty"`
@@ -26,7 +33,7 @@ func postLoginHandler(conn db.DB) http.HandlerFunc {
 			return
 		}
 
-		user, err := db.FindUserByEmail(conn, *request.Email)
+		user, err := store.FindUserByEmail(normalizeEmail(*request.Email))
 		if err != nil {
 			respondWithError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
 			return
@@ -37,14 +44,445 @@ func postLoginHandler(conn db.DB) http.HandlerFunc {
 			return
 		}
 
-		token, err := auth.GenerateToken(user.UUID.String())
+		if user.Verified == 0 {
+			respondWithError(w, http.StatusForbidden, "Email address has not been verified")
+			return
+		}
+
+		family, err := uuid.NewRandom()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		tokens, err := issueTokens(store, user.UUID, family, uuid.Nil, now)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, tokens)
+	}
+}
+
+func postRefreshHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			RefreshToken *string `json:"refresh_token,omitempty"`
+			Workspace    *string `json:"workspace,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		hash := auth.HashOpaqueToken(*request.RefreshToken)
+
+		// switching to a workspace is checked before the token is used, so
+		// asking for one the user is not a member of doesn't end the session
+		workspace := uuid.Nil
+		if request.Workspace != nil && *request.Workspace != "" {
+			var err error
+			if workspace, err = uuid.Parse(*request.Workspace); err != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+
+			if existing, err := store.RetrieveRefreshToken(hash); err == nil {
+				if _, err := store.RetrieveWorkspace(existing.UserUUID.String(), workspace.String()); err != nil {
+					respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+					return
+				}
+			}
+		}
+
+		token, err := store.UseRefreshToken(hash, now)
+		if err != nil {
+			// a used token being presented again means it was leaked, so
+			// nothing issued from that login can be trusted anymore
+			if existing, err := store.RetrieveRefreshToken(hash); err == nil && existing.Used != 0 {
+				log.Println("Refresh token reused, revoking family", existing.Family)
+				store.RevokeRefreshTokenFamily(existing.Family, now)
+			}
+
+			respondWithError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+
+		if _, err := store.FindUserByUUID(token.UserUUID.String()); err != nil {
+			respondWithError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+
+		// the active workspace is kept, unless the user has left it since
+		if request.Workspace == nil && token.Workspace != uuid.Nil {
+			workspace = token.Workspace
+			if _, err := store.RetrieveWorkspace(token.UserUUID.String(), workspace.String()); err != nil {
+				workspace = uuid.Nil
+			}
+		}
+
+		tokens, err := issueTokens(store, token.UserUUID, token.Family, workspace, now)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, tokens)
+	}
+}
+
+func postLogoutHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		claims := r.Context().Value("claims").(*auth.TokenClaims)
+
+		var request struct {
+			RefreshToken *string `json:"refresh_token,omitempty"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+		}
+
+		now := time.Now().UTC().Unix()
+		if request.RefreshToken != nil {
+			token, err := store.RetrieveRefreshToken(auth.HashOpaqueToken(*request.RefreshToken))
+			if err == nil && token.UserUUID.String() == user {
+				if err := store.RevokeRefreshTokenFamily(token.Family, now); err != nil {
+					respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+			}
+		}
+
+		if err := store.RevokeAccessToken(claims.Id, claims.ExpiresAt); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func getJWKSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, auth.Keys.PublicJWKS())
+	}
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Workspace    string `json:"workspace,omitempty"`
+}
+
+// issueTokens creates a new access token and a refresh token in family, with
+// workspace as the active workspace.
+func issueTokens(store db.TokenStore, user, family, workspace uuid.UUID, now int64) (tokenResponse, error) {
+	active := ""
+	if workspace != uuid.Nil {
+		active = workspace.String()
+	}
+
+	accessToken, err := auth.GenerateToken(user.String(), active)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	refreshToken, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	token := models.RefreshToken{
+		Hash:      hash,
+		Family:    family,
+		UserUUID:  user,
+		Workspace: workspace,
+		Created:   now,
+		Expires:   now + int64(auth.RefreshTokenExpiry.Seconds()),
+	}
+	if err := store.CreateRefreshToken(token); err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(auth.AccessTokenExpiry.Seconds()),
+		Workspace:    active,
+	}, nil
+}
+
+const (
+	minPasswordLength        = 8
+	verificationTokenExpiry  = time.Hour * 24
+	passwordResetTokenExpiry = time.Hour
+)
+
+func postRegisterHandler(store db.Store, mailer mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Email    *string `json:"email,omitempty"`
+			Password *string `json:"password,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		if request.Email == nil || request.Password == nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		email := normalizeEmail(*request.Email)
+		if !strings.Contains(email, "@") || len(*request.Password) < minPasswordLength {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		// the password is hashed either way so the time taken doesn't tell
+		// whether the account exists
+		passHash, err := bcrypt.GenerateFromPassword([]byte(*request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		// respond the same for existing accounts so the endpoint cannot be
+		// used to find them, their owner is emailed instead
+		if existing, err := store.FindUserByEmail(email); err == nil {
+			msg := mail.Message{
+				To:      existing.Email,
+				Subject: "Your procrast account",
+				Body:    "Someone tried to register a procrast account with this email address, which already has one.\n\nIf it was you and you have forgotten your password, you can reset it.",
+			}
+			if err := mailer.Send(msg); err != nil {
+				log.Println("Failed to send account exists email:", err)
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+
+			respondRegistered(w, email)
+			return
+		}
+
+		id, err := uuid.NewRandom()
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, struct {
-			Token string `json:"token"`
-		}{Token: token})
+		now := time.Now().UTC().Unix()
+		user := models.User{
+			UUID:     id,
+			Email:    email,
+			PassHash: passHash,
+			Created:  now,
+			Modified: now,
+		}
+		if err := store.CreateUser(user); err != nil {
+			// lost a race with another registration of the same address
+			if _, err := store.FindUserByEmail(email); err == nil {
+				respondRegistered(w, email)
+				return
+			}
+
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		token, err := createUserToken(store, db.TokenVerification, user, now, verificationTokenExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		msg := mail.Message{
+			To:      user.Email,
+			Subject: "Verify your procrast account",
+			Body:    fmt.Sprintf("Use this token to verify your email address:\n\n%s\n\nIt expires in 24 hours.", token),
+		}
+		if err := mailer.Send(msg); err != nil {
+			log.Println("Failed to send verification email:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondRegistered(w, email)
 	}
 }
+
+// respondRegistered is the response to every valid registration, whether or
+// not the account already existed.
+func respondRegistered(w http.ResponseWriter, email string) {
+	respondWithJSON(w, http.StatusCreated, struct {
+		Email   string `json:"email"`
+		Message string `json:"message"`
+	}{Email: email, Message: "Check your email to continue"})
+}
+
+// normalizeEmail is applied to email addresses before they are stored or
+// looked up, so the same address in a different case is the same account.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func postVerifyHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Token *string `json:"token,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		token, err := store.UseUserToken(db.TokenVerification, auth.HashOpaqueToken(*request.Token), now)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		user, err := store.FindUserByUUID(token.UserUUID.String())
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		if user.Verified == 0 {
+			user.Verified = now
+			user.Modified = now
+			if err := store.UpdateUser(user); err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func postForgotPasswordHandler(store db.Store, mailer mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Email *string `json:"email,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		// always succeed so the endpoint cannot be used to find accounts
+		user, err := store.FindUserByEmail(normalizeEmail(*request.Email))
+		if err != nil {
+			respondWithJSON(w, http.StatusNoContent, nil)
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		token, err := createUserToken(store, db.TokenPasswordReset, user, now, passwordResetTokenExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		msg := mail.Message{
+			To:      user.Email,
+			Subject: "Reset your procrast password",
+			Body:    fmt.Sprintf("Use this token to reset your password:\n\n%s\n\nIt expires in 1 hour.", token),
+		}
+		if err := mailer.Send(msg); err != nil {
+			log.Println("Failed to send password reset email:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func postResetPasswordHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Token    *string `json:"token,omitempty"`
+			Password *string `json:"password,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		if request.Token == nil || request.Password == nil || len(*request.Password) < minPasswordLength {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		token, err := store.UseUserToken(db.TokenPasswordReset, auth.HashOpaqueToken(*request.Token), now)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		user, err := store.FindUserByUUID(token.UserUUID.String())
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+			return
+		}
+
+		passHash, err := bcrypt.GenerateFromPassword([]byte(*request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		// receiving the reset email also proves the address, and the access
+		// tokens issued with the old password stop working
+		user.PassHash = passHash
+		user.Modified = now
+		user.NotBefore = now
+		if user.Verified == 0 {
+			user.Verified = now
+		}
+		if err := store.UpdateUser(user); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		// log out every session that used the old password
+		if err := store.RevokeUserRefreshTokens(user.UUID.String(), now); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func createUserToken(store db.UserStore, kind string, user models.User, now int64, expiry time.Duration) (string, error) {
+	token, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	userToken := models.UserToken{
+		Hash:     hash,
+		UserUUID: user.UUID,
+		Created:  now,
+		Expires:  now + int64(expiry.Seconds()),
+	}
+	if err := store.CreateUserToken(kind, userToken); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
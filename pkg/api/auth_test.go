@@ -0,0 +1,41 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+)
+
+func TestResetPasswordRevokesAccessTokens(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	other := a.newUser("other@example.com")
+	a.must(user, http.StatusOK, "GET", "/lists", nil, nil)
+
+	stored, err := a.store.FindUserByUUID(user.id)
+	if err != nil {
+		t.Fatalf("Failed to find user: %s", err)
+	}
+	token, err := createUserToken(a.store, db.TokenPasswordReset, stored, time.Now().UTC().Unix(), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create reset token: %s", err)
+	}
+
+	body, _ := json.Marshal(map[string]string{"token": token, "password": "a new password"})
+	r := httptest.NewRequest("POST", "/auth/v1/password/reset", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	a.api.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	if status := a.do(user, "GET", "/lists", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("access token from before the reset: expected %d, got %d", http.StatusUnauthorized, status)
+	}
+	a.must(other, http.StatusOK, "GET", "/lists", nil, nil)
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// boardColumn is a state of the list's workflow with the top level items in
+// that state as its cards, in list order.
+type boardColumn struct {
+	models.WorkflowState
+	Count     int           `json:"count"`
+	OverLimit bool          `json:"over_limit"`
+	Cards     []models.Item `json:"cards"`
+}
+
+// board is the response of getBoardHandler. Other has the cards in states
+// the workflow doesn't have.
+type board struct {
+	Columns []boardColumn `json:"columns"`
+	Other   []models.Item `json:"other"`
+}
+
+// listBoard groups the top level items of the list into its workflow's
+// columns. Subtasks are shown through their parent, not as cards.
+func listBoard(list models.List, items []models.Item) board {
+	states := list.States()
+	b := board{Columns: make([]boardColumn, len(states)), Other: make([]models.Item, 0)}
+
+	columns := make(map[uint8]int, len(states))
+	for i, s := range states {
+		b.Columns[i] = boardColumn{WorkflowState: s, Cards: make([]models.Item, 0)}
+		columns[s.Value] = i
+	}
+
+	for _, item := range items {
+		if item.ParentUUID != uuid.Nil {
+			continue
+		}
+
+		i, ok := columns[item.State]
+		if !ok {
+			b.Other = append(b.Other, item)
+			continue
+		}
+
+		c := &b.Columns[i]
+		c.Cards = append(c.Cards, item)
+		c.Count++
+		c.OverLimit = c.Limit > 0 && c.Count > c.Limit
+	}
+
+	return b
+}
+
+func getBoardHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		items, err := store.RetrieveAllItems(user, listId)
+		if err == nil {
+			err = markBlocked(store, user, items)
+		}
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, listBoard(list, items))
+	}
+}
+
+// postMoveCardHandler moves a card to a column of the board, directly before
+// or after another card in that column or to the end of it when neither is
+// given. The state and position change together and are recorded as one
+// ITEM MOVE. Columns that are at their limit don't take more cards.
+func postMoveCardHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		var request struct {
+			UUID   *uuid.UUID `json:"uuid,omitempty"`
+			State  *uint8     `json:"state,omitempty"`
+			Before *uuid.UUID `json:"before,omitempty"`
+			After  *uuid.UUID `json:"after,omitempty"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || request.UUID == nil || request.State == nil || (request.Before != nil && request.After != nil) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if !models.CanEdit(list.Role) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		column, ok := list.State(*request.State)
+		if !ok {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		items, err := store.RetrieveAllItems(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		var item *models.Item
+		cards := make([]uuid.UUID, 0)
+		for i := range items {
+			if items[i].UUID == *request.UUID {
+				item = &items[i]
+			} else if items[i].ParentUUID == uuid.Nil && items[i].State == column.Value {
+				cards = append(cards, items[i].UUID)
+			}
+		}
+
+		if item == nil || item.ParentUUID != uuid.Nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if item.State != column.Value && column.Limit > 0 && len(cards) >= column.Limit {
+			respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		}
+
+		target, after := uuid.Nil, true
+		if request.Before != nil {
+			target, after = *request.Before, false
+		} else if request.After != nil {
+			target = *request.After
+		} else if len(cards) > 0 {
+			target = cards[len(cards)-1]
+		}
+
+		if target != uuid.Nil && !containsUUID(cards, target) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		previous := *item
+		moved := *item
+		err = store.Transaction(func(tx db.Store) error {
+			if target == uuid.Nil {
+				if moved.Position, err = newItemPosition(tx, user, list.UUID); err != nil {
+					return err
+				}
+			} else {
+				moved.Position, _ = positionNextTo(itemSiblings(items, moved.UUID), target, after)
+			}
+
+			moved.Clock.Set("position", now)
+			if moved.State != column.Value {
+				moved.State = column.Value
+				moved.Modified = now
+				moved.Clock.Set("state", now)
+			}
+
+			if err := tx.UpdateItem(user, moved); err != nil {
+				return err
+			}
+
+			state := itemMoveState{
+				UUID:       moved.UUID,
+				ListUUID:   moved.ListUUID,
+				ParentUUID: moved.ParentUUID,
+				Position:   moved.Position,
+				State:      &moved.State,
+				Modified:   moved.Modified,
+			}
+			if err := createHistoryForState(tx, CmdItemMove, user, now, state, list.UUID); err != nil {
+				return err
+			}
+
+			if completesOccurrence(list, previous, moved) {
+				if _, err := spawnNextOccurrence(tx, user, moved, now); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, moved)
+	}
+}
+
+func containsUUID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
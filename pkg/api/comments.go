@@ -0,0 +1,325 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// Comments are returned a page at a time, ?limit= asks for up to
+// maxCommentLimit of them.
+const (
+	defaultCommentLimit = 50
+	maxCommentLimit     = 200
+)
+
+// commentState is the state of ITEM COMMENT UPDATE history entries, the
+// comment is edited at the entry's timestamp.
+type commentState struct {
+	UUID uuid.UUID `json:"uuid"`
+	Body string    `json:"body"`
+}
+
+func validCommentBody(body string) bool {
+	return strings.TrimSpace(body) != ""
+}
+
+// commentReplies returns the ids of the replies to the comment at any depth.
+func commentReplies(comments []models.Comment, id uuid.UUID) []uuid.UUID {
+	ids := make([]uuid.UUID, 0)
+	for i := -1; i < len(ids); i++ {
+		parent := id
+		if i >= 0 {
+			parent = ids[i]
+		}
+
+		for _, c := range comments {
+			if c.ParentUUID == parent {
+				ids = append(ids, c.UUID)
+			}
+		}
+	}
+	return ids
+}
+
+// repliesLast orders comments so that every reply comes after the comment it
+// answers, which the order they were written in only does for distinct times.
+func repliesLast(comments []models.Comment) []models.Comment {
+	ordered := make([]models.Comment, 0, len(comments))
+	added := make(map[uuid.UUID]bool, len(comments))
+	for len(ordered) < len(comments) {
+		progress := false
+		for _, c := range comments {
+			if added[c.UUID] || (c.ParentUUID != uuid.Nil && !added[c.ParentUUID]) {
+				continue
+			}
+
+			ordered = append(ordered, c)
+			added[c.UUID] = true
+			progress = true
+		}
+
+		if !progress {
+			break
+		}
+	}
+	return ordered
+}
+
+// deleteComment deletes the comment and its replies and tombstones them, so
+// offline replies and edits conflict instead of failing.
+func deleteComment(tx db.Store, user string, comment models.Comment, ts int64) error {
+	comments, err := tx.RetrieveComments(user, comment.ItemUUID.String(), "", 0)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range append(commentReplies(comments, comment.UUID), comment.UUID) {
+		if err := tx.CreateTombstone(user, id, ts); err != nil {
+			return err
+		}
+	}
+
+	return tx.DeleteComment(user, comment)
+}
+
+// findComment returns the comment if it is on the item.
+func findComment(store db.Store, user, itemId, commentId string) (models.Comment, bool) {
+	comment, err := store.RetrieveComment(user, commentId)
+	if err != nil || comment.ItemUUID.String() != itemId {
+		return models.Comment{}, false
+	}
+	return comment, true
+}
+
+// getCommentsHandler returns the comments on the item oldest first. Next is
+// set when there are more, and is passed as ?after= to get the next page.
+func getCommentsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+
+		limit := defaultCommentLimit
+		if param := r.URL.Query().Get("limit"); param != "" {
+			l, err := strconv.Atoi(param)
+			if err != nil || l < 1 || l > maxCommentLimit {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+			limit = l
+		}
+
+		if _, err := store.RetrieveItem(user, itemId); err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		after := r.URL.Query().Get("after")
+		if after != "" {
+			if _, ok := findComment(store, user, itemId, after); !ok {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+		}
+
+		comments, err := store.RetrieveComments(user, itemId, after, limit+1)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		next := ""
+		if len(comments) > limit {
+			comments = comments[:limit]
+			next = comments[limit-1].UUID.String()
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Comments []models.Comment `json:"comments"`
+			Next     string           `json:"next,omitempty"`
+		}{Comments: comments, Next: next})
+	}
+}
+
+// postCommentHandler comments on the item, or replies to the comment
+// `parent_uuid` on it. Members that can only view the list can't comment.
+func postCommentHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+
+		var request struct {
+			Body       string    `json:"body"`
+			ParentUUID uuid.UUID `json:"parent_uuid"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || !validCommentBody(request.Body) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if !canEdit(store, user, item.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		if request.ParentUUID != uuid.Nil {
+			if _, ok := findComment(store, user, itemId, request.ParentUUID.String()); !ok {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+		}
+
+		id, err := uuid.NewRandom()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		author, _ := uuid.Parse(user)
+		comment := models.Comment{
+			UUID:       id,
+			ItemUUID:   item.UUID,
+			ParentUUID: request.ParentUUID,
+			Author:     author,
+			Body:       request.Body,
+			Created:    now,
+		}
+
+		err = store.Transaction(func(tx db.Store) error {
+			if err := tx.CreateComment(user, comment); err != nil {
+				return err
+			}
+
+			return createHistoryForState(tx, CmdItemComment, user, now, comment, item.ListUUID)
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, comment)
+	}
+}
+
+// patchCommentHandler changes the `body` of a comment, only its author can.
+func patchCommentHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+		commentId := chi.URLParam(r, "commentId")
+
+		var request struct {
+			Body string `json:"body"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || !validCommentBody(request.Body) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		comment, ok := findComment(store, user, itemId, commentId)
+		if !ok {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if comment.Author.String() != user || !canEdit(store, user, item.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		if comment.Body == request.Body {
+			respondWithJSON(w, http.StatusOK, comment)
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		comment.Body = request.Body
+		comment.Edited = now
+
+		err = store.Transaction(func(tx db.Store) error {
+			if err := tx.UpdateComment(user, comment); err != nil {
+				return err
+			}
+
+			state := commentState{UUID: comment.UUID, Body: comment.Body}
+			return createHistoryForState(tx, CmdItemCommentUpdate, user, now, state, item.ListUUID)
+		})
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, comment)
+	}
+}
+
+// deleteCommentHandler deletes a comment and the replies to it, only its
+// author can.
+func deleteCommentHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+		commentId := chi.URLParam(r, "commentId")
+
+		comment, ok := findComment(store, user, itemId, commentId)
+		if !ok {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if comment.Author.String() != user || !canEdit(store, user, item.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := deleteComment(tx, user, comment, now); err != nil {
+				return err
+			}
+
+			state := uuidState{UUID: comment.UUID}
+			return createHistoryForState(tx, CmdItemCommentDelete, user, now, state, item.ListUUID)
+		})
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
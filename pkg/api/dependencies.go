@@ -0,0 +1,308 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// dependencyState is the state of ITEM BLOCK and ITEM UNBLOCK history
+// entries, the item is blocked by BlockerUUID.
+type dependencyState struct {
+	UUID        uuid.UUID `json:"uuid"`
+	BlockerUUID uuid.UUID `json:"blocker_uuid"`
+}
+
+// hasDependency reports whether item is already blocked by blocker.
+func hasDependency(dependencies []models.Dependency, item, blocker uuid.UUID) bool {
+	for _, d := range dependencies {
+		if d.ItemUUID == item && d.BlockerUUID == blocker {
+			return true
+		}
+	}
+	return false
+}
+
+// createsCycle reports whether blocking item on blocker would make a cycle,
+// which is when item already blocks blocker directly or through other items.
+// Dependencies of items in the trash count since the items can be restored.
+func createsCycle(dependencies []models.Dependency, item, blocker uuid.UUID) bool {
+	blockers := make(map[uuid.UUID][]uuid.UUID)
+	for _, d := range dependencies {
+		blockers[d.ItemUUID] = append(blockers[d.ItemUUID], d.BlockerUUID)
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	pending := []uuid.UUID{blocker}
+	for len(pending) > 0 {
+		id := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if id == item {
+			return true
+		}
+
+		if !seen[id] {
+			seen[id] = true
+			pending = append(pending, blockers[id]...)
+		}
+	}
+	return false
+}
+
+// liveItems loads the items referred to by dependencies once. Items that are
+// not live are stored as nil.
+type liveItems struct {
+	tx        db.Store
+	user      string
+	items     map[uuid.UUID]*models.Item
+	workflows *listWorkflows
+}
+
+func newLiveItems(tx db.Store, user string) *liveItems {
+	return &liveItems{
+		tx:        tx,
+		user:      user,
+		items:     make(map[uuid.UUID]*models.Item),
+		workflows: newListWorkflows(tx, user),
+	}
+}
+
+func (l *liveItems) get(id uuid.UUID) *models.Item {
+	if item, ok := l.items[id]; ok {
+		return item
+	}
+
+	var found *models.Item
+	if item, err := l.tx.RetrieveItem(l.user, id.String()); err == nil {
+		found = &item
+	}
+	l.items[id] = found
+	return found
+}
+
+// blocks reports whether the item blocks the items that depend on it, which
+// live items do until they are finished.
+func (l *liveItems) blocks(id uuid.UUID) bool {
+	item := l.get(id)
+	return item != nil && !l.workflows.finished(*item)
+}
+
+// markBlocked sets Blocked on the items that depend on an item that is not
+// finished.
+func markBlocked(tx db.Store, user string, items []models.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	dependencies, err := tx.RetrieveDependencies(user)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[uuid.UUID]int, len(items))
+	for i, item := range items {
+		index[item.UUID] = i
+	}
+
+	live := newLiveItems(tx, user)
+	for _, d := range dependencies {
+		if i, ok := index[d.ItemUUID]; ok && live.blocks(d.BlockerUUID) {
+			items[i].Blocked = true
+		}
+	}
+	return nil
+}
+
+// dependencyGraph is the response of getItemGraphHandler. Upstream are the
+// dependencies the item waits on directly or indirectly, downstream the ones
+// waiting on it. Items has every item in the graph, including the item itself.
+type dependencyGraph struct {
+	Items      []models.Item       `json:"items"`
+	Upstream   []models.Dependency `json:"upstream"`
+	Downstream []models.Dependency `json:"downstream"`
+}
+
+// walkDependencies follows the dependencies between live items from root,
+// towards blockers when upstream is set.
+func walkDependencies(dependencies []models.Dependency, live *liveItems, root uuid.UUID, upstream bool) []models.Dependency {
+	walked := make([]models.Dependency, 0)
+	seen := map[uuid.UUID]bool{root: true}
+	pending := []uuid.UUID{root}
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+
+		for _, d := range dependencies {
+			from, to := d.BlockerUUID, d.ItemUUID
+			if upstream {
+				from, to = d.ItemUUID, d.BlockerUUID
+			}
+
+			if from != id || live.get(to) == nil {
+				continue
+			}
+
+			walked = append(walked, d)
+			if !seen[to] {
+				seen[to] = true
+				pending = append(pending, to)
+			}
+		}
+	}
+	return walked
+}
+
+func getItemGraphHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		dependencies, err := store.RetrieveDependencies(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		live := newLiveItems(store, user)
+		graph := dependencyGraph{
+			Items:      []models.Item{item},
+			Upstream:   walkDependencies(dependencies, live, item.UUID, true),
+			Downstream: walkDependencies(dependencies, live, item.UUID, false),
+		}
+
+		seen := map[uuid.UUID]bool{item.UUID: true}
+		for _, d := range append(graph.Upstream, graph.Downstream...) {
+			for _, id := range []uuid.UUID{d.ItemUUID, d.BlockerUUID} {
+				if !seen[id] {
+					seen[id] = true
+					graph.Items = append(graph.Items, *live.get(id))
+				}
+			}
+		}
+
+		if err := markBlocked(store, user, graph.Items); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, graph)
+	}
+}
+
+// putItemBlockerHandler blocks the item on another item, which can be in any
+// of the user's lists. Dependencies that would make a cycle are rejected.
+func putItemBlockerHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+		blockerId := chi.URLParam(r, "blockerId")
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if !canEdit(store, user, item.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		blocker, err := store.RetrieveItem(user, blockerId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			dependencies, err := tx.RetrieveDependencies(user)
+			if err != nil || hasDependency(dependencies, item.UUID, blocker.UUID) {
+				return err
+			}
+
+			if createsCycle(dependencies, item.UUID, blocker.UUID) {
+				return errDependencyCycle
+			}
+
+			if err := tx.AddDependency(user, item.UUID, blocker.UUID, now); err != nil {
+				return err
+			}
+
+			state := dependencyState{UUID: item.UUID, BlockerUUID: blocker.UUID}
+			return createHistoryForState(tx, CmdItemBlock, user, now, state, item.ListUUID)
+		})
+
+		if err == errDependencyCycle {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func deleteItemBlockerHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+		blockerId := chi.URLParam(r, "blockerId")
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if !canEdit(store, user, item.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		blocker, err := uuid.Parse(blockerId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := tx.RemoveDependency(user, item.UUID, blocker); err != nil {
+				return err
+			}
+
+			state := dependencyState{UUID: item.UUID, BlockerUUID: blocker}
+			return createHistoryForState(tx, CmdItemUnblock, user, now, state, item.ListUUID)
+		})
+
+		if err == db.ErrNoAccess {
+			// the item is not blocked by it
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
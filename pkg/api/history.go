@@ -14,15 +14,45 @@ import (
 )
 
 var (
-	CmdListCreate = "LIST CREATE"
-	CmdListUpdate = "LIST UPDATE"
-	CmdListDelete = "LIST DELETE"
-	CmdItemCreate = "ITEM CREATE"
-	CmdItemUpdate = "ITEM UPDATE"
-	CmdItemDelete = "ITEM DELETE"
+	CmdListCreate   = "LIST CREATE"
+	CmdListUpdate   = "LIST UPDATE"
+	CmdListDelete   = "LIST DELETE"
+	CmdListRestore  = "LIST RESTORE"
+	CmdListMove     = "LIST MOVE"
+	CmdItemCreate   = "ITEM CREATE"
+	CmdItemUpdate   = "ITEM UPDATE"
+	CmdItemDelete   = "ITEM DELETE"
+	CmdItemRestore  = "ITEM RESTORE"
+	CmdItemMove     = "ITEM MOVE"
+	CmdItemTag      = "ITEM TAG"
+	CmdItemUntag    = "ITEM UNTAG"
+	CmdItemBlock    = "ITEM BLOCK"
+	CmdItemUnblock  = "ITEM UNBLOCK"
+	CmdItemAssign   = "ITEM ASSIGN"
+	CmdItemUnassign = "ITEM UNASSIGN"
+	CmdTagCreate    = "TAG CREATE"
+	CmdTagUpdate    = "TAG UPDATE"
+	CmdTagDelete    = "TAG DELETE"
+
+	CmdItemComment       = "ITEM COMMENT"
+	CmdItemCommentUpdate = "ITEM COMMENT UPDATE"
+	CmdItemCommentDelete = "ITEM COMMENT DELETE"
 )
 
-func getHistoryHandler(conn db.DB) http.HandlerFunc {
+// historyResult reports what happened to a submitted history entry. State is
+// the winning server state when the entry conflicted and Error is the code
+// when it failed.
+type historyResult struct {
+	UUID   uuid.UUID   `json:"uuid"`
+	Status string      `json:"status"`
+	State  interface{} `json:"state,omitempty"`
+	Error  string      `json:"error,omitempty"`
+
+	// the entry had already been submitted
+	Duplicate bool `json:"-"`
+}
+
+func getHistoryHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		since_param := r.URL.Query().Get("since")
@@ -37,7 +67,7 @@ func getHistoryHandler(conn db.DB) http.HandlerFunc {
 			since = s
 		}
 
-		history, err := db.GetHistorySince(conn, user, since)
+		history, err := store.GetHistorySince(user, since)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 			return
@@ -49,12 +79,16 @@ func getHistoryHandler(conn db.DB) http.HandlerFunc {
 	}
 }
 
-func postHistoryHandler(conn db.DB) http.HandlerFunc {
+// postHistoryHandler applies the submitted history entries and reports a
+// result for each one. Entries are applied independently unless the request
+// is atomic, in which case a failure rolls back the whole batch.
+func postHistoryHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 
 		var request struct {
 			History *[]models.History `json:"history,omitempty"`
+			Atomic  bool              `json:"atomic"`
 		}
 
 		err := json.NewDecoder(r.Body).Decode(&request)
@@ -69,138 +103,104 @@ func postHistoryHandler(conn db.DB) http.HandlerFunc {
 		}
 
 		now := time.Now().UTC().Unix()
-		processed := make([]uuid.UUID, 0)
-		for _, history := range *request.History {
-			err = db.Transaction(conn, func(tx db.Conn) error {
-				if _, err := db.GetHistory(tx, user, history.UUID); err == nil {
-					log.Println("Skipping: History already exists", history.UUID)
-					processed = append(processed, history.UUID)
-					return nil
+		entries := *request.History
+		results := make([]historyResult, len(entries))
+		status := http.StatusCreated
+
+		if request.Atomic {
+			failed := -1
+			err = store.Transaction(func(tx db.Store) error {
+				for i, history := range entries {
+					result, err := applyHistoryEntry(tx, user, history, now)
+					if err != nil {
+						failed = i
+						results[i] = failedResult(history.UUID, err)
+						return err
+					}
+					results[i] = result
 				}
+				return nil
+			})
 
-				history.Created = now
-				if err := db.CreateHistory(tx, user, history); err != nil {
-					return err
+			if err != nil {
+				log.Println("Failed to apply history batch:", err)
+				for i, history := range entries {
+					if i != failed {
+						results[i] = historyResult{UUID: history.UUID, Status: StatusAborted}
+					}
 				}
 
-				switch history.Command {
-				case CmdListCreate:
-					{
-						var state models.List
-						if err := json.Unmarshal(history.State, &state); err != nil {
-							return err
-						}
-
-						if err := db.CreateList(tx, user, state); err != nil {
-							return err
-						}
+				status = http.StatusUnprocessableEntity
+				if failed < 0 {
+					status = http.StatusInternalServerError
+				}
+			}
+		} else {
+			for i, history := range entries {
+				err = store.Transaction(func(tx db.Store) error {
+					result, err := applyHistoryEntry(tx, user, history, now)
+					results[i] = result
+					return err
+				})
 
-						processed = append(processed, state.UUID)
-					}
-				case CmdListUpdate:
-					{
-						var state models.List
-						if err := json.Unmarshal(history.State, &state); err != nil {
-							return err
-						}
-
-						list, err := db.RetrieveList(tx, user, state.UUID.String())
-						if err != nil {
-							return err
-						}
-
-						list.Title = state.Title
-						list.Description = state.Description
-						list.Modified = state.Modified
-						if err := db.UpdateList(tx, user, list); err != nil {
-							return err
-						}
-
-						processed = append(processed, state.UUID)
-					}
-				case CmdListDelete:
-					{
-						var state struct {
-							UUID uuid.UUID `json:"uuid"`
-						}
-						if err := json.Unmarshal(history.State, &state); err != nil {
-							return err
-						}
-
-						list, err := db.RetrieveList(conn, user, state.UUID.String())
-						if err != nil {
-							return err
-						}
-
-						if err := db.DeleteList(tx, user, list); err != nil {
-							return err
-						}
-
-						processed = append(processed, state.UUID)
-					}
-				case CmdItemCreate:
-					{
-						var state models.Item
-						if err := json.Unmarshal(history.State, &state); err != nil {
-							return err
-						}
-
-						if err := db.CreateItem(tx, state); err != nil {
-							return err
-						}
-
-						processed = append(processed, state.UUID)
-					}
-				case CmdItemUpdate:
-					{
-						var state models.Item
-						if err := json.Unmarshal(history.State, &state); err != nil {
-							return err
-						}
-
-						item, err := db.RetrieveItem(tx, user, state.UUID.String())
-						if err != nil {
-							return err
-						}
-
-						item.Title = state.Title
-						item.Description = state.Description
-						item.State = state.State
-						item.Modified = state.Modified
-						if err := db.UpdateItem(tx, item); err != nil {
-							return err
-						}
-
-						processed = append(processed, state.UUID)
-					}
-				case CmdItemDelete:
-					{
-						var state struct {
-							UUID uuid.UUID `json:"uuid"`
-						}
-						if err := json.Unmarshal(history.State, &state); err != nil {
-							return err
-						}
-
-						item, err := db.RetrieveItem(conn, user, state.UUID.String())
-						if err != nil {
-							return err
-						}
-
-						if err := db.DeleteItem(tx, item); err != nil {
-							return err
-						}
-
-						processed = append(processed, state.UUID)
-					}
+				if err != nil {
+					results[i] = failedResult(history.UUID, err)
 				}
+			}
+		}
 
-				return nil
-			})
+		processed := make([]uuid.UUID, 0)
+		for i, result := range results {
+			switch {
+			case result.Status == StatusFailed || result.Status == StatusAborted:
+			case result.Duplicate:
+				processed = append(processed, result.UUID)
+			default:
+				if entity, ok := entityUUID(entries[i]); ok {
+					processed = append(processed, entity)
+				}
+			}
 		}
 
-		respondWithJSON(w, http.StatusCreated, struct {
-			Processed []uuid.UUID `json:"processed"`
-		}{Processed: processed})
+		respondWithJSON(w, status, struct {
+			Processed []uuid.UUID     `json:"processed"`
+			Results   []historyResult `json:"results"`
+		}{Processed: processed, Results: results})
+	}
+}
+
+func applyHistoryEntry(tx db.Store, user string, history models.History, now int64) (historyResult, error) {
+	if _, err := tx.GetHistory(user, history.UUID); err == nil {
+		log.Println("Skipping: History already exists", history.UUID)
+		return historyResult{UUID: history.UUID, Status: StatusSkipped, Duplicate: true}, nil
+	}
+
+	history.Created = now
+	status, server, err := applyHistory(tx, user, history)
+	if err != nil {
+		return historyResult{}, err
+	}
+
+	result := historyResult{UUID: history.UUID, Status: status}
+	if status == StatusConflicted {
+		result.State = server
+	}
+	return result, nil
+}
+
+func failedResult(id uuid.UUID, err error) historyResult {
+	code := errorCode(err)
+	if code == ErrCodeInternal {
+		log.Println("Failed to apply history", id, err)
+	}
+	return historyResult{UUID: id, Status: StatusFailed, Error: code}
+}
+
+// entityUUID returns the uuid of the list or item a history entry refers to.
+func entityUUID(history models.History) (uuid.UUID, bool) {
+	var state uuidState
+	if err := json.Unmarshal(history.State, &state); err != nil {
+		return uuid.Nil, false
 	}
+	return state.UUID, true
 }
@@ -13,17 +13,21 @@ import (
 	"github.com/google/uuid"
 )
 
-func getItemsHandler(conn db.DB) http.HandlerFunc {
+func getItemsHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		listId := chi.URLParam(r, "listId")
 
-		if _, err := db.RetrieveList(conn, user, listId); err != nil {
+		if _, err := store.RetrieveList(user, listId); err != nil {
 			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
 		}
 
-		items, err := db.RetrieveAllItems(conn, user, listId)
+		items, err := store.RetrieveAllItems(user, listId)
+		if err == nil {
+			err = markBlocked(store, user, items)
+		}
+
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 			return
@@ -35,29 +39,49 @@ func getItemsHandler(conn db.DB) http.HandlerFunc {
 	}
 }
 
-func postItemHandler(conn db.DB) http.HandlerFunc {
+func postItemHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		listId := chi.URLParam(r, "listId")
 		now := time.Now().UTC().Unix()
 
-		list, err := db.RetrieveList(conn, user, listId)
+		list, err := store.RetrieveList(user, listId)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
 		}
 
+		if !models.CanEdit(list.Role) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
 		var request struct {
-			Title       *string `json:"title,omitempty"`
-			Description string  `json:"description"`
-			State       uint8   `json:"state"`
+			Title       *string   `json:"title,omitempty"`
+			Description string    `json:"description"`
+			State       *uint8    `json:"state,omitempty"`
+			Due         int64     `json:"due"`
+			Start       int64     `json:"start"`
+			RRule       string    `json:"rrule"`
+			ParentUUID  uuid.UUID `json:"parent_uuid"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
 			return
 		}
 
-		if request.Title == nil {
+		if request.Title == nil || !validRRule(request.RRule) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		// items start in the first state of the workflow unless told otherwise
+		state := initialState(list)
+		if request.State != nil {
+			state = *request.State
+		}
+
+		if _, ok := list.State(state); !ok {
 			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
 			return
 		}
@@ -72,18 +96,35 @@ func postItemHandler(conn db.DB) http.HandlerFunc {
 			UUID:        id,
 			Title:       *request.Title,
 			Description: request.Description,
-			State:       request.State,
+			State:       state,
 			Created:     now,
 			Modified:    now,
 			ListUUID:    list.UUID,
+			ParentUUID:  request.ParentUUID,
+			Due:         request.Due,
+			Start:       request.Start,
+			RRule:       request.RRule,
+		}
+
+		if item.RRule != "" {
+			item.Occurrence = 1
 		}
 
-		err = db.Transaction(conn, func(conn db.Conn) error {
-			if err := db.CreateItem(conn, item); err != nil {
+		if err := checkParent(store, user, item); err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		err = store.Transaction(func(tx db.Store) error {
+			if item.Position, err = newItemPosition(tx, user, list.UUID); err != nil {
+				return err
+			}
+
+			if err := tx.CreateItem(user, item); err != nil {
 				return err
 			}
 
-			if err := createHistoryForState(conn, CmdItemCreate, user, now, item); err != nil {
+			if err := createHistoryForState(tx, CmdItemCreate, user, now, item, item.ListUUID); err != nil {
 				return err
 			}
 			return nil
@@ -99,72 +140,179 @@ func postItemHandler(conn db.DB) http.HandlerFunc {
 	}
 }
 
-func getItemHandler(conn db.DB) http.HandlerFunc {
+func getItemHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		itemId := chi.URLParam(r, "itemId")
 
-		item, err := db.RetrieveItem(conn, user, itemId)
+		item, err := store.RetrieveItem(user, itemId)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, item)
+		items := []models.Item{item}
+		if err := markBlocked(store, user, items); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, items[0])
 	}
 }
 
-func patchItemHandler(conn db.DB) http.HandlerFunc {
+func patchItemHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		itemId := chi.URLParam(r, "itemId")
 
 		var request struct {
-			Title       *string `json:"title,omitempty"`
-			Description *string `json:"description,omitempty"`
-			State       *uint8  `json:"state,omitempty"`
+			Title       *string    `json:"title,omitempty"`
+			Description *string    `json:"description,omitempty"`
+			State       *uint8     `json:"state,omitempty"`
+			Due         *int64     `json:"due,omitempty"`
+			Start       *int64     `json:"start,omitempty"`
+			RRule       *string    `json:"rrule,omitempty"`
+			ListUUID    *uuid.UUID `json:"list_uuid,omitempty"`
+			ParentUUID  *uuid.UUID `json:"parent_uuid,omitempty"`
 		}
 		err := json.NewDecoder(r.Body).Decode(&request)
-		if err != nil {
+		if err != nil || (request.RRule != nil && !validRRule(*request.RRule)) {
 			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
 			return
 		}
 
-		item, err := db.RetrieveItem(conn, user, itemId)
+		item, err := store.RetrieveItem(user, itemId)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
 		}
 
+		now := time.Now().UTC().Unix()
+		previous := item
 		updated := false
 		if request.Title != nil {
 			item.Title = *request.Title
+			item.Clock.Set("title", now)
 			updated = true
 		}
 
 		if request.Description != nil {
 			item.Description = *request.Description
+			item.Clock.Set("description", now)
 			updated = true
 		}
 
 		if request.State != nil {
 			item.State = *request.State
+			item.Clock.Set("state", now)
 			updated = true
 		}
 
+		// 0 clears the date
+		if request.Due != nil {
+			item.Due = *request.Due
+			item.Clock.Set("due", now)
+			updated = true
+		}
+
+		if request.Start != nil {
+			item.Start = *request.Start
+			item.Clock.Set("start", now)
+			updated = true
+		}
+
+		// an empty rule stops the item repeating
+		if request.RRule != nil {
+			item.RRule = *request.RRule
+			item.Clock.Set("rrule", now)
+			updated = true
+		}
+
+		// moving the item to another list or parent puts it at the end of the
+		// list, a subtask moved to another list on its own becomes top level
+		moved := false
+		list, err := store.RetrieveList(user, item.ListUUID.String())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		if !models.CanEdit(list.Role) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		if request.ListUUID != nil && *request.ListUUID != item.ListUUID {
+			if list, err = store.RetrieveList(user, request.ListUUID.String()); err != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+
+			if !models.CanEdit(list.Role) {
+				respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+				return
+			}
+
+			item.ListUUID = *request.ListUUID
+			item.ParentUUID = uuid.Nil
+			moved = true
+		}
+
+		if request.ParentUUID != nil && *request.ParentUUID != item.ParentUUID {
+			item.ParentUUID = *request.ParentUUID
+			moved = true
+		}
+
+		// a new state has to be in the workflow of the list the item ends up
+		// in, items moved to a list without their state keep it until changed
+		if _, ok := list.State(item.State); !ok && request.State != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		if moved {
+			if err := checkParent(store, user, item); err != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+
+			if item.Position, err = newItemPosition(store, user, item.ListUUID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+		}
+
 		if updated {
-			now := time.Now().UTC().Unix()
 			item.Modified = now
+		}
 
-			err = db.Transaction(conn, func(tx db.Conn) error {
-				if err = db.UpdateItem(tx, item); err != nil {
+		if updated || moved {
+			err = store.Transaction(func(tx db.Store) error {
+				if moved {
+					if err := moveItem(tx, user, &item, now); err != nil {
+						return err
+					}
+				}
+
+				if !updated {
+					return nil
+				}
+
+				if err = tx.UpdateItem(user, item); err != nil {
 					return err
 				}
 
-				if err := createHistoryForState(conn, CmdItemUpdate, user, now, item); err != nil {
+				if err := createHistoryForState(tx, CmdItemUpdate, user, now, item, item.ListUUID); err != nil {
 					return err
 				}
 
+				if completesOccurrence(list, previous, item) {
+					if _, err := spawnNextOccurrence(tx, user, item, now); err != nil {
+						return err
+					}
+				}
+
 				return nil
 			})
 
@@ -178,28 +326,31 @@ func patchItemHandler(conn db.DB) http.HandlerFunc {
 	}
 }
 
-func deleteItemHandler(conn db.DB) http.HandlerFunc {
+func deleteItemHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		itemId := chi.URLParam(r, "itemId")
 		now := time.Now().UTC().Unix()
 
-		item, err := db.RetrieveItem(conn, user, itemId)
+		item, err := store.RetrieveItem(user, itemId)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
 		}
 
-		err = db.Transaction(conn, func(tx db.Conn) error {
-			if err := db.DeleteItem(conn, item); err != nil {
+		if !canEdit(store, user, item.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		err = store.Transaction(func(tx db.Store) error {
+			if err := deleteItem(tx, user, item, now); err != nil {
 				return err
 			}
 
-			state := struct {
-				UUID uuid.UUID `json:"uuid"`
-			}{UUID: item.UUID}
+			state := uuidState{UUID: item.UUID}
 
-			if err := createHistoryForState(conn, CmdItemDelete, user, now, state); err != nil {
+			if err := createHistoryForState(tx, CmdItemDelete, user, now, state, item.ListUUID); err != nil {
 				return err
 			}
 
@@ -214,3 +365,46 @@ func deleteItemHandler(conn db.DB) http.HandlerFunc {
 		respondWithJSON(w, http.StatusNoContent, nil)
 	}
 }
+
+// postRestoreItemHandler takes an item out of the trash with its subtasks.
+// Items in a list or under a parent that is in the trash are restored with it.
+func postRestoreItemHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+
+		item, err := store.RetrieveTrashedItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if !restorable(store, user, item) {
+			respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := restoreItem(tx, user, item); err != nil {
+				return err
+			}
+
+			state := uuidState{UUID: item.UUID}
+			if err := createHistoryForState(tx, CmdItemRestore, user, now, state, item.ListUUID); err != nil {
+				return err
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		item.Deleted = 0
+		respondWithJSON(w, http.StatusOK, item)
+	}
+}
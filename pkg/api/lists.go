@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"ismacaulay/procrast-api/pkg/db"
@@ -13,30 +14,43 @@ import (
 	"github.com/google/uuid"
 )
 
-func getListsHandler(conn db.DB) http.HandlerFunc {
+// getListsHandler returns the lists of the active workspace, or the lists
+// outside of any workspace when there is none.
+func getListsHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
+		workspace := activeWorkspace(r)
 
-		lists, err := db.RetrieveAllLists(conn, user)
+		lists, err := store.RetrieveAllLists(user)
 		if err != nil {
 			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
 			return
 		}
 
+		filtered := make([]models.List, 0, len(lists))
+		for _, l := range lists {
+			if l.WorkspaceUUID == workspace {
+				filtered = append(filtered, l)
+			}
+		}
+
 		respondWithJSON(w, http.StatusOK, struct {
 			Lists []models.List `json:"lists"`
-		}{Lists: lists})
+		}{Lists: filtered})
 	}
 }
 
-func postListHandler(conn db.DB) http.HandlerFunc {
+// postListHandler creates a list in the active workspace, which takes a member
+// that can edit, or a list of the user's own when there is none.
+func postListHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		now := time.Now().UTC().Unix()
 
 		var request struct {
-			Title       *string `json:"title,omitempty"`
-			Description string  `json:"description"`
+			Title       *string                `json:"title,omitempty"`
+			Description string                 `json:"description"`
+			Workflow    []models.WorkflowState `json:"workflow,omitempty"`
 		}
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
@@ -44,7 +58,8 @@ func postListHandler(conn db.DB) http.HandlerFunc {
 			return
 		}
 
-		if request.Title == nil {
+		// lists without a workflow use the default one
+		if request.Title == nil || (request.Workflow != nil && !validWorkflow(request.Workflow)) {
 			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
 			return
 		}
@@ -56,15 +71,37 @@ func postListHandler(conn db.DB) http.HandlerFunc {
 		}
 
 		list := models.List{
-			UUID:        id,
-			Title:       *request.Title,
-			Description: request.Description,
-			Created:     now,
-			Modified:    now,
+			UUID:          id,
+			Title:         *request.Title,
+			Description:   request.Description,
+			Workflow:      request.Workflow,
+			Role:          models.RoleOwner,
+			WorkspaceUUID: activeWorkspace(r),
+			Created:       now,
+			Modified:      now,
+		}
+
+		if list.Workflow == nil {
+			list.Workflow = append([]models.WorkflowState(nil), models.DefaultWorkflow...)
+		}
+
+		if list.WorkspaceUUID != uuid.Nil {
+			workspace, err := store.RetrieveWorkspace(user, list.WorkspaceUUID.String())
+			if err != nil || !models.CanEdit(workspace.Role) {
+				respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+				return
+			}
+
+			// workspace lists are owned by the workspace's owners
+			list.Role = workspace.Role
 		}
 
-		err = db.Transaction(conn, func(tx db.Conn) error {
-			if err := db.CreateList(tx, user, list); err != nil {
+		err = store.Transaction(func(tx db.Store) error {
+			if list.Position, err = newListPosition(tx, user); err != nil {
+				return err
+			}
+
+			if err := tx.CreateList(user, list); err != nil {
 				return err
 			}
 
@@ -72,6 +109,10 @@ func postListHandler(conn db.DB) http.HandlerFunc {
 				return err
 			}
 
+			if list.WorkspaceUUID != uuid.Nil {
+				return addWorkspaceMembers(tx, user, list, now)
+			}
+
 			return nil
 		})
 
@@ -85,12 +126,12 @@ func postListHandler(conn db.DB) http.HandlerFunc {
 	}
 }
 
-func getListHandler(conn db.DB) http.HandlerFunc {
+func getListHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		listId := chi.URLParam(r, "listId")
 
-		list, err := db.RetrieveList(conn, user, listId)
+		list, err := store.RetrieveList(user, listId)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
@@ -100,48 +141,64 @@ func getListHandler(conn db.DB) http.HandlerFunc {
 	}
 }
 
-func patchListHandler(conn db.DB) http.HandlerFunc {
+func patchListHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		listId := chi.URLParam(r, "listId")
 
 		var request struct {
-			Title       *string `json:"title,omitempty"`
-			Description *string `json:"description,omitempty"`
+			Title       *string                `json:"title,omitempty"`
+			Description *string                `json:"description,omitempty"`
+			Workflow    []models.WorkflowState `json:"workflow,omitempty"`
 		}
 		err := json.NewDecoder(r.Body).Decode(&request)
-		if err != nil {
+		if err != nil || (request.Workflow != nil && !validWorkflow(request.Workflow)) {
 			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
 			return
 		}
 
-		list, err := db.RetrieveList(conn, user, listId)
+		list, err := store.RetrieveList(user, listId)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
 		}
 
+		if !models.CanEdit(list.Role) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
 		update := false
 		if request.Title != nil {
 			list.Title = *request.Title
+			list.Clock.Set("title", now)
 			update = true
 		}
 
 		if request.Description != nil {
 			list.Description = *request.Description
+			list.Clock.Set("description", now)
+			update = true
+		}
+
+		// items in states the new workflow no longer has keep them until they
+		// are changed
+		if request.Workflow != nil {
+			list.Workflow = request.Workflow
+			list.Clock.Set("workflow", now)
 			update = true
 		}
 
 		if update {
-			now := time.Now().UTC().Unix()
 			list.Modified = now
 
-			err = db.Transaction(conn, func(tx db.Conn) error {
-				if err = db.UpdateList(tx, user, list); err != nil {
+			err = store.Transaction(func(tx db.Store) error {
+				if err = tx.UpdateList(user, list); err != nil {
 					return err
 				}
 
-				if err := createHistoryForState(tx, CmdListUpdate, user, now, list); err != nil {
+				if err := createHistoryForState(tx, CmdListUpdate, user, now, list, list.UUID); err != nil {
 					return err
 				}
 
@@ -159,27 +216,30 @@ func patchListHandler(conn db.DB) http.HandlerFunc {
 	}
 }
 
-func deleteListHandler(conn db.DB) http.HandlerFunc {
+func deleteListHandler(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value("user").(string)
 		listId := chi.URLParam(r, "listId")
-		list, err := db.RetrieveList(conn, user, listId)
+		list, err := store.RetrieveList(user, listId)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			return
 		}
 
-		err = db.Transaction(conn, func(tx db.Conn) error {
-			if err := db.DeleteList(tx, user, list); err != nil {
+		// members other than the owner leave the list instead
+		if list.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := deleteList(tx, user, list, now); err != nil {
 				return err
 			}
 
-			state := struct {
-				UUID uuid.UUID `json:"uuid"`
-			}{UUID: list.UUID}
-
-			now := time.Now().UTC().Unix()
-			if err := createHistoryForState(tx, CmdListDelete, user, now, state); err != nil {
+			state := uuidState{UUID: list.UUID}
+			if err := createHistoryForState(tx, CmdListDelete, user, now, state, list.UUID); err != nil {
 				return err
 			}
 
@@ -194,3 +254,194 @@ func deleteListHandler(conn db.DB) http.HandlerFunc {
 		respondWithJSON(w, http.StatusNoContent, nil)
 	}
 }
+
+// postRestoreListHandler takes a list and the items deleted with it out of the
+// trash. With ?at= the list is restored as it was at that time instead.
+func postRestoreListHandler(store db.Store) http.HandlerFunc {
+	restoreAt := restoreListAtHandler(store)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("at") != "" {
+			restoreAt(w, r)
+			return
+		}
+
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		list, err := store.RetrieveTrashedList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := restoreList(tx, user, list); err != nil {
+				return err
+			}
+
+			state := uuidState{UUID: list.UUID}
+			if err := createHistoryForState(tx, CmdListRestore, user, now, state, list.UUID); err != nil {
+				return err
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		list.Deleted = 0
+		respondWithJSON(w, http.StatusOK, list)
+	}
+}
+
+// restoreListAtHandler recreates a deleted list and its items as they were
+// at the given time by replaying the user's history up to it. The restore is
+// recorded as new history entries so other devices pick it up. A copy of the
+// list still in the trash is replaced.
+func restoreListAtHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		at, err := strconv.ParseInt(r.URL.Query().Get("at"), 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		if _, err := store.RetrieveList(user, listId); err == nil {
+			respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		}
+
+		history, err := store.GetHistorySince(user, 0)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		// the history is in the order it was applied, so entries are replayed
+		// after the lists and items they depend on even when they were all
+		// synced in the same second
+		until := make([]models.History, 0, len(history))
+		for _, entry := range history {
+			if entry.Timestamp <= at {
+				until = append(until, entry)
+			}
+		}
+
+		snapshot := db.NewMemoryStore()
+		if _, err := Replay(snapshot, user, until); err != nil {
+			log.Println("Failed to replay history:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		list, err := snapshot.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		items, err := snapshot.RetrieveAllItems(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		// a list of a workspace the user has left comes back as their own
+		if list.WorkspaceUUID != uuid.Nil && !inWorkspace(store, user, list.WorkspaceUUID) {
+			list.WorkspaceUUID = uuid.Nil
+		}
+
+		now := time.Now().UTC().Unix()
+		restored := make(map[uuid.UUID]bool, len(items))
+		err = store.Transaction(func(tx db.Store) error {
+			if trashed, err := tx.RetrieveTrashedList(user, listId); err == nil {
+				if err := tx.DeleteList(user, trashed); err != nil {
+					return err
+				}
+			}
+
+			list.Modified = now
+			if err := tx.DeleteTombstone(user, list.UUID); err != nil {
+				return err
+			}
+
+			if err := tx.CreateList(user, list); err != nil {
+				return err
+			}
+
+			if err := createHistoryForState(tx, CmdListCreate, user, now, list); err != nil {
+				return err
+			}
+
+			if list.WorkspaceUUID != uuid.Nil {
+				if err := addWorkspaceMembers(tx, user, list, now); err != nil {
+					return err
+				}
+			}
+
+			// items are ordered by position, subtasks can come before their
+			// parent which has to be created first
+			drift := make([]ItemDrift, len(items))
+			for i := range items {
+				drift[i] = ItemDrift{UUID: items[i].UUID, Replayed: &items[i]}
+			}
+
+			for _, d := range parentsFirst(drift) {
+				item := d.Replayed
+				if _, err := tx.RetrieveItem(user, item.UUID.String()); err == nil {
+					// the item still exists somewhere else
+					continue
+				}
+
+				// a subtask whose parent wasn't restored with it, because the
+				// parent has since been moved to another list, becomes a top
+				// level item
+				if item.ParentUUID != uuid.Nil && !restored[item.ParentUUID] {
+					item.ParentUUID = uuid.Nil
+				}
+
+				item.Modified = now
+				if err := tx.DeleteTombstone(user, item.UUID); err != nil {
+					return err
+				}
+
+				if err := tx.CreateItem(user, *item); err != nil {
+					return err
+				}
+
+				if err := createHistoryForState(tx, CmdItemCreate, user, now, item); err != nil {
+					return err
+				}
+				restored[item.UUID] = true
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		restoredItems := make([]models.Item, 0, len(restored))
+		for _, item := range items {
+			if restored[item.UUID] {
+				restoredItems = append(restoredItems, item)
+			}
+		}
+
+		respondWithJSON(w, http.StatusCreated, struct {
+			List  models.List   `json:"list"`
+			Items []models.Item `json:"items"`
+		}{List: list, Items: restoredItems})
+	}
+}
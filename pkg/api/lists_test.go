@@ -0,0 +1,148 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+func TestRestoreListAtSubtaskBeforeParent(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	ts := time.Now().UTC().Unix() - 60
+
+	list := models.List{UUID: uuid.New(), Title: "list", Position: "m"}
+	parent := models.Item{UUID: uuid.New(), Title: "parent", ListUUID: list.UUID, Position: "m"}
+	subtask := models.Item{UUID: uuid.New(), Title: "subtask", ListUUID: list.UUID, Position: "a"}
+
+	// one batch, so every entry is created in the same second, where the
+	// subtask is created before its parent and moved under it afterwards and
+	// the timestamps run backwards
+	batch := []models.History{
+		historyEntry(CmdListCreate, list, ts),
+		historyEntry(CmdItemCreate, subtask, ts-1),
+		historyEntry(CmdItemCreate, parent, ts-2),
+		historyEntry(CmdItemMove, itemMoveState{UUID: subtask.UUID, ListUUID: list.UUID, ParentUUID: parent.UUID, Position: "a"}, ts-3),
+	}
+	var response historyResponse
+	a.must(user, http.StatusCreated, "POST", "/history", map[string]interface{}{"history": batch, "atomic": true}, &response)
+	for i, result := range response.Results {
+		if result.Status != StatusApplied {
+			t.Fatalf("%s was not applied: %+v", batch[i].Command, result)
+		}
+	}
+
+	a.must(user, http.StatusNoContent, "DELETE", "/lists/"+list.UUID.String(), nil, nil)
+
+	var restored struct {
+		List  models.List   `json:"list"`
+		Items []models.Item `json:"items"`
+	}
+	path := fmt.Sprintf("/lists/%s/restore?at=%d", list.UUID, ts)
+	a.must(user, http.StatusCreated, "POST", path, nil, &restored)
+
+	if len(restored.Items) != 2 || restored.Items[0].UUID != subtask.UUID || restored.Items[1].UUID != parent.UUID {
+		t.Fatalf("expected the subtask and its parent in position order, got %+v", restored.Items)
+	}
+
+	item, err := a.store.RetrieveItem(user.id, subtask.UUID.String())
+	if err != nil || item.ParentUUID != parent.UUID {
+		t.Errorf("the subtask was not restored under its parent: %+v %v", item, err)
+	}
+}
+
+func TestRestoreListAtSubtaskWithoutParent(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	created := time.Now().UTC().Unix() - 120
+	changed := created + 60
+
+	list := models.List{UUID: uuid.New(), Title: "list", Position: "m"}
+	other := models.List{UUID: uuid.New(), Title: "other", Position: "n"}
+	parent := models.Item{UUID: uuid.New(), Title: "parent", ListUUID: list.UUID, Position: "m"}
+	subtask := models.Item{UUID: uuid.New(), Title: "subtask", ListUUID: list.UUID, ParentUUID: parent.UUID, Position: "n"}
+	for _, entry := range []models.History{
+		historyEntry(CmdListCreate, list, created),
+		historyEntry(CmdListCreate, other, created),
+		historyEntry(CmdItemCreate, parent, created),
+		historyEntry(CmdItemCreate, subtask, created),
+		// the subtask is taken out of its parent, which is moved to the
+		// other list, and the subtask is deleted with the list
+		historyEntry(CmdItemMove, map[string]interface{}{"uuid": subtask.UUID, "list_uuid": list.UUID, "parent_uuid": nil, "position": "n"}, changed),
+		historyEntry(CmdItemMove, itemMoveState{UUID: parent.UUID, ListUUID: other.UUID, Position: "m"}, changed),
+	} {
+		if result := a.sync(user, entry); result.Status != StatusApplied {
+			t.Fatalf("%s was not applied: %+v", entry.Command, result)
+		}
+	}
+
+	a.must(user, http.StatusNoContent, "DELETE", "/lists/"+list.UUID.String(), nil, nil)
+
+	var restored struct {
+		Items []models.Item `json:"items"`
+	}
+	path := fmt.Sprintf("/lists/%s/restore?at=%d", list.UUID, created)
+	a.must(user, http.StatusCreated, "POST", path, nil, &restored)
+
+	if len(restored.Items) != 1 || restored.Items[0].UUID != subtask.UUID || restored.Items[0].ParentUUID != uuid.Nil {
+		t.Fatalf("expected the subtask as a top level item, got %+v", restored.Items)
+	}
+
+	item, err := a.store.RetrieveItem(user.id, parent.UUID.String())
+	if err != nil || item.ListUUID != other.UUID {
+		t.Errorf("the parent was not left in the other list: %+v %v", item, err)
+	}
+}
+
+func TestRestoreListAt(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	created := time.Now().UTC().Unix() - 120
+	changed := created + 60
+
+	list := models.List{UUID: uuid.New(), Title: "list", Position: "m"}
+	item := models.Item{UUID: uuid.New(), Title: "before", ListUUID: list.UUID, Position: "m"}
+	later := models.Item{UUID: uuid.New(), Title: "later", ListUUID: list.UUID, Position: "n"}
+	for _, entry := range []models.History{
+		historyEntry(CmdListCreate, list, created),
+		historyEntry(CmdItemCreate, item, created),
+		historyEntry(CmdItemUpdate, map[string]interface{}{"uuid": item.UUID, "title": "after"}, changed),
+		historyEntry(CmdItemCreate, later, changed),
+	} {
+		if result := a.sync(user, entry); result.Status != StatusApplied {
+			t.Fatalf("%s was not applied: %+v", entry.Command, result)
+		}
+	}
+
+	path := fmt.Sprintf("/lists/%s/restore?at=%d", list.UUID, created)
+	if status := a.do(user, "POST", path, nil, nil); status != http.StatusConflict {
+		t.Errorf("restoring a list that exists: expected %d, got %d", http.StatusConflict, status)
+	}
+
+	a.must(user, http.StatusNoContent, "DELETE", "/lists/"+list.UUID.String(), nil, nil)
+
+	if status := a.do(user, "POST", fmt.Sprintf("/lists/%s/restore?at=%d", uuid.New(), created), nil, nil); status != http.StatusNotFound {
+		t.Errorf("restoring an unknown list: expected %d, got %d", http.StatusNotFound, status)
+	}
+	if status := a.do(user, "POST", fmt.Sprintf("/lists/%s/restore?at=%d", list.UUID, created-60), nil, nil); status != http.StatusNotFound {
+		t.Errorf("restoring from before the list existed: expected %d, got %d", http.StatusNotFound, status)
+	}
+
+	var restored struct {
+		List  models.List   `json:"list"`
+		Items []models.Item `json:"items"`
+	}
+	a.must(user, http.StatusCreated, "POST", path, nil, &restored)
+
+	if restored.List.Title != "list" || len(restored.Items) != 1 || restored.Items[0].Title != "before" {
+		t.Errorf("expected the list as it was when created, got %+v", restored)
+	}
+	if got := a.itemTitles(user, list); got != "before" {
+		t.Errorf("expected the restored item in the list, got %s", got)
+	}
+}
@@ -0,0 +1,597 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/mail"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// canEdit reports whether the user can change the list and its items.
+func canEdit(tx db.Store, user string, list uuid.UUID) bool {
+	l, err := tx.RetrieveList(user, list.String())
+	return err == nil && models.CanEdit(l.Role)
+}
+
+// validMemberRole reports whether a member can be given the role, a list only
+// ever has the one owner.
+func validMemberRole(role string) bool {
+	return role == models.RoleEditor || role == models.RoleViewer
+}
+
+// shareHistory copies a history entry of the user to the other members of the
+// lists, so changes to a shared list show up in every member's history. Each
+// copy gets its own uuid.
+func shareHistory(tx db.Store, user string, history models.History, lists ...uuid.UUID) error {
+	shared := map[string]bool{user: true}
+	for _, list := range lists {
+		if list == uuid.Nil {
+			continue
+		}
+
+		members, err := tx.RetrieveMembers(user, list.String())
+		if err != nil {
+			return err
+		}
+
+		for _, m := range members {
+			member := m.UserUUID.String()
+			if shared[member] {
+				continue
+			}
+			shared[member] = true
+
+			entry := history
+			if entry.UUID, err = uuid.NewRandom(); err != nil {
+				return err
+			}
+
+			if err := tx.CreateHistory(member, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// historyLists returns the lists whose members see a history entry submitted
+// by the user. It has to be called before the entry is applied, while deleted
+// items can still be found. Tags are personal and list moves only change the
+// user's own order, those entries are not shared.
+func historyLists(tx db.Store, user string, history models.History) []uuid.UUID {
+	var state struct {
+		UUID     uuid.UUID `json:"uuid"`
+		ListUUID uuid.UUID `json:"list_uuid"`
+		ItemUUID uuid.UUID `json:"item_uuid"`
+	}
+	if err := json.Unmarshal(history.State, &state); err != nil {
+		return nil
+	}
+
+	listOf := func(id uuid.UUID) uuid.UUID {
+		if item, err := tx.RetrieveItem(user, id.String()); err == nil {
+			return item.ListUUID
+		}
+		if item, err := tx.RetrieveTrashedItem(user, id.String()); err == nil {
+			return item.ListUUID
+		}
+		return uuid.Nil
+	}
+
+	switch history.Command {
+	case CmdListUpdate, CmdListDelete, CmdListRestore:
+		return []uuid.UUID{state.UUID}
+	case CmdItemCreate:
+		return []uuid.UUID{state.ListUUID}
+	case CmdItemUpdate, CmdItemDelete, CmdItemRestore, CmdItemBlock, CmdItemUnblock, CmdItemAssign, CmdItemUnassign:
+		return []uuid.UUID{listOf(state.UUID)}
+	case CmdItemMove:
+		return []uuid.UUID{listOf(state.UUID), state.ListUUID}
+	case CmdItemComment:
+		return []uuid.UUID{listOf(state.ItemUUID)}
+	case CmdItemCommentUpdate, CmdItemCommentDelete:
+		if comment, err := tx.RetrieveComment(user, state.UUID.String()); err == nil {
+			return []uuid.UUID{listOf(comment.ItemUUID)}
+		}
+	}
+	return nil
+}
+
+// shareListSnapshot writes the list as it is now into the history of a new
+// member, so their devices pick it up on the next sync like any other list.
+func shareListSnapshot(tx db.Store, user string, list models.List, now int64) error {
+	if err := createHistoryForState(tx, CmdListCreate, user, now, list); err != nil {
+		return err
+	}
+
+	items, err := tx.RetrieveAllItems(user, list.UUID.String())
+	if err != nil {
+		return err
+	}
+
+	// parents are created before their subtasks
+	created := make(map[uuid.UUID]bool, len(items))
+	for len(created) < len(items) {
+		progress := false
+		for _, item := range items {
+			if created[item.UUID] || (item.ParentUUID != uuid.Nil && !created[item.ParentUUID]) {
+				continue
+			}
+
+			if err := createHistoryForState(tx, CmdItemCreate, user, now, item); err != nil {
+				return err
+			}
+			created[item.UUID] = true
+			progress = true
+		}
+
+		if !progress {
+			break
+		}
+	}
+
+	dependencies, err := tx.RetrieveDependencies(user)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dependencies {
+		if !created[d.ItemUUID] {
+			continue
+		}
+
+		state := dependencyState{UUID: d.ItemUUID, BlockerUUID: d.BlockerUUID}
+		if err := createHistoryForState(tx, CmdItemBlock, user, now, state); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range items {
+		comments, err := tx.RetrieveComments(user, item.UUID.String(), "", 0)
+		if err != nil {
+			return err
+		}
+
+		for _, comment := range repliesLast(comments) {
+			if err := createHistoryForState(tx, CmdItemComment, user, now, comment); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fillMemberEmails looks up the email addresses of the members, which live
+// in the user database.
+func fillMemberEmails(store db.Store, members []models.Member) {
+	for i := range members {
+		if u, err := store.FindUserByUUID(members[i].UserUUID.String()); err == nil {
+			members[i].Email = u.Email
+		}
+	}
+}
+
+func getMembersHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		if _, err := store.RetrieveList(user, listId); err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		members, err := store.RetrieveMembers(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		fillMemberEmails(store, members)
+		respondWithJSON(w, http.StatusOK, struct {
+			Members []models.Member `json:"members"`
+		}{Members: members})
+	}
+}
+
+// findMember returns the member of the list with the given user id.
+func findMember(store db.Store, user, listId, memberId string) (models.Member, bool) {
+	members, err := store.RetrieveMembers(user, listId)
+	if err != nil {
+		return models.Member{}, false
+	}
+
+	for _, m := range members {
+		if m.UserUUID.String() == memberId {
+			return m, true
+		}
+	}
+	return models.Member{}, false
+}
+
+// patchMemberHandler lets the owner change the role of another member.
+func patchMemberHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+		memberId := chi.URLParam(r, "userId")
+
+		var request struct {
+			Role *string `json:"role,omitempty"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || request.Role == nil || !validMemberRole(*request.Role) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		member, ok := findMember(store, user, listId, memberId)
+		if !ok {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if list.Role != models.RoleOwner || member.Role == models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		// members of the list's workspace have the role they have there
+		if list.WorkspaceUUID != uuid.Nil && inWorkspace(store, memberId, list.WorkspaceUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		member.Role = *request.Role
+		if err := store.UpdateMember(user, member); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		updated := []models.Member{member}
+		fillMemberEmails(store, updated)
+		respondWithJSON(w, http.StatusOK, updated[0])
+	}
+}
+
+// deleteMemberHandler stops sharing the list with a member. The owner can
+// remove anyone else, other members can only remove themselves. The list is
+// deleted from the removed member's history so their devices drop it.
+func deleteMemberHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+		memberId := chi.URLParam(r, "userId")
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		member, ok := findMember(store, user, listId, memberId)
+		if !ok {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		// the owner deletes the list instead of leaving it, and members of its
+		// workspace leave the workspace
+		if member.Role == models.RoleOwner || (memberId != user && list.Role != models.RoleOwner) ||
+			(list.WorkspaceUUID != uuid.Nil && inWorkspace(store, memberId, list.WorkspaceUUID)) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := unassignLeaving(tx, user, memberId, []uuid.UUID{list.UUID}, now); err != nil {
+				return err
+			}
+
+			if err := tx.RemoveMember(user, list.UUID, member.UserUUID); err != nil {
+				return err
+			}
+
+			state := uuidState{UUID: list.UUID}
+			return createHistoryForState(tx, CmdListDelete, memberId, now, state)
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func getListInvitationsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if list.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		invitations, err := store.RetrieveListInvitations(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Invitations []models.Invitation `json:"invitations"`
+		}{Invitations: invitations})
+	}
+}
+
+// postInvitationHandler invites an email address to the list and mails the
+// invitation to it. Only the owner can invite others. Addresses that are
+// already members or invited are rejected with a conflict.
+func postInvitationHandler(store db.Store, mailer mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		var request struct {
+			Email *string `json:"email,omitempty"`
+			Role  *string `json:"role,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		// invitations are for editors unless told otherwise
+		role := models.RoleEditor
+		if request.Role != nil {
+			role = *request.Role
+		}
+
+		email := normalizeEmail(*request.Email)
+		if !strings.Contains(email, "@") || !validMemberRole(role) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if list.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		members, err := store.RetrieveMembers(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		fillMemberEmails(store, members)
+		for _, m := range members {
+			if strings.EqualFold(m.Email, email) {
+				respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+				return
+			}
+		}
+
+		inviter, err := store.FindUserByUUID(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		id, err := uuid.NewRandom()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		invitation := models.Invitation{
+			UUID:      id,
+			ListUUID:  list.UUID,
+			Email:     email,
+			Role:      role,
+			InvitedBy: inviter.UUID,
+			Created:   time.Now().UTC().Unix(),
+		}
+
+		if err := store.CreateInvitation(user, invitation); err == db.ErrNoAccess {
+			// the address has already been invited
+			respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		} else if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		msg := mail.Message{
+			To:      email,
+			Subject: "You have been invited to a procrast list",
+			Body:    fmt.Sprintf("%s invited you to the list %q.\n\nSign in to procrast with this email address to accept the invitation.", inviter.Email, list.Title),
+		}
+		if err := mailer.Send(msg); err != nil {
+			log.Println("Failed to send invitation email:", err)
+		}
+
+		respondWithJSON(w, http.StatusCreated, invitation)
+	}
+}
+
+// deleteListInvitationHandler withdraws an invitation to the list.
+func deleteListInvitationHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+		invitationId := chi.URLParam(r, "invitationId")
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if list.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		invitations, err := store.RetrieveListInvitations(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		for _, inv := range invitations {
+			if inv.UUID.String() != invitationId {
+				continue
+			}
+
+			if err := store.DeleteInvitation(user, "", inv.UUID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+
+			respondWithJSON(w, http.StatusNoContent, nil)
+			return
+		}
+
+		respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+}
+
+// getInvitationsHandler returns the open invitations for the user's email
+// address.
+func getInvitationsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		u, err := store.FindUserByUUID(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		invitations, err := store.RetrieveInvitations(u.Email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Invitations []models.Invitation `json:"invitations"`
+		}{Invitations: invitations})
+	}
+}
+
+// postAcceptInvitationHandler makes the user a member of the list. The list
+// goes first in the user's lists and is written into their history with its
+// items, so their devices pick it up on the next sync.
+func postAcceptInvitationHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		invitationId := chi.URLParam(r, "invitationId")
+
+		u, err := store.FindUserByUUID(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		invitation, err := store.RetrieveInvitation(u.Email, invitationId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if _, err := store.RetrieveList(user, invitation.ListUUID.String()); err == nil {
+			respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		var list models.List
+		err = store.Transaction(func(tx db.Store) error {
+			pos, err := newListPosition(tx, user)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.AcceptInvitation(user, u.Email, invitation.UUID, pos, now); err != nil {
+				return err
+			}
+
+			if list, err = tx.RetrieveList(user, invitation.ListUUID.String()); err != nil {
+				return err
+			}
+
+			return shareListSnapshot(tx, user, list, now)
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, list)
+	}
+}
+
+// deleteInvitationHandler declines an invitation.
+func deleteInvitationHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		invitationId := chi.URLParam(r, "invitationId")
+
+		u, err := store.FindUserByUUID(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		invitation, err := store.RetrieveInvitation(u.Email, invitationId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if err := store.DeleteInvitation(user, u.Email, invitation.UUID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
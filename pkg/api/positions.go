@@ -0,0 +1,399 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+	"ismacaulay/procrast-api/pkg/position"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// positionState is the state of LIST MOVE history entries.
+type positionState struct {
+	UUID     uuid.UUID `json:"uuid"`
+	Position string    `json:"position"`
+}
+
+// itemMoveState is the state of ITEM MOVE history entries. The item is moved
+// to another list if ListUUID is set and differs from its list. ParentUUID is
+// the item's parent after the move, uuid.Nil or null makes it a top level
+// item. A move without parent_uuid keeps the parent, see keptParent. Moves of
+// a card on the board also set the item's State, and Modified with it.
+type itemMoveState struct {
+	UUID       uuid.UUID `json:"uuid"`
+	ListUUID   uuid.UUID `json:"list_uuid"`
+	ParentUUID uuid.UUID `json:"parent_uuid"`
+	Position   string    `json:"position"`
+	State      *uint8    `json:"state,omitempty"`
+	Modified   int64     `json:"modified,omitempty"`
+}
+
+// keptParent is the parent of an item moved to list by an ITEM MOVE without
+// a parent_uuid. A plain reorder keeps the parent, moving to another list
+// leaves it behind.
+func keptParent(item models.Item, list uuid.UUID) uuid.UUID {
+	if list != item.ListUUID {
+		return uuid.Nil
+	}
+	return item.ParentUUID
+}
+
+// sibling is a list or item in the order they are returned by the store.
+type sibling struct {
+	UUID     uuid.UUID
+	Position string
+}
+
+func listSiblings(lists []models.List, moving uuid.UUID) []sibling {
+	siblings := make([]sibling, 0, len(lists))
+	for _, list := range lists {
+		if list.UUID != moving {
+			siblings = append(siblings, sibling{UUID: list.UUID, Position: list.Position})
+		}
+	}
+	return siblings
+}
+
+func itemSiblings(items []models.Item, moving uuid.UUID) []sibling {
+	siblings := make([]sibling, 0, len(items))
+	for _, item := range items {
+		if item.UUID != moving {
+			siblings = append(siblings, sibling{UUID: item.UUID, Position: item.Position})
+		}
+	}
+	return siblings
+}
+
+// childItems returns the items directly under parent, uuid.Nil for the top
+// level items.
+func childItems(items []models.Item, parent uuid.UUID) []models.Item {
+	children := make([]models.Item, 0, len(items))
+	for _, item := range items {
+		if item.ParentUUID == parent {
+			children = append(children, item)
+		}
+	}
+	return children
+}
+
+// positionNextTo returns a position directly after, or before, the sibling
+// with the given id. false is returned if there is no such sibling, or the
+// siblings are out of order.
+func positionNextTo(siblings []sibling, id uuid.UUID, after bool) (string, bool) {
+	for i, s := range siblings {
+		if s.UUID != id {
+			continue
+		}
+
+		if after {
+			next := ""
+			if i+1 < len(siblings) {
+				next = siblings[i+1].Position
+			}
+			pos, err := position.Between(s.Position, next)
+			return pos, err == nil
+		}
+
+		prev := ""
+		if i > 0 {
+			prev = siblings[i-1].Position
+		}
+		pos, err := position.Between(prev, s.Position)
+		return pos, err == nil
+	}
+
+	return "", false
+}
+
+// newListPosition puts new lists first, like the newest first order lists
+// had before they could be reordered.
+func newListPosition(tx db.Store, user string) (string, error) {
+	lists, err := tx.RetrieveAllLists(user)
+	if err != nil {
+		return "", err
+	}
+
+	if len(lists) == 0 {
+		return position.Between("", "")
+	}
+	return position.Between("", lists[0].Position)
+}
+
+// newItemPosition puts new items at the end of the list.
+func newItemPosition(tx db.Store, user string, list uuid.UUID) (string, error) {
+	items, err := tx.RetrieveAllItems(user, list.String())
+	if err != nil {
+		return "", err
+	}
+
+	if len(items) == 0 {
+		return position.Between("", "")
+	}
+	return position.Between(items[len(items)-1].Position, "")
+}
+
+// moveRequest places a list or item directly before or after a sibling. Moves
+// only change the position, they do not count as modifying the list or item.
+type moveRequest struct {
+	Before *uuid.UUID `json:"before,omitempty"`
+	After  *uuid.UUID `json:"after,omitempty"`
+}
+
+// decodeMoveRequest returns the sibling to move next to and whether to move
+// after it. Exactly one of before and after must be given.
+func decodeMoveRequest(r *http.Request) (uuid.UUID, bool, bool) {
+	var request moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return uuid.Nil, false, false
+	}
+
+	switch {
+	case request.Before != nil && request.After == nil:
+		return *request.Before, false, true
+	case request.After != nil && request.Before == nil:
+		return *request.After, true, true
+	}
+	return uuid.Nil, false, false
+}
+
+func postMoveListHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		target, after, ok := decodeMoveRequest(r)
+		if !ok {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		lists, err := store.RetrieveAllLists(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		pos, ok := positionNextTo(listSiblings(lists, list.UUID), target, after)
+		if !ok {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		list.Position = pos
+		list.Clock.Set("position", now)
+
+		// every member orders their lists themselves, so moves are not shared
+		err = store.Transaction(func(tx db.Store) error {
+			if err := tx.MoveList(user, list.UUID, list.Position, now); err != nil {
+				return err
+			}
+
+			state := positionState{UUID: list.UUID, Position: list.Position}
+			return createHistoryForState(tx, CmdListMove, user, now, state)
+		})
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, list)
+	}
+}
+
+// postMoveItemHandler moves an item next to another item, moving it to the
+// other item's list and parent if those are different.
+func postMoveItemHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+
+		target, after, ok := decodeMoveRequest(r)
+		if !ok {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		other, err := store.RetrieveItem(user, target.String())
+		if err != nil || other.UUID == item.UUID {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		if !canEdit(store, user, item.ListUUID) || !canEdit(store, user, other.ListUUID) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		items, err := store.RetrieveAllItems(user, other.ListUUID.String())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		pos, ok := positionNextTo(itemSiblings(items, item.UUID), target, after)
+		if !ok {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		item.ListUUID = other.ListUUID
+		item.ParentUUID = other.ParentUUID
+		item.Position = pos
+		if err := checkParent(store, user, item); err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+
+		err = store.Transaction(func(tx db.Store) error {
+			return moveItem(tx, user, &item, now)
+		})
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, item)
+	}
+}
+
+// moveItem stores the item's list, parent and position and records the move.
+// The move is shared with the members of the list the item was in as well as
+// the list it is moved to.
+func moveItem(tx db.Store, user string, item *models.Item, now int64) error {
+	stored, err := tx.RetrieveItem(user, item.UUID.String())
+	if err != nil {
+		return err
+	}
+
+	item.Clock.Set("position", now)
+	if err := tx.UpdateItem(user, *item); err != nil {
+		return err
+	}
+
+	state := itemMoveState{
+		UUID:       item.UUID,
+		ListUUID:   item.ListUUID,
+		ParentUUID: item.ParentUUID,
+		Position:   item.Position,
+	}
+	return createHistoryForState(tx, CmdItemMove, user, now, state, stored.ListUUID, item.ListUUID)
+}
+
+// postMoveItemsHandler moves every item in the list that is in the given
+// state to the end of another list, keeping their order. Subtasks go with
+// their parent, subtasks moved without it become top level items.
+func postMoveItemsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		var request struct {
+			State    *uint8     `json:"state,omitempty"`
+			ListUUID *uuid.UUID `json:"list_uuid,omitempty"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || request.State == nil || request.ListUUID == nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		target, err := store.RetrieveList(user, request.ListUUID.String())
+		if err != nil || target.UUID == list.UUID {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		if !models.CanEdit(list.Role) || !models.CanEdit(target.Role) {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		moved := make([]models.Item, 0)
+		err = store.Transaction(func(tx db.Store) error {
+			items, err := tx.RetrieveAllItems(user, list.UUID.String())
+			if err != nil {
+				return err
+			}
+
+			matches := make(map[uuid.UUID]bool)
+			for _, item := range items {
+				matches[item.UUID] = item.State == *request.State
+			}
+
+			for _, item := range items {
+				if !matches[item.UUID] || movesWithParent(items, item, matches) {
+					continue
+				}
+
+				if item.Position, err = newItemPosition(tx, user, target.UUID); err != nil {
+					return err
+				}
+
+				item.ListUUID = target.UUID
+				item.ParentUUID = uuid.Nil
+				if err := moveItem(tx, user, &item, now); err != nil {
+					return err
+				}
+				moved = append(moved, item)
+			}
+			return nil
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Items []models.Item `json:"items"`
+		}{Items: moved})
+	}
+}
+
+// movesWithParent reports whether one of the item's ancestors is moved.
+func movesWithParent(items []models.Item, item models.Item, moved map[uuid.UUID]bool) bool {
+	for depth := 0; item.ParentUUID != uuid.Nil && depth < maxItemDepth; depth++ {
+		if moved[item.ParentUUID] {
+			return true
+		}
+
+		for _, parent := range items {
+			if parent.UUID == item.ParentUUID {
+				item = parent
+				break
+			}
+		}
+	}
+	return false
+}
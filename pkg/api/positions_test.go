@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+func (a *testApi) listTitles(user testUser) string {
+	a.t.Helper()
+	var response struct {
+		Lists []models.List `json:"lists"`
+	}
+	a.must(user, http.StatusOK, "GET", "/lists", nil, &response)
+
+	titles := make([]string, 0, len(response.Lists))
+	for _, list := range response.Lists {
+		titles = append(titles, list.Title)
+	}
+	return strings.Join(titles, " ")
+}
+
+func (a *testApi) itemTitles(user testUser, list models.List) string {
+	a.t.Helper()
+	var response struct {
+		Items []models.Item `json:"items"`
+	}
+	a.must(user, http.StatusOK, "GET", "/lists/"+list.UUID.String()+"/items", nil, &response)
+
+	titles := make([]string, 0, len(response.Items))
+	for _, item := range response.Items {
+		titles = append(titles, item.Title)
+	}
+	return strings.Join(titles, " ")
+}
+
+func TestMoveLists(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	c := a.createList(user, "c")
+	b := a.createList(user, "b")
+	first := a.createList(user, "a")
+
+	// new lists go first
+	if got := a.listTitles(user); got != "a b c" {
+		t.Fatalf("expected a b c, got %s", got)
+	}
+
+	a.must(user, http.StatusOK, "POST", "/lists/"+first.UUID.String()+"/move", map[string]interface{}{"after": c.UUID}, nil)
+	if got := a.listTitles(user); got != "b c a" {
+		t.Errorf("after moving a after c: expected b c a, got %s", got)
+	}
+
+	a.must(user, http.StatusOK, "POST", "/lists/"+c.UUID.String()+"/move", map[string]interface{}{"before": b.UUID}, nil)
+	if got := a.listTitles(user); got != "c b a" {
+		t.Errorf("after moving c before b: expected c b a, got %s", got)
+	}
+
+	for _, body := range []map[string]interface{}{
+		{},
+		{"before": b.UUID, "after": c.UUID},
+		{"after": c.UUID.String() + "x"},
+	} {
+		if status := a.do(user, "POST", "/lists/"+first.UUID.String()+"/move", body, nil); status != http.StatusUnprocessableEntity {
+			t.Errorf("%v: expected %d, got %d", body, http.StatusUnprocessableEntity, status)
+		}
+	}
+}
+
+func TestMoveItems(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	list := a.createList(user, "list")
+	first := a.createItem(user, list, "a")
+	second := a.createItem(user, list, "b")
+	third := a.createItem(user, list, "c")
+
+	// new items go last
+	if got := a.itemTitles(user, list); got != "a b c" {
+		t.Fatalf("expected a b c, got %s", got)
+	}
+
+	// moving between the same pair over and over keeps the order
+	for i := 0; i < 20; i++ {
+		moving, target := first, third
+		if i%2 == 1 {
+			moving, target = third, first
+		}
+		a.must(user, http.StatusOK, "POST", "/items/"+moving.UUID.String()+"/move", map[string]interface{}{"before": second.UUID}, nil)
+		a.must(user, http.StatusOK, "POST", "/items/"+target.UUID.String()+"/move", map[string]interface{}{"after": second.UUID}, nil)
+	}
+	if got := a.itemTitles(user, list); got != "c b a" {
+		t.Errorf("expected c b a, got %s", got)
+	}
+
+	if status := a.do(user, "POST", "/items/"+first.UUID.String()+"/move", map[string]interface{}{"after": first.UUID}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("moving an item next to itself: expected %d, got %d", http.StatusUnprocessableEntity, status)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+	"ismacaulay/procrast-api/pkg/rrule"
+
+	"github.com/google/uuid"
+)
+
+// validRRule reports whether rule is empty or a recurrence rule we support.
+func validRRule(rule string) bool {
+	if rule == "" {
+		return true
+	}
+
+	_, err := rrule.Parse(rule)
+	return err == nil
+}
+
+// completesOccurrence reports whether an update moves a repeating item in the
+// list into a terminal state. An item that is finished again after being
+// reopened has already spawned its next occurrence, which
+// spawnNextOccurrence checks for.
+func completesOccurrence(list models.List, before, after models.Item) bool {
+	return after.RRule != "" && !list.Finished(before.State) && list.Finished(after.State)
+}
+
+// spawnNextOccurrence creates the next occurrence of a repeating item that has
+// just been finished and records it in the history, so offline clients get it
+// on their next sync. It starts in the first state of the list's workflow. The
+// dates move to the next occurrence in the user's time zone, items without
+// dates repeat from when they were completed. nil is returned once the series
+// has ended, or when the next occurrence was already spawned.
+func spawnNextOccurrence(tx db.Store, user string, item models.Item, now int64) (*models.Item, error) {
+	rule, err := rrule.Parse(item.RRule)
+	if err != nil {
+		return nil, nil
+	}
+
+	items, err := tx.RetrieveAllItems(user, item.ListUUID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// one in the trash counts too, restoring it brings it back
+	trashed, err := tx.RetrieveTrashedItems(user)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range append(items, trashed...) {
+		if other.PreviousUUID != nil && *other.PreviousUUID == item.UUID {
+			return nil, nil
+		}
+	}
+
+	u, err := tx.FindUserByUUID(user)
+	if err != nil {
+		return nil, err
+	}
+	loc := userLocation(u.TimeZone)
+
+	list, err := tx.RetrieveList(user, item.ListUUID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	anchor := item.Due
+	if anchor == 0 {
+		anchor = item.Start
+	}
+	if anchor == 0 {
+		anchor = now
+	}
+
+	occurrence := item.Occurrence
+	if occurrence == 0 {
+		occurrence = 1
+	}
+
+	next, ok := rule.Next(time.Unix(anchor, 0).In(loc), occurrence)
+	if !ok {
+		return nil, nil
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	delta := next.Unix() - anchor
+	spawned := models.Item{
+		UUID:         id,
+		Title:        item.Title,
+		Description:  item.Description,
+		State:        initialState(list),
+		Created:      now,
+		Modified:     now,
+		ListUUID:     item.ListUUID,
+		ParentUUID:   item.ParentUUID,
+		RRule:        item.RRule,
+		Occurrence:   occurrence + 1,
+		PreviousUUID: &item.UUID,
+
+		// the series stays with whoever it is assigned to
+		AssigneeUUID: item.AssigneeUUID,
+	}
+
+	if item.Due != 0 {
+		spawned.Due = item.Due + delta
+	}
+
+	if item.Start != 0 {
+		spawned.Start = item.Start + delta
+	}
+
+	if item.Due == 0 && item.Start == 0 {
+		spawned.Due = next.Unix()
+	}
+
+	// the next occurrence takes the place of the one that was finished,
+	// among its siblings when it is a subtask
+	siblings := itemSiblings(childItems(items, item.ParentUUID), uuid.Nil)
+	spawned.Position, _ = positionNextTo(siblings, item.UUID, true)
+	if spawned.Position == "" {
+		if spawned.Position, err = newItemPosition(tx, user, item.ListUUID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.CreateItem(user, spawned); err != nil {
+		return nil, err
+	}
+
+	if err := createHistoryForState(tx, CmdItemCreate, user, now, spawned, spawned.ListUUID); err != nil {
+		return nil, err
+	}
+
+	return &spawned, nil
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// occurrences returns the items of the list with the title, in position order.
+func (a *testApi) occurrences(user testUser, list models.List, title string) []models.Item {
+	a.t.Helper()
+	var response struct {
+		Items []models.Item `json:"items"`
+	}
+	a.must(user, http.StatusOK, "GET", "/lists/"+list.UUID.String()+"/items", nil, &response)
+
+	items := make([]models.Item, 0)
+	for _, item := range response.Items {
+		if item.Title == title {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func TestReopenedOccurrenceSpawnsOnce(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	list := a.createList(user, "list")
+
+	var item models.Item
+	a.must(user, http.StatusCreated, "POST", "/lists/"+list.UUID.String()+"/items", map[string]interface{}{"title": "water", "rrule": "FREQ=DAILY"}, &item)
+
+	path := "/items/" + item.UUID.String()
+	for _, state := range []uint8{models.ItemStateDone, 0, models.ItemStateDone, 0, models.ItemStateDone} {
+		a.must(user, http.StatusOK, "PATCH", path, map[string]interface{}{"state": state}, nil)
+	}
+
+	items := a.occurrences(user, list, "water")
+	if len(items) != 2 {
+		t.Fatalf("expected the item and one next occurrence, got %+v", items)
+	}
+
+	next := items[0]
+	if next.UUID == item.UUID {
+		next = items[1]
+	}
+	if next.Occurrence != 2 || next.PreviousUUID == nil || *next.PreviousUUID != item.UUID {
+		t.Errorf("unexpected next occurrence: %+v", next)
+	}
+
+	// finishing the next occurrence carries on the series
+	a.must(user, http.StatusOK, "PATCH", "/items/"+next.UUID.String(), map[string]interface{}{"state": models.ItemStateDone}, nil)
+	if items := a.occurrences(user, list, "water"); len(items) != 3 {
+		t.Errorf("expected a third occurrence, got %+v", items)
+	}
+}
+
+// Positions are only ordered among siblings, a subtask can have the same
+// position as a top level item.
+func TestRepeatingSubtaskSpawnsNextToItself(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	list := a.createList(user, "list")
+	ts := time.Now().UTC().Unix()
+
+	first := models.Item{UUID: uuid.MustParse("00000000-0000-0000-0000-000000000001"), Title: "first", RRule: "FREQ=WEEKLY", Position: "m"}
+	parent := models.Item{UUID: uuid.MustParse("00000000-0000-0000-0000-000000000002"), Title: "parent", Position: "m"}
+	second := models.Item{UUID: uuid.New(), Title: "second", Position: "n"}
+	first.ParentUUID, second.ParentUUID = parent.UUID, parent.UUID
+	for _, item := range []models.Item{parent, first, second} {
+		item.ListUUID = list.UUID
+		if result := a.sync(user, historyEntry(CmdItemCreate, item, ts)); result.Status != StatusApplied {
+			t.Fatalf("%s was not applied: %+v", item.Title, result)
+		}
+	}
+
+	a.must(user, http.StatusOK, "PATCH", "/items/"+first.UUID.String(), map[string]interface{}{"state": models.ItemStateDone}, nil)
+
+	var items []models.Item
+	a.must(user, http.StatusOK, "GET", "/lists/"+list.UUID.String()+"/items", nil, &struct {
+		Items *[]models.Item `json:"items"`
+	}{&items})
+
+	// it goes right after the finished one, not at the end of the list
+	titles := make([]string, 0)
+	for _, item := range childItems(items, parent.UUID) {
+		titles = append(titles, item.Title)
+	}
+	if len(titles) != 3 || titles[0] != "first" || titles[1] != "first" || titles[2] != "second" {
+		t.Errorf("expected both occurrences before the second subtask, got %v", titles)
+	}
+}
@@ -0,0 +1,634 @@
+package api
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// Replay rebuilds a user's lists and items in store by applying their history
+// in the order it was recorded. Entries that lost a conflict were stored with
+// the winning state, so applying every entry in order converges on the state
+// the server had. Entries that cannot be applied are logged and counted.
+func Replay(store db.Store, user string, history []models.History) (int, error) {
+	skipped := 0
+	err := store.Transaction(func(tx db.Store) error {
+		for _, entry := range history {
+			err := replayEntry(tx, user, entry)
+			if _, ok := err.(*syncError); ok {
+				log.Printf("Skipping history %s (%s): %s\n", entry.UUID, entry.Command, err)
+				skipped++
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return skipped, err
+}
+
+func replayEntry(tx db.Store, user string, entry models.History) error {
+	switch entry.Command {
+	case CmdListCreate:
+		var state models.List
+		_, err := decodeState(entry.State, &state)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.RetrieveList(user, state.UUID.String()); err == nil {
+			return nil
+		}
+
+		if state.Position == "" {
+			if state.Position, err = newListPosition(tx, user); err != nil {
+				return err
+			}
+		}
+
+		state.Clock = nil
+		return tx.CreateList(user, state)
+	case CmdListUpdate:
+		var state models.List
+		fields, err := decodeState(entry.State, &state)
+		if err != nil {
+			return err
+		}
+
+		list, err := tx.RetrieveList(user, state.UUID.String())
+		if err != nil {
+			return errListNotFound
+		}
+
+		changed := false
+		if _, ok := fields["title"]; ok && list.Title != state.Title {
+			list.Title = state.Title
+			changed = true
+		}
+
+		if _, ok := fields["description"]; ok && list.Description != state.Description {
+			list.Description = state.Description
+			changed = true
+		}
+
+		if _, ok := fields["workflow"]; ok && !sameWorkflow(list.States(), state.Workflow) {
+			list.Workflow = state.Workflow
+			changed = true
+		}
+
+		if !changed {
+			return nil
+		}
+
+		if state.Modified > list.Modified {
+			list.Modified = state.Modified
+		}
+		return tx.UpdateList(user, list)
+	case CmdListDelete:
+		var state uuidState
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		list, err := tx.RetrieveList(user, state.UUID.String())
+		if err != nil {
+			// already deleted
+			return nil
+		}
+
+		return deleteList(tx, user, list, entry.Timestamp)
+	case CmdListRestore:
+		var state uuidState
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		list, err := tx.RetrieveTrashedList(user, state.UUID.String())
+		if err != nil {
+			// not in the trash
+			return nil
+		}
+
+		return restoreList(tx, user, list)
+	case CmdItemCreate:
+		var state models.Item
+		_, err := decodeState(entry.State, &state)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.RetrieveItem(user, state.UUID.String()); err == nil {
+			return nil
+		}
+
+		if state.Position == "" {
+			if state.Position, err = newItemPosition(tx, user, state.ListUUID); err != nil {
+				return err
+			}
+		}
+
+		state.Clock = nil
+		return accessError(tx.CreateItem(user, state), errListNotFound)
+	case CmdItemUpdate:
+		var state models.Item
+		fields, err := decodeState(entry.State, &state)
+		if err != nil {
+			return err
+		}
+
+		item, err := tx.RetrieveItem(user, state.UUID.String())
+		if err != nil {
+			return errItemNotFound
+		}
+
+		changed := false
+		if _, ok := fields["title"]; ok && item.Title != state.Title {
+			item.Title = state.Title
+			changed = true
+		}
+
+		if _, ok := fields["description"]; ok && item.Description != state.Description {
+			item.Description = state.Description
+			changed = true
+		}
+
+		if _, ok := fields["state"]; ok && item.State != state.State {
+			item.State = state.State
+			changed = true
+		}
+
+		if _, ok := fields["due"]; ok && item.Due != state.Due {
+			item.Due = state.Due
+			changed = true
+		}
+
+		if _, ok := fields["start"]; ok && item.Start != state.Start {
+			item.Start = state.Start
+			changed = true
+		}
+
+		// the next occurrence of a repeating item has its own ITEM CREATE
+		if _, ok := fields["rrule"]; ok && item.RRule != state.RRule {
+			item.RRule = state.RRule
+			changed = true
+		}
+
+		if !changed {
+			return nil
+		}
+
+		if state.Modified > item.Modified {
+			item.Modified = state.Modified
+		}
+		return tx.UpdateItem(user, item)
+	case CmdItemDelete:
+		var state uuidState
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		item, err := tx.RetrieveItem(user, state.UUID.String())
+		if err != nil {
+			return nil
+		}
+
+		return deleteItem(tx, user, item, entry.Timestamp)
+	case CmdItemRestore:
+		var state uuidState
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		item, err := tx.RetrieveTrashedItem(user, state.UUID.String())
+		if err != nil {
+			return nil
+		}
+
+		return accessError(restoreItem(tx, user, item), errListNotFound)
+	case CmdListMove:
+		var state positionState
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		list, err := tx.RetrieveList(user, state.UUID.String())
+		if err != nil {
+			return errListNotFound
+		}
+
+		if list.Position == state.Position {
+			return nil
+		}
+
+		return tx.MoveList(user, list.UUID, state.Position, entry.Timestamp)
+	case CmdItemMove:
+		var state itemMoveState
+		fields, err := decodeState(entry.State, &state)
+		if err != nil {
+			return err
+		}
+
+		item, err := tx.RetrieveItem(user, state.UUID.String())
+		if err != nil {
+			return errItemNotFound
+		}
+
+		if state.ListUUID == uuid.Nil {
+			state.ListUUID = item.ListUUID
+		}
+
+		if _, ok := fields["parent_uuid"]; !ok {
+			state.ParentUUID = keptParent(item, state.ListUUID)
+		}
+
+		moved := item.Position != state.Position || item.ListUUID != state.ListUUID || item.ParentUUID != state.ParentUUID
+		changed := state.State != nil && item.State != *state.State
+		if !moved && !changed {
+			return nil
+		}
+
+		item.Position = state.Position
+		item.ListUUID = state.ListUUID
+		item.ParentUUID = state.ParentUUID
+		if changed {
+			item.State = *state.State
+			if state.Modified > item.Modified {
+				item.Modified = state.Modified
+			}
+		}
+		return accessError(tx.UpdateItem(user, item), errListNotFound)
+	case CmdTagCreate:
+		var state models.Tag
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		if _, err := tx.RetrieveTag(user, state.UUID.String()); err == nil {
+			return nil
+		}
+
+		state.Clock = nil
+		return tx.CreateTag(user, state)
+	case CmdTagUpdate:
+		var state models.Tag
+		fields, err := decodeState(entry.State, &state)
+		if err != nil {
+			return err
+		}
+
+		tag, err := tx.RetrieveTag(user, state.UUID.String())
+		if err != nil {
+			return errTagNotFound
+		}
+
+		changed := false
+		if _, ok := fields["name"]; ok && tag.Name != state.Name {
+			tag.Name = state.Name
+			changed = true
+		}
+
+		if _, ok := fields["color"]; ok && tag.Color != state.Color {
+			tag.Color = state.Color
+			changed = true
+		}
+
+		if !changed {
+			return nil
+		}
+
+		if state.Modified > tag.Modified {
+			tag.Modified = state.Modified
+		}
+		return tx.UpdateTag(user, tag)
+	case CmdTagDelete:
+		var state uuidState
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		tag, err := tx.RetrieveTag(user, state.UUID.String())
+		if err != nil {
+			return nil
+		}
+
+		return deleteTag(tx, user, tag, entry.Timestamp)
+	case CmdItemTag, CmdItemUntag:
+		var state itemTagState
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		// the item or tag may have been deleted since
+		tagged, err := hasTag(tx, user, state.UUID, state.TagUUID)
+		if err != nil {
+			return err
+		}
+
+		if entry.Command == CmdItemUntag {
+			if !tagged {
+				return nil
+			}
+			return tx.UntagItem(user, state.UUID, state.TagUUID)
+		}
+
+		if tagged {
+			return nil
+		}
+
+		if _, err := tx.RetrieveItem(user, state.UUID.String()); err != nil {
+			return nil
+		}
+
+		if _, err := tx.RetrieveTag(user, state.TagUUID.String()); err != nil {
+			return nil
+		}
+		return tx.TagItem(user, state.UUID, state.TagUUID, entry.Timestamp)
+	case CmdItemBlock, CmdItemUnblock:
+		var state dependencyState
+		if _, err := decodeState(entry.State, &state); err != nil {
+			return err
+		}
+
+		dependencies, err := tx.RetrieveDependencies(user)
+		if err != nil {
+			return err
+		}
+
+		blocked := hasDependency(dependencies, state.UUID, state.BlockerUUID)
+		if entry.Command == CmdItemUnblock {
+			if !blocked {
+				return nil
+			}
+			return tx.RemoveDependency(user, state.UUID, state.BlockerUUID)
+		}
+
+		if blocked || createsCycle(dependencies, state.UUID, state.BlockerUUID) {
+			return nil
+		}
+
+		// either item may have been deleted since
+		for _, id := range []uuid.UUID{state.UUID, state.BlockerUUID} {
+			if _, err := tx.RetrieveItem(user, id.String()); err != nil {
+				return nil
+			}
+		}
+		return tx.AddDependency(user, state.UUID, state.BlockerUUID, entry.Timestamp)
+	case CmdItemAssign, CmdItemUnassign, CmdItemComment, CmdItemCommentUpdate, CmdItemCommentDelete:
+		// assignments and comments are not part of the rebuilt lists and items
+		return nil
+	}
+
+	return errUnknownCommand
+}
+
+// ListDrift is a list that differs between the live tables and the replayed
+// state. Live or Replayed is nil if the list only exists on one side.
+type ListDrift struct {
+	UUID     uuid.UUID
+	Live     *models.List
+	Replayed *models.List
+}
+
+// ItemDrift is an item that differs between the live tables and the replayed
+// state. Live or Replayed is nil if the item only exists on one side.
+type ItemDrift struct {
+	UUID     uuid.UUID
+	Live     *models.Item
+	Replayed *models.Item
+}
+
+type Drift struct {
+	Lists []ListDrift
+	Items []ItemDrift
+}
+
+func (d Drift) Empty() bool {
+	return len(d.Lists) == 0 && len(d.Items) == 0
+}
+
+// DiffState compares a user's lists and items in live against replayed.
+// Clocks are not compared since they are only used to resolve conflicts.
+func DiffState(user string, live, replayed db.Store) (Drift, error) {
+	var drift Drift
+
+	liveLists, liveItems, err := loadState(live, user)
+	if err != nil {
+		return drift, err
+	}
+
+	replayedLists, replayedItems, err := loadState(replayed, user)
+	if err != nil {
+		return drift, err
+	}
+
+	for id, list := range liveLists {
+		list := list
+		other, ok := replayedLists[id]
+		if !ok {
+			drift.Lists = append(drift.Lists, ListDrift{UUID: id, Live: &list})
+		} else if !sameList(list, other) {
+			drift.Lists = append(drift.Lists, ListDrift{UUID: id, Live: &list, Replayed: &other})
+		}
+	}
+
+	for id, list := range replayedLists {
+		list := list
+		if _, ok := liveLists[id]; !ok {
+			drift.Lists = append(drift.Lists, ListDrift{UUID: id, Replayed: &list})
+		}
+	}
+
+	for id, item := range liveItems {
+		item := item
+		other, ok := replayedItems[id]
+		if !ok {
+			drift.Items = append(drift.Items, ItemDrift{UUID: id, Live: &item})
+		} else if !sameItem(item, other) {
+			drift.Items = append(drift.Items, ItemDrift{UUID: id, Live: &item, Replayed: &other})
+		}
+	}
+
+	for id, item := range replayedItems {
+		item := item
+		if _, ok := liveItems[id]; !ok {
+			drift.Items = append(drift.Items, ItemDrift{UUID: id, Replayed: &item})
+		}
+	}
+
+	return drift, nil
+}
+
+func loadState(store db.Store, user string) (map[uuid.UUID]models.List, map[uuid.UUID]models.Item, error) {
+	lists, err := store.RetrieveAllLists(user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listsById := make(map[uuid.UUID]models.List, len(lists))
+	itemsById := make(map[uuid.UUID]models.Item)
+	for _, list := range lists {
+		listsById[list.UUID] = list
+
+		items, err := store.RetrieveAllItems(user, list.UUID.String())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, item := range items {
+			itemsById[item.UUID] = item
+		}
+	}
+
+	return listsById, itemsById, nil
+}
+
+func sameList(a, b models.List) bool {
+	return a.Title == b.Title &&
+		a.Description == b.Description &&
+		sameWorkflow(a.States(), b.States()) &&
+		a.Position == b.Position &&
+		a.Modified == b.Modified
+}
+
+func sameItem(a, b models.Item) bool {
+	return a.Title == b.Title &&
+		a.Description == b.Description &&
+		a.State == b.State &&
+		a.Due == b.Due &&
+		a.Start == b.Start &&
+		a.RRule == b.RRule &&
+		a.Position == b.Position &&
+		a.Modified == b.Modified &&
+		a.ListUUID == b.ListUUID &&
+		a.ParentUUID == b.ParentUUID
+}
+
+// Repair brings the live tables in line with the replayed state. Removed lists
+// and items are tombstoned so devices that still have them delete them on
+// their next sync. Live clocks and assignees are kept for updated entities.
+func Repair(live db.Store, user string, drift Drift) error {
+	now := time.Now().UTC().Unix()
+	return live.Transaction(func(tx db.Store) error {
+		for _, d := range drift.Items {
+			if d.Replayed == nil {
+				// subtasks are deleted with their parent
+				if _, err := tx.RetrieveItem(user, d.UUID.String()); err != nil {
+					continue
+				}
+
+				if err := deleteItem(tx, user, *d.Live, now); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, d := range drift.Lists {
+			if d.Replayed == nil {
+				if err := deleteList(tx, user, *d.Live, now); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, d := range drift.Lists {
+			if d.Replayed == nil {
+				continue
+			}
+
+			list := *d.Replayed
+			if d.Live == nil {
+				// replace a copy left in the trash
+				if trashed, err := tx.RetrieveTrashedList(user, list.UUID.String()); err == nil {
+					if err := tx.DeleteList(user, trashed); err != nil {
+						return err
+					}
+				}
+
+				list.Clock = nil
+				if err := tx.CreateList(user, list); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// the position is the user's own, members that can't change the
+			// list can still have a drifted position
+			live := *d.Live
+			live.Position = list.Position
+			if !sameList(live, list) {
+				list.Clock = d.Live.Clock
+				if err := tx.UpdateList(user, list); err != nil {
+					return err
+				}
+			}
+
+			if list.Position != d.Live.Position {
+				if err := tx.MoveList(user, list.UUID, list.Position, now); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, d := range parentsFirst(drift.Items) {
+			if d.Replayed == nil {
+				continue
+			}
+
+			item := *d.Replayed
+			if d.Live == nil {
+				if trashed, err := tx.RetrieveTrashedItem(user, item.UUID.String()); err == nil {
+					if err := tx.DeleteItem(user, trashed); err != nil {
+						return err
+					}
+				}
+
+				item.Clock = nil
+				if err := tx.CreateItem(user, item); err != nil {
+					return err
+				}
+				continue
+			}
+
+			item.Clock = d.Live.Clock
+			item.AssigneeUUID = d.Live.AssigneeUUID
+			if err := tx.UpdateItem(user, item); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// parentsFirst orders item drift so parents are written before their subtasks.
+func parentsFirst(drift []ItemDrift) []ItemDrift {
+	parents := make(map[uuid.UUID]uuid.UUID, len(drift))
+	for _, d := range drift {
+		if d.Replayed != nil {
+			parents[d.UUID] = d.Replayed.ParentUUID
+		}
+	}
+
+	depth := func(id uuid.UUID) int {
+		n := 0
+		for parent, ok := parents[id]; ok && parent != uuid.Nil && n <= len(parents); parent, ok = parents[parent] {
+			n++
+		}
+		return n
+	}
+
+	sorted := append([]ItemDrift(nil), drift...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return depth(sorted[i].UUID) < depth(sorted[j].UUID)
+	})
+	return sorted
+}
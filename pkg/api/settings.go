@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+)
+
+type settingsResponse struct {
+	TimeZone string `json:"time_zone"`
+}
+
+func getSettingsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := store.FindUserByUUID(r.Context().Value("user").(string))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, settingsResponse{TimeZone: userLocation(user.TimeZone).String()})
+	}
+}
+
+func patchSettingsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			TimeZone *string `json:"time_zone,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		user, err := store.FindUserByUUID(r.Context().Value("user").(string))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if request.TimeZone != nil {
+			// time zones are IANA names like Europe/Berlin
+			loc, err := time.LoadLocation(*request.TimeZone)
+			if err != nil || *request.TimeZone == "" || *request.TimeZone == "Local" {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+
+			user.TimeZone = loc.String()
+			user.Modified = time.Now().UTC().Unix()
+			if err := store.UpdateUser(user); err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+		}
+
+		respondWithJSON(w, http.StatusOK, settingsResponse{TimeZone: userLocation(user.TimeZone).String()})
+	}
+}
+
+// userLocation returns the location for a user's time zone, falling back to
+// UTC if it is not set or no longer known.
+func userLocation(timeZone string) *time.Location {
+	if timeZone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
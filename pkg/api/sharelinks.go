@@ -0,0 +1,269 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/auth"
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+func getShareLinksHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if list.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		links, err := store.RetrieveShareLinks(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Links []models.ShareLink `json:"links"`
+		}{Links: links})
+	}
+}
+
+// postShareLinkHandler creates a public link to the list, owners only. The
+// token is only returned here, the link expires at `expires` if it is set.
+func postShareLinkHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+		now := time.Now().UTC().Unix()
+
+		var request struct {
+			Expires int64 `json:"expires"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+		}
+
+		if request.Expires < 0 || (request.Expires != 0 && request.Expires <= now) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if list.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		id, err := uuid.NewRandom()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		token, hash, err := auth.GenerateOpaqueToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		owner, _ := uuid.Parse(user)
+		link := models.ShareLink{
+			UUID:      id,
+			ListUUID:  list.UUID,
+			Token:     token,
+			Hash:      hash,
+			CreatedBy: owner,
+			Created:   now,
+			Expires:   request.Expires,
+		}
+
+		if err := store.CreateShareLink(user, link); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, link)
+	}
+}
+
+// deleteShareLinkHandler revokes a public link to the list.
+func deleteShareLinkHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+		linkId := chi.URLParam(r, "linkId")
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if list.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		links, err := store.RetrieveShareLinks(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		for _, link := range links {
+			if link.UUID.String() != linkId {
+				continue
+			}
+
+			if err := store.DeleteShareLink(user, link.UUID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+
+			respondWithJSON(w, http.StatusNoContent, nil)
+			return
+		}
+
+		respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+}
+
+// publicList is what anyone with a share link sees of a list, as json or
+// rendered as a page. It leaves out ids, members and anything else that is
+// only meant for accounts that can see the list.
+type publicList struct {
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Items       []publicItem `json:"items"`
+}
+
+// publicItem is an item of a publicList, with its subtasks as children.
+type publicItem struct {
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	State       string       `json:"state"`
+	Done        bool         `json:"done"`
+	Children    []publicItem `json:"children"`
+}
+
+func publicItems(list models.List, nodes []itemNode) []publicItem {
+	items := make([]publicItem, 0, len(nodes))
+	for _, node := range nodes {
+		state, _ := list.State(node.State)
+		items = append(items, publicItem{
+			Title:       node.Title,
+			Description: node.Description,
+			State:       state.Name,
+			Done:        list.Finished(node.State),
+			Children:    publicItems(list, node.Children),
+		})
+	}
+	return items
+}
+
+var publicListTemplate = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+ul { list-style: none; padding-left: 1.5em; }
+.done { text-decoration: line-through; color: #888; }
+.state { font-size: 0.8em; color: #666; }
+.description { margin: 0.2em 0; color: #444; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Description}}<p>{{.}}</p>{{end}}
+{{template "items" .Items}}
+</body>
+</html>
+{{define "items"}}{{if .}}<ul>
+{{range .}}<li>
+<span{{if .Done}} class="done"{{end}}>{{.Title}}</span>{{with .State}} <span class="state">{{.}}</span>{{end}}
+{{with .Description}}<p class="description">{{.}}</p>{{end}}
+{{template "items" .Children}}
+</li>
+{{end}}</ul>{{end}}{{end}}`))
+
+// getPublicListHandler returns the publicList of a share link, without an
+// account. With ?format=html the list is rendered as a page instead. The list
+// is read as the owner who created the link.
+func getPublicListHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := chi.URLParam(r, "token")
+		now := time.Now().UTC().Unix()
+
+		link, err := store.RetrieveShareLink(auth.HashOpaqueToken(token), now)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		owner := link.CreatedBy.String()
+		list, err := store.RetrieveList(owner, link.ListUUID.String())
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		items, err := store.RetrieveAllItems(owner, link.ListUUID.String())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		page := publicList{
+			Title:       list.Title,
+			Description: list.Description,
+			Items:       publicItems(list, itemTree(list, items)),
+		}
+
+		// revoking a link takes effect straight away
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.URL.Query().Get("format") != "html" {
+			respondWithJSON(w, http.StatusOK, page)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := publicListTemplate.Execute(&buf, page); err != nil {
+			log.Println("Failed to render list:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
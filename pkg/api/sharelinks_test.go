@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+func TestPublicListLeavesOutIds(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("owner@example.com")
+
+	list := a.createList(user, "list")
+	item := a.createItem(user, list, "item")
+	var subtask models.Item
+	a.must(user, http.StatusCreated, "POST", "/lists/"+list.UUID.String()+"/items", map[string]interface{}{"title": "subtask", "parent_uuid": item.UUID}, &subtask)
+
+	var link models.ShareLink
+	a.must(user, http.StatusCreated, "POST", "/lists/"+list.UUID.String()+"/links", nil, &link)
+
+	r := httptest.NewRequest("GET", "/public/v1/lists/"+link.Token, nil)
+	w := httptest.NewRecorder()
+	a.api.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	body := w.Body.String()
+	for _, id := range []string{user.id, list.UUID.String(), item.UUID.String(), subtask.UUID.String(), link.UUID.String()} {
+		if strings.Contains(body, id) {
+			t.Errorf("the public list contains %s: %s", id, body)
+		}
+	}
+
+	var public publicList
+	if err := json.Unmarshal(w.Body.Bytes(), &public); err != nil {
+		t.Fatalf("Failed to decode the public list: %s", err)
+	}
+	if public.Title != "list" || len(public.Items) != 1 || public.Items[0].Title != "item" ||
+		len(public.Items[0].Children) != 1 || public.Items[0].Children[0].Title != "subtask" {
+		t.Errorf("unexpected public list: %+v", public)
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"net/http"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// maxItemDepth is how deeply subtasks can be nested, top level items are at
+// depth 1.
+const maxItemDepth = 3
+
+// itemNode is an item in the tree returned by getItemTreeHandler. Done and
+// Total count its direct subtasks, Done those in a terminal state.
+type itemNode struct {
+	models.Item
+	Done     int        `json:"done"`
+	Total    int        `json:"total"`
+	Children []itemNode `json:"children"`
+}
+
+// itemTree nests the items of a list under their parents, keeping the order
+// they were given in.
+func itemTree(list models.List, items []models.Item) []itemNode {
+	ids := make(map[uuid.UUID]bool, len(items))
+	for _, item := range items {
+		ids[item.UUID] = true
+	}
+
+	children := make(map[uuid.UUID][]models.Item)
+	for _, item := range items {
+		parent := item.ParentUUID
+		if !ids[parent] {
+			parent = uuid.Nil
+		}
+		children[parent] = append(children[parent], item)
+	}
+
+	var build func(parent uuid.UUID) []itemNode
+	build = func(parent uuid.UUID) []itemNode {
+		nodes := make([]itemNode, 0, len(children[parent]))
+		for _, item := range children[parent] {
+			node := itemNode{Item: item, Children: build(item.UUID)}
+			for _, child := range node.Children {
+				node.Total++
+				if list.Finished(child.State) {
+					node.Done++
+				}
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+
+	return build(uuid.Nil)
+}
+
+// subtasksOf returns the subtasks of the item at any depth from the items of
+// its list.
+func subtasksOf(items []models.Item, id uuid.UUID) []models.Item {
+	subtasks := make([]models.Item, 0)
+	parents := map[uuid.UUID]bool{id: true}
+	for found := true; found; {
+		found = false
+		for _, item := range items {
+			if parents[item.ParentUUID] && !parents[item.UUID] {
+				parents[item.UUID] = true
+				subtasks = append(subtasks, item)
+				found = true
+			}
+		}
+	}
+	return subtasks
+}
+
+// checkParent checks that item can be stored with its ListUUID and
+// ParentUUID. errItemNotFound is returned if the parent is not a live item in
+// the list, errInvalidParent if nesting the item and its subtasks there would
+// make a cycle or go deeper than maxItemDepth.
+func checkParent(tx db.Store, user string, item models.Item) error {
+	if item.ParentUUID == uuid.Nil {
+		return nil
+	}
+
+	items, err := tx.RetrieveAllItems(user, item.ListUUID.String())
+	if err != nil {
+		return err
+	}
+
+	byId := make(map[uuid.UUID]models.Item, len(items))
+	for _, i := range items {
+		byId[i.UUID] = i
+	}
+
+	parent, ok := byId[item.ParentUUID]
+	if !ok {
+		return errItemNotFound
+	}
+
+	depth := 1
+	for ; ; depth++ {
+		if parent.UUID == item.UUID || depth > maxItemDepth {
+			return errInvalidParent
+		}
+
+		if parent, ok = byId[parent.ParentUUID]; !ok {
+			break
+		}
+	}
+
+	// the item brings its own subtasks with it
+	if stored, err := tx.RetrieveItem(user, item.UUID.String()); err == nil {
+		if stored.ListUUID != item.ListUUID {
+			if items, err = tx.RetrieveAllItems(user, stored.ListUUID.String()); err != nil {
+				return err
+			}
+		}
+
+		if depth+itemHeight(items, item.UUID) > maxItemDepth {
+			return errInvalidParent
+		}
+	} else if depth+1 > maxItemDepth {
+		return errInvalidParent
+	}
+
+	return nil
+}
+
+// itemHeight is the number of levels in the item's subtree, counting the item.
+func itemHeight(items []models.Item, id uuid.UUID) int {
+	height := 1
+	for _, item := range items {
+		if item.ParentUUID == id && item.UUID != id {
+			if h := itemHeight(items, item.UUID) + 1; h > height {
+				height = h
+			}
+		}
+	}
+	return height
+}
+
+// getItemTreeHandler returns the items of a list nested under their parents,
+// with how many of each item's subtasks are done.
+func getItemTreeHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		listId := chi.URLParam(r, "listId")
+
+		list, err := store.RetrieveList(user, listId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		items, err := store.RetrieveAllItems(user, listId)
+		if err == nil {
+			err = markBlocked(store, user, items)
+		}
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Items []itemNode `json:"items"`
+		}{Items: itemTree(list, items)})
+	}
+}
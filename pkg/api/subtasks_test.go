@@ -0,0 +1,124 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+func TestItemMoveWithoutParentKeepsParent(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	ts := time.Now().UTC().Unix() - 60
+
+	list := models.List{UUID: uuid.New(), Title: "list", Position: "m"}
+	parent := models.Item{UUID: uuid.New(), Title: "parent", ListUUID: list.UUID, Position: "m"}
+	subtask := models.Item{UUID: uuid.New(), Title: "subtask", ListUUID: list.UUID, ParentUUID: parent.UUID, Position: "m"}
+	for _, entry := range []models.History{
+		historyEntry(CmdListCreate, list, ts),
+		historyEntry(CmdItemCreate, parent, ts),
+		historyEntry(CmdItemCreate, subtask, ts),
+	} {
+		if result := a.sync(user, entry); result.Status != StatusApplied {
+			t.Fatalf("%s was not applied: %+v", entry.Command, result)
+		}
+	}
+
+	expectParent := func(store db.Store, what string, want uuid.UUID) {
+		t.Helper()
+		item, err := store.RetrieveItem(user.id, subtask.UUID.String())
+		if err != nil || item.ParentUUID != want {
+			t.Errorf("%s: expected the parent to be %s, got %+v %v", what, want, item, err)
+		}
+	}
+
+	reorder := historyEntry(CmdItemMove, map[string]interface{}{"uuid": subtask.UUID, "position": "b"}, ts+1)
+	if result := a.sync(user, reorder); result.Status != StatusApplied {
+		t.Fatalf("reorder was not applied: %+v", result)
+	}
+	expectParent(a.store, "reorder", parent.UUID)
+
+	history, _ := a.store.GetHistorySince(user.id, 0)
+	replayed := db.NewMemoryStore()
+	if _, err := Replay(replayed, user.id, history); err != nil {
+		t.Fatalf("Failed to replay: %s", err)
+	}
+	expectParent(replayed, "replayed reorder", parent.UUID)
+
+	unparent := historyEntry(CmdItemMove, map[string]interface{}{"uuid": subtask.UUID, "parent_uuid": nil, "position": "c"}, ts+2)
+	if result := a.sync(user, unparent); result.Status != StatusApplied {
+		t.Fatalf("move out of the parent was not applied: %+v", result)
+	}
+	expectParent(a.store, "null parent", uuid.Nil)
+}
+
+func TestItemTree(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	list := a.createList(user, "list")
+	parent := a.createItem(user, list, "parent")
+	a.createItem(user, list, "other")
+
+	subtask := func(parent models.Item, title string) models.Item {
+		var item models.Item
+		body := map[string]interface{}{"title": title, "parent_uuid": parent.UUID}
+		a.must(user, http.StatusCreated, "POST", "/lists/"+list.UUID.String()+"/items", body, &item)
+		return item
+	}
+	done := subtask(parent, "done")
+	child := subtask(parent, "todo")
+	subtask(child, "nested")
+	a.must(user, http.StatusOK, "PATCH", "/items/"+done.UUID.String(), map[string]interface{}{"state": models.ItemStateDone}, nil)
+
+	var tree struct {
+		Items []itemNode `json:"items"`
+	}
+	a.must(user, http.StatusOK, "GET", "/lists/"+list.UUID.String()+"/items/tree", nil, &tree)
+
+	if len(tree.Items) != 2 || tree.Items[0].Title != "parent" || tree.Items[1].Title != "other" {
+		t.Fatalf("expected parent and other at the top level, got %+v", tree.Items)
+	}
+
+	node := tree.Items[0]
+	if node.Done != 1 || node.Total != 2 || len(node.Children) != 2 {
+		t.Errorf("expected 1 of 2 subtasks done, got %d of %d %+v", node.Done, node.Total, node.Children)
+	}
+	if nested := node.Children[1]; nested.Title != "todo" || len(nested.Children) != 1 || nested.Children[0].Title != "nested" {
+		t.Errorf("expected nested under todo, got %+v", nested)
+	}
+}
+
+func TestSubtaskDepthAndCycles(t *testing.T) {
+	a := newTestApi(t)
+	user := a.newUser("user@example.com")
+	list := a.createList(user, "list")
+	path := "/lists/" + list.UUID.String() + "/items"
+
+	items := []models.Item{a.createItem(user, list, "1")}
+	for depth := 2; depth <= maxItemDepth; depth++ {
+		var item models.Item
+		body := map[string]interface{}{"title": "item", "parent_uuid": items[len(items)-1].UUID}
+		a.must(user, http.StatusCreated, "POST", path, body, &item)
+		items = append(items, item)
+	}
+	deepest := items[len(items)-1]
+
+	if status := a.do(user, "POST", path, map[string]interface{}{"title": "too deep", "parent_uuid": deepest.UUID}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("nesting past maxItemDepth: expected %d, got %d", http.StatusUnprocessableEntity, status)
+	}
+
+	if status := a.do(user, "PATCH", "/items/"+items[0].UUID.String(), map[string]interface{}{"parent_uuid": deepest.UUID}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("moving an item under its own subtask: expected %d, got %d", http.StatusUnprocessableEntity, status)
+	}
+
+	// deleting an item takes its subtasks with it
+	a.must(user, http.StatusNoContent, "DELETE", "/items/"+items[0].UUID.String(), nil, nil)
+	if got := a.itemTitles(user, list); got != "" {
+		t.Errorf("expected the subtasks to be deleted, got %s", got)
+	}
+}
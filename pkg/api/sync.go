@@ -0,0 +1,1339 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+	"ismacaulay/procrast-api/pkg/position"
+
+	"github.com/google/uuid"
+)
+
+const (
+	StatusApplied    = "applied"
+	StatusSkipped    = "skipped"
+	StatusConflicted = "conflicted"
+	StatusFailed     = "failed"
+	StatusAborted    = "aborted"
+)
+
+// Error codes reported for failed history entries.
+const (
+	ErrCodeInvalidState    = "invalid_state"
+	ErrCodeUnknownCommand  = "unknown_command"
+	ErrCodeListNotFound    = "list_not_found"
+	ErrCodeItemNotFound    = "item_not_found"
+	ErrCodeTagNotFound     = "tag_not_found"
+	ErrCodeCommentNotFound = "comment_not_found"
+	ErrCodeInvalidParent   = "invalid_parent"
+	ErrCodeInvalidAssignee = "invalid_assignee"
+	ErrCodeDependencyCycle = "dependency_cycle"
+	ErrCodeUnknownState    = "unknown_state"
+	ErrCodeForbidden       = "forbidden"
+	ErrCodeInternal        = "internal_error"
+)
+
+// syncError is returned when a history entry cannot be applied because of
+// what the client sent.
+type syncError struct {
+	Code string
+}
+
+func (e *syncError) Error() string {
+	return e.Code
+}
+
+var (
+	errInvalidState    = &syncError{Code: ErrCodeInvalidState}
+	errUnknownCommand  = &syncError{Code: ErrCodeUnknownCommand}
+	errListNotFound    = &syncError{Code: ErrCodeListNotFound}
+	errItemNotFound    = &syncError{Code: ErrCodeItemNotFound}
+	errTagNotFound     = &syncError{Code: ErrCodeTagNotFound}
+	errCommentNotFound = &syncError{Code: ErrCodeCommentNotFound}
+	errInvalidParent   = &syncError{Code: ErrCodeInvalidParent}
+	errInvalidAssignee = &syncError{Code: ErrCodeInvalidAssignee}
+	errDependencyCycle = &syncError{Code: ErrCodeDependencyCycle}
+	errUnknownState    = &syncError{Code: ErrCodeUnknownState}
+	errForbidden       = &syncError{Code: ErrCodeForbidden}
+)
+
+// errorCode returns the code reported to the client for err.
+func errorCode(err error) string {
+	if e, ok := err.(*syncError); ok {
+		return e.Code
+	}
+	return ErrCodeInternal
+}
+
+// deletedState is reported as the server state when a history entry refers to
+// a list or item that has been deleted.
+type deletedState struct {
+	UUID    uuid.UUID `json:"uuid"`
+	Deleted bool      `json:"deleted"`
+}
+
+type uuidState struct {
+	UUID uuid.UUID `json:"uuid"`
+}
+
+var deleteCommands = map[string]string{
+	CmdListCreate:  CmdListDelete,
+	CmdListUpdate:  CmdListDelete,
+	CmdListDelete:  CmdListDelete,
+	CmdListRestore: CmdListDelete,
+	CmdListMove:    CmdListDelete,
+	CmdItemCreate:  CmdItemDelete,
+	CmdItemUpdate:  CmdItemDelete,
+	CmdItemDelete:  CmdItemDelete,
+	CmdItemRestore: CmdItemDelete,
+	CmdItemMove:    CmdItemDelete,
+	CmdTagCreate:   CmdTagDelete,
+	CmdTagUpdate:   CmdTagDelete,
+	CmdTagDelete:   CmdTagDelete,
+
+	CmdItemComment:       CmdItemCommentDelete,
+	CmdItemCommentUpdate: CmdItemCommentDelete,
+	CmdItemCommentDelete: CmdItemCommentDelete,
+}
+
+// applyHistory applies a single history entry submitted by a client and
+// stores it. When the entry loses to newer server state the stored entry is
+// rewritten to the winning state, so devices replaying the history converge
+// on what the server has. Applied entries that change a shared list are
+// copied to the history of its other members.
+func applyHistory(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	lists := historyLists(tx, user, history)
+
+	var status string
+	var server interface{}
+	var err error
+	switch history.Command {
+	case CmdListCreate:
+		status, server, err = applyListCreate(tx, user, history)
+	case CmdListUpdate:
+		status, server, err = applyListUpdate(tx, user, history)
+	case CmdListDelete:
+		status, server, err = applyListDelete(tx, user, history)
+	case CmdListRestore:
+		status, server, err = applyListRestore(tx, user, history)
+	case CmdListMove:
+		status, server, err = applyListMove(tx, user, history)
+	case CmdItemCreate:
+		status, server, err = applyItemCreate(tx, user, history)
+	case CmdItemUpdate:
+		status, server, err = applyItemUpdate(tx, user, history)
+	case CmdItemDelete:
+		status, server, err = applyItemDelete(tx, user, history)
+	case CmdItemRestore:
+		status, server, err = applyItemRestore(tx, user, history)
+	case CmdItemMove:
+		status, server, err = applyItemMove(tx, user, history)
+	case CmdItemTag:
+		status, server, err = applyItemTag(tx, user, history)
+	case CmdItemUntag:
+		status, server, err = applyItemUntag(tx, user, history)
+	case CmdItemBlock:
+		status, server, err = applyItemBlock(tx, user, history)
+	case CmdItemUnblock:
+		status, server, err = applyItemUnblock(tx, user, history)
+	case CmdItemAssign:
+		status, server, err = applyItemAssignment(tx, user, history, true)
+	case CmdItemUnassign:
+		status, server, err = applyItemAssignment(tx, user, history, false)
+	case CmdItemComment:
+		status, server, err = applyItemComment(tx, user, history)
+	case CmdItemCommentUpdate:
+		status, server, err = applyItemCommentUpdate(tx, user, history)
+	case CmdItemCommentDelete:
+		status, server, err = applyItemCommentDelete(tx, user, history)
+	case CmdTagCreate:
+		status, server, err = applyTagCreate(tx, user, history)
+	case CmdTagUpdate:
+		status, server, err = applyTagUpdate(tx, user, history)
+	case CmdTagDelete:
+		status, server, err = applyTagDelete(tx, user, history)
+	default:
+		err = errUnknownCommand
+	}
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	record := history
+	if status == StatusConflicted {
+		if deleted, ok := server.(deletedState); ok {
+			record.Command = deleteCommands[history.Command]
+			server = deleted
+			record.State, err = json.Marshal(uuidState{UUID: deleted.UUID})
+		} else {
+			if assignment, ok := server.(assigneeState); ok {
+				record.Command = assignment.command()
+			}
+			record.State, err = json.Marshal(server)
+		}
+
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err := tx.CreateHistory(user, record); err != nil {
+		return "", nil, err
+	}
+
+	if status == StatusApplied {
+		if err := shareHistory(tx, user, record, lists...); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return status, server, nil
+}
+
+// decodeState decodes a history state into state and records which fields
+// were present so that partial states only touch the fields they contain.
+// Every state must have a uuid.
+func decodeState(encoded []byte, state interface{}) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(encoded, state); err != nil {
+		return nil, errInvalidState
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, errInvalidState
+	}
+
+	if _, ok := fields["uuid"]; !ok {
+		return nil, errInvalidState
+	}
+
+	return fields, nil
+}
+
+// accessError reports a write the user has no access to as notFound, so ids
+// belonging to other users look the same as ids that do not exist.
+func accessError(err, notFound error) error {
+	if err == db.ErrNoAccess {
+		return notFound
+	}
+	return err
+}
+
+// requireEditor fails with forbidden if the user is a member of the list that
+// can only view it.
+func requireEditor(list models.List) error {
+	if !models.CanEdit(list.Role) {
+		return errForbidden
+	}
+	return nil
+}
+
+// missingEntity reports a conflict if the entity has been deleted, otherwise
+// notFound is returned.
+func missingEntity(tx db.Store, user string, id uuid.UUID, notFound error) (string, interface{}, error) {
+	deleted, tombErr := tx.IsTombstoned(user, id)
+	if tombErr != nil {
+		return "", nil, tombErr
+	}
+
+	if !deleted {
+		return "", nil, notFound
+	}
+
+	return StatusConflicted, deletedState{UUID: id, Deleted: true}, nil
+}
+
+// fieldMerger resolves an update per field with last-writer-wins. A field is
+// written if it is present in the update, differs from the stored value and
+// the update is not older than the stored field.
+type fieldMerger struct {
+	fields   map[string]json.RawMessage
+	clock    *models.Clock
+	fallback int64
+	ts       int64
+
+	applied    bool
+	conflicted bool
+}
+
+func newFieldMerger(fields map[string]json.RawMessage, clock *models.Clock, modified, ts int64) *fieldMerger {
+	return &fieldMerger{fields: fields, clock: clock, fallback: modified, ts: ts}
+}
+
+func (m *fieldMerger) wins(field string, differs bool) bool {
+	if _, ok := m.fields[field]; !ok || !differs {
+		return false
+	}
+
+	if m.ts < m.clock.Get(field, m.fallback) {
+		m.conflicted = true
+		return false
+	}
+
+	m.clock.Set(field, m.ts)
+	m.applied = true
+	return true
+}
+
+func (m *fieldMerger) status() string {
+	if m.conflicted {
+		return StatusConflicted
+	}
+
+	if m.applied {
+		return StatusApplied
+	}
+
+	return StatusSkipped
+}
+
+func applyListCreate(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state models.List
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	// lists from clients that don't know about workflows get the default
+	if state.Workflow == nil {
+		state.Workflow = append([]models.WorkflowState(nil), models.DefaultWorkflow...)
+	} else if !validWorkflow(state.Workflow) {
+		return "", nil, errInvalidState
+	}
+
+	if deleted, err := tx.IsTombstoned(user, state.UUID); err != nil {
+		return "", nil, err
+	} else if deleted {
+		return StatusConflicted, deletedState{UUID: state.UUID, Deleted: true}, nil
+	}
+
+	if list, err := tx.RetrieveList(user, state.UUID.String()); err == nil {
+		return StatusSkipped, list, nil
+	}
+
+	if state.WorkspaceUUID != uuid.Nil {
+		if workspace, err := tx.RetrieveWorkspace(user, state.WorkspaceUUID.String()); err != nil || !models.CanEdit(workspace.Role) {
+			return "", nil, errForbidden
+		}
+	}
+
+	if state.Position == "" {
+		pos, err := newListPosition(tx, user)
+		if err != nil {
+			return "", nil, err
+		}
+		state.Position = pos
+	} else if !position.Valid(state.Position) {
+		return "", nil, errInvalidState
+	}
+
+	// the id is taken by a list the user can't see
+	state.Clock = nil
+	if err := tx.CreateList(user, state); err != nil {
+		return "", nil, accessError(err, errForbidden)
+	}
+
+	if state.WorkspaceUUID != uuid.Nil {
+		if err := addWorkspaceMembers(tx, user, state, time.Now().UTC().Unix()); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return StatusApplied, state, nil
+}
+
+func applyListUpdate(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state models.List
+	fields, err := decodeState(history.State, &state)
+	if err != nil {
+		return "", nil, err
+	}
+
+	list, err := tx.RetrieveList(user, state.UUID.String())
+	if err != nil {
+		return missingEntity(tx, user, state.UUID, errListNotFound)
+	}
+
+	if err := requireEditor(list); err != nil {
+		return "", nil, err
+	}
+
+	m := newFieldMerger(fields, &list.Clock, list.Modified, history.Timestamp)
+	if m.wins("title", list.Title != state.Title) {
+		list.Title = state.Title
+	}
+
+	if m.wins("description", list.Description != state.Description) {
+		list.Description = state.Description
+	}
+
+	if _, ok := fields["workflow"]; ok && !validWorkflow(state.Workflow) {
+		return "", nil, errInvalidState
+	}
+
+	if m.wins("workflow", !sameWorkflow(list.States(), state.Workflow)) {
+		list.Workflow = state.Workflow
+	}
+
+	if m.applied {
+		if state.Modified > list.Modified {
+			list.Modified = state.Modified
+		}
+
+		if err := tx.UpdateList(user, list); err != nil {
+			return "", nil, accessError(err, errListNotFound)
+		}
+	}
+
+	return m.status(), list, nil
+}
+
+func applyListDelete(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state uuidState
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	list, err := tx.RetrieveList(user, state.UUID.String())
+	if err != nil {
+		status, server, err := missingEntity(tx, user, state.UUID, errListNotFound)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// deleting something that is already deleted is a no-op
+		if status == StatusConflicted {
+			status = StatusSkipped
+		}
+		return status, server, nil
+	}
+
+	if list.Role != models.RoleOwner {
+		return "", nil, errForbidden
+	}
+
+	if err := deleteList(tx, user, list, history.Timestamp); err != nil {
+		return "", nil, accessError(err, errListNotFound)
+	}
+
+	return StatusApplied, deletedState{UUID: list.UUID, Deleted: true}, nil
+}
+
+// applyListRestore takes a list out of the trash unless it was deleted again
+// after the restore happened.
+func applyListRestore(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state uuidState
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	if list, err := tx.RetrieveList(user, state.UUID.String()); err == nil {
+		return StatusSkipped, list, nil
+	}
+
+	list, err := tx.RetrieveTrashedList(user, state.UUID.String())
+	if err != nil {
+		return "", nil, errListNotFound
+	}
+
+	if history.Timestamp < list.Deleted {
+		return StatusConflicted, deletedState{UUID: list.UUID, Deleted: true}, nil
+	}
+
+	if err := restoreList(tx, user, list); err != nil {
+		return "", nil, accessError(err, errListNotFound)
+	}
+
+	list.Deleted = 0
+	return StatusApplied, list, nil
+}
+
+// applyListMove and applyItemMove resolve concurrent moves of the same list
+// or item with last-writer-wins. Different lists or items moved to the same
+// place end up with the same position and are ordered by uuid. List positions
+// are the user's own, so any member can move a list.
+func applyListMove(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state positionState
+	fields, err := decodeState(history.State, &state)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !position.Valid(state.Position) {
+		return "", nil, errInvalidState
+	}
+
+	list, err := tx.RetrieveList(user, state.UUID.String())
+	if err != nil {
+		return missingEntity(tx, user, state.UUID, errListNotFound)
+	}
+
+	m := newFieldMerger(fields, &list.Clock, list.Modified, history.Timestamp)
+	if m.wins("position", list.Position != state.Position) {
+		list.Position = state.Position
+	}
+
+	if m.applied {
+		if err := tx.MoveList(user, list.UUID, list.Position, history.Timestamp); err != nil {
+			return "", nil, accessError(err, errListNotFound)
+		}
+	}
+
+	return m.status(), list, nil
+}
+
+func applyItemCreate(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state models.Item
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	if deleted, err := tx.IsTombstoned(user, state.UUID); err != nil {
+		return "", nil, err
+	} else if deleted {
+		return StatusConflicted, deletedState{UUID: state.UUID, Deleted: true}, nil
+	}
+
+	if item, err := tx.RetrieveItem(user, state.UUID.String()); err == nil {
+		return StatusSkipped, item, nil
+	}
+
+	list, err := tx.RetrieveList(user, state.ListUUID.String())
+	if err != nil {
+		status, _, err := missingEntity(tx, user, state.ListUUID, errListNotFound)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// the item was created in a list that has since been deleted
+		if err := tx.CreateTombstone(user, state.UUID, history.Timestamp); err != nil {
+			return "", nil, err
+		}
+		return status, deletedState{UUID: state.UUID, Deleted: true}, nil
+	}
+
+	if state.ParentUUID != uuid.Nil {
+		if _, err := tx.RetrieveItem(user, state.ParentUUID.String()); err != nil {
+			status, _, err := missingEntity(tx, user, state.ParentUUID, errItemNotFound)
+			if err != nil {
+				return "", nil, err
+			}
+
+			// subtasks of a deleted item are deleted with it
+			if err := tx.CreateTombstone(user, state.UUID, history.Timestamp); err != nil {
+				return "", nil, err
+			}
+			return status, deletedState{UUID: state.UUID, Deleted: true}, nil
+		}
+
+		if err := checkParent(tx, user, state); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if !validRRule(state.RRule) {
+		return "", nil, errInvalidState
+	}
+
+	if err := requireEditor(list); err != nil {
+		return "", nil, err
+	}
+
+	// a nil uuid is nobody, the same as leaving it out
+	state.Assign(state.Assignee())
+	if state.AssigneeUUID != nil {
+		if member, err := isMember(tx, user, list.UUID, state.Assignee()); err != nil {
+			return "", nil, err
+		} else if !member {
+			return "", nil, errInvalidAssignee
+		}
+	}
+
+	if _, ok := list.State(state.State); !ok {
+		return "", nil, errUnknownState
+	}
+
+	if state.RRule != "" && state.Occurrence == 0 {
+		state.Occurrence = 1
+	}
+
+	if state.Position == "" {
+		pos, err := newItemPosition(tx, user, state.ListUUID)
+		if err != nil {
+			return "", nil, err
+		}
+		state.Position = pos
+	} else if !position.Valid(state.Position) {
+		return "", nil, errInvalidState
+	}
+
+	// the list was found, so the id is taken by an item the user can't see
+	state.Clock = nil
+	if err := tx.CreateItem(user, state); err != nil {
+		return "", nil, accessError(err, errForbidden)
+	}
+
+	return StatusApplied, state, nil
+}
+
+func applyItemUpdate(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state models.Item
+	fields, err := decodeState(history.State, &state)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, ok := fields["rrule"]; ok && !validRRule(state.RRule) {
+		return "", nil, errInvalidState
+	}
+
+	item, err := tx.RetrieveItem(user, state.UUID.String())
+	if err != nil {
+		return missingEntity(tx, user, state.UUID, errItemNotFound)
+	}
+
+	list, err := tx.RetrieveList(user, item.ListUUID.String())
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := requireEditor(list); err != nil {
+		return "", nil, err
+	}
+
+	if _, ok := fields["state"]; ok {
+		if _, ok := list.State(state.State); !ok {
+			return "", nil, errUnknownState
+		}
+	}
+
+	previous := item
+	m := newFieldMerger(fields, &item.Clock, item.Modified, history.Timestamp)
+	if m.wins("title", item.Title != state.Title) {
+		item.Title = state.Title
+	}
+
+	if m.wins("description", item.Description != state.Description) {
+		item.Description = state.Description
+	}
+
+	if m.wins("state", item.State != state.State) {
+		item.State = state.State
+	}
+
+	if m.wins("due", item.Due != state.Due) {
+		item.Due = state.Due
+	}
+
+	if m.wins("start", item.Start != state.Start) {
+		item.Start = state.Start
+	}
+
+	if m.wins("rrule", item.RRule != state.RRule) {
+		item.RRule = state.RRule
+	}
+
+	if m.applied {
+		if state.Modified > item.Modified {
+			item.Modified = state.Modified
+		}
+
+		if err := tx.UpdateItem(user, item); err != nil {
+			return "", nil, accessError(err, errItemNotFound)
+		}
+
+		if completesOccurrence(list, previous, item) {
+			if _, err := spawnNextOccurrence(tx, user, item, time.Now().UTC().Unix()); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	return m.status(), item, nil
+}
+
+// applyItemMove treats the list, parent and position as one field, so an item
+// never ends up with a position from one list in another. Moving into a list
+// or under a parent that has been deleted conflicts and leaves the item where
+// it is, as does a move that would nest the item too deeply or under itself.
+// The state of card moves is merged like the state of ITEM UPDATE.
+func applyItemMove(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state itemMoveState
+	fields, err := decodeState(history.State, &state)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !position.Valid(state.Position) {
+		return "", nil, errInvalidState
+	}
+
+	item, err := tx.RetrieveItem(user, state.UUID.String())
+	if err != nil {
+		return missingEntity(tx, user, state.UUID, errItemNotFound)
+	}
+
+	if !canEdit(tx, user, item.ListUUID) {
+		return "", nil, errForbidden
+	}
+
+	list := item.ListUUID
+	if state.ListUUID != uuid.Nil && state.ListUUID != item.ListUUID {
+		target, err := tx.RetrieveList(user, state.ListUUID.String())
+		if err != nil {
+			status, _, err := missingEntity(tx, user, state.ListUUID, errListNotFound)
+			return status, item, err
+		}
+
+		if err := requireEditor(target); err != nil {
+			return "", nil, err
+		}
+		list = state.ListUUID
+	}
+
+	if _, ok := fields["parent_uuid"]; !ok {
+		state.ParentUUID = keptParent(item, list)
+	}
+
+	if state.ParentUUID != uuid.Nil && state.ParentUUID != item.ParentUUID {
+		if _, err := tx.RetrieveItem(user, state.ParentUUID.String()); err != nil {
+			status, _, err := missingEntity(tx, user, state.ParentUUID, errItemNotFound)
+			return status, item, err
+		}
+	}
+
+	if state.State != nil {
+		target, err := tx.RetrieveList(user, list.String())
+		if err != nil {
+			return "", nil, err
+		}
+
+		if _, ok := target.State(*state.State); !ok {
+			return "", nil, errUnknownState
+		}
+	}
+
+	moved := item
+	moved.ListUUID = list
+	moved.ParentUUID = state.ParentUUID
+	if moved.ListUUID != item.ListUUID || moved.ParentUUID != item.ParentUUID {
+		if err := checkParent(tx, user, moved); err == errInvalidParent {
+			return StatusConflicted, item, nil
+		} else if err != nil {
+			return "", nil, err
+		}
+	}
+
+	previous := item
+	m := newFieldMerger(fields, &item.Clock, item.Modified, history.Timestamp)
+	if m.wins("position", item.Position != state.Position || item.ListUUID != list || item.ParentUUID != state.ParentUUID) {
+		item.Position = state.Position
+		item.ListUUID = list
+		item.ParentUUID = state.ParentUUID
+	}
+
+	if state.State != nil && m.wins("state", item.State != *state.State) {
+		item.State = *state.State
+		if state.Modified > item.Modified {
+			item.Modified = state.Modified
+		}
+	}
+
+	if m.applied {
+		if err := tx.UpdateItem(user, item); err != nil {
+			return "", nil, accessError(err, errItemNotFound)
+		}
+
+		target, err := tx.RetrieveList(user, item.ListUUID.String())
+		if err != nil {
+			return "", nil, err
+		}
+
+		if completesOccurrence(target, previous, item) {
+			if _, err := spawnNextOccurrence(tx, user, item, time.Now().UTC().Unix()); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	return m.status(), item, nil
+}
+
+func applyItemDelete(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state uuidState
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	item, err := tx.RetrieveItem(user, state.UUID.String())
+	if err != nil {
+		status, server, err := missingEntity(tx, user, state.UUID, errItemNotFound)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if status == StatusConflicted {
+			status = StatusSkipped
+		}
+		return status, server, nil
+	}
+
+	if !canEdit(tx, user, item.ListUUID) {
+		return "", nil, errForbidden
+	}
+
+	if err := deleteItem(tx, user, item, history.Timestamp); err != nil {
+		return "", nil, accessError(err, errItemNotFound)
+	}
+
+	return StatusApplied, deletedState{UUID: item.UUID, Deleted: true}, nil
+}
+
+func applyItemRestore(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state uuidState
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	if item, err := tx.RetrieveItem(user, state.UUID.String()); err == nil {
+		return StatusSkipped, item, nil
+	}
+
+	item, err := tx.RetrieveTrashedItem(user, state.UUID.String())
+	if err != nil {
+		return "", nil, errItemNotFound
+	}
+
+	// the item stays deleted while its list or parent is in the trash
+	if !restorable(tx, user, item) || history.Timestamp < item.Deleted {
+		return StatusConflicted, deletedState{UUID: item.UUID, Deleted: true}, nil
+	}
+
+	if err := restoreItem(tx, user, item); err != nil {
+		return "", nil, accessError(err, errItemNotFound)
+	}
+
+	item.Deleted = 0
+	return StatusApplied, item, nil
+}
+
+// applyTagCreate and the other tag commands follow the list commands, tags
+// are deleted outright instead of going to the trash.
+func applyTagCreate(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state models.Tag
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	if !validTagName(state.Name) || !validColor(state.Color) {
+		return "", nil, errInvalidState
+	}
+
+	if deleted, err := tx.IsTombstoned(user, state.UUID); err != nil {
+		return "", nil, err
+	} else if deleted {
+		return StatusConflicted, deletedState{UUID: state.UUID, Deleted: true}, nil
+	}
+
+	if tag, err := tx.RetrieveTag(user, state.UUID.String()); err == nil {
+		return StatusSkipped, tag, nil
+	}
+
+	state.Clock = nil
+	if err := tx.CreateTag(user, state); err != nil {
+		return "", nil, err
+	}
+
+	return StatusApplied, state, nil
+}
+
+func applyTagUpdate(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state models.Tag
+	fields, err := decodeState(history.State, &state)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, ok := fields["name"]; ok && !validTagName(state.Name) {
+		return "", nil, errInvalidState
+	}
+
+	if _, ok := fields["color"]; ok && !validColor(state.Color) {
+		return "", nil, errInvalidState
+	}
+
+	tag, err := tx.RetrieveTag(user, state.UUID.String())
+	if err != nil {
+		return missingEntity(tx, user, state.UUID, errTagNotFound)
+	}
+
+	m := newFieldMerger(fields, &tag.Clock, tag.Modified, history.Timestamp)
+	if m.wins("name", tag.Name != state.Name) {
+		tag.Name = state.Name
+	}
+
+	if m.wins("color", tag.Color != state.Color) {
+		tag.Color = state.Color
+	}
+
+	if m.applied {
+		if state.Modified > tag.Modified {
+			tag.Modified = state.Modified
+		}
+
+		if err := tx.UpdateTag(user, tag); err != nil {
+			return "", nil, accessError(err, errTagNotFound)
+		}
+	}
+
+	return m.status(), tag, nil
+}
+
+func applyTagDelete(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state uuidState
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	tag, err := tx.RetrieveTag(user, state.UUID.String())
+	if err != nil {
+		return missingLinked(tx, user, state.UUID, errTagNotFound)
+	}
+
+	if err := deleteTag(tx, user, tag, history.Timestamp); err != nil {
+		return "", nil, accessError(err, errTagNotFound)
+	}
+
+	return StatusApplied, deletedState{UUID: tag.UUID, Deleted: true}, nil
+}
+
+// missingLinked skips changes that only concern something already deleted,
+// like tagging a deleted item, since the deletion already undid them.
+func missingLinked(tx db.Store, user string, id uuid.UUID, notFound error) (string, interface{}, error) {
+	status, server, err := missingEntity(tx, user, id, notFound)
+	if status == StatusConflicted {
+		status = StatusSkipped
+	}
+	return status, server, err
+}
+
+// decodeItemTag decodes an ITEM TAG or ITEM UNTAG entry and loads the item
+// and tag it refers to. status is set if the entry cannot be applied.
+func decodeItemTag(tx db.Store, user string, history models.History) (itemTagState, string, interface{}, error) {
+	var state itemTagState
+	if _, err := decodeState(history.State, &state); err != nil {
+		return state, "", nil, err
+	}
+
+	if state.TagUUID == uuid.Nil {
+		return state, "", nil, errInvalidState
+	}
+
+	if _, err := tx.RetrieveItem(user, state.UUID.String()); err != nil {
+		status, server, err := missingLinked(tx, user, state.UUID, errItemNotFound)
+		return state, status, server, err
+	}
+
+	if _, err := tx.RetrieveTag(user, state.TagUUID.String()); err != nil {
+		status, server, err := missingLinked(tx, user, state.TagUUID, errTagNotFound)
+		return state, status, server, err
+	}
+
+	return state, "", nil, nil
+}
+
+func applyItemTag(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	state, status, server, err := decodeItemTag(tx, user, history)
+	if err != nil || status != "" {
+		return status, server, err
+	}
+
+	if tagged, err := hasTag(tx, user, state.UUID, state.TagUUID); err != nil {
+		return "", nil, err
+	} else if tagged {
+		return StatusSkipped, state, nil
+	}
+
+	if err := tx.TagItem(user, state.UUID, state.TagUUID, history.Timestamp); err != nil {
+		return "", nil, accessError(err, errItemNotFound)
+	}
+
+	return StatusApplied, state, nil
+}
+
+func applyItemUntag(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	state, status, server, err := decodeItemTag(tx, user, history)
+	if err != nil || status != "" {
+		return status, server, err
+	}
+
+	if tagged, err := hasTag(tx, user, state.UUID, state.TagUUID); err != nil {
+		return "", nil, err
+	} else if !tagged {
+		return StatusSkipped, state, nil
+	}
+
+	if err := tx.UntagItem(user, state.UUID, state.TagUUID); err != nil {
+		return "", nil, accessError(err, errItemNotFound)
+	}
+
+	return StatusApplied, state, nil
+}
+
+// decodeDependency decodes an ITEM BLOCK or ITEM UNBLOCK entry and loads the
+// dependencies of the user. status is set if the entry cannot be applied.
+func decodeDependency(tx db.Store, user string, history models.History) (dependencyState, []models.Dependency, string, interface{}, error) {
+	var state dependencyState
+	if _, err := decodeState(history.State, &state); err != nil {
+		return state, nil, "", nil, err
+	}
+
+	if state.BlockerUUID == uuid.Nil {
+		return state, nil, "", nil, errInvalidState
+	}
+
+	for _, id := range []uuid.UUID{state.UUID, state.BlockerUUID} {
+		if _, err := tx.RetrieveItem(user, id.String()); err != nil {
+			status, server, err := missingLinked(tx, user, id, errItemNotFound)
+			return state, nil, status, server, err
+		}
+	}
+
+	if item, err := tx.RetrieveItem(user, state.UUID.String()); err != nil || !canEdit(tx, user, item.ListUUID) {
+		return state, nil, "", nil, errForbidden
+	}
+
+	dependencies, err := tx.RetrieveDependencies(user)
+	return state, dependencies, "", nil, err
+}
+
+// applyItemBlock fails with dependency_cycle if the dependency would make a
+// cycle, which two devices can do by adding opposite dependencies.
+func applyItemBlock(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	state, dependencies, status, server, err := decodeDependency(tx, user, history)
+	if err != nil || status != "" {
+		return status, server, err
+	}
+
+	if hasDependency(dependencies, state.UUID, state.BlockerUUID) {
+		return StatusSkipped, state, nil
+	}
+
+	if createsCycle(dependencies, state.UUID, state.BlockerUUID) {
+		return "", nil, errDependencyCycle
+	}
+
+	if err := tx.AddDependency(user, state.UUID, state.BlockerUUID, history.Timestamp); err != nil {
+		return "", nil, accessError(err, errItemNotFound)
+	}
+
+	return StatusApplied, state, nil
+}
+
+func applyItemUnblock(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	state, dependencies, status, server, err := decodeDependency(tx, user, history)
+	if err != nil || status != "" {
+		return status, server, err
+	}
+
+	if !hasDependency(dependencies, state.UUID, state.BlockerUUID) {
+		return StatusSkipped, state, nil
+	}
+
+	if err := tx.RemoveDependency(user, state.UUID, state.BlockerUUID); err != nil {
+		return "", nil, accessError(err, errItemNotFound)
+	}
+
+	return StatusApplied, state, nil
+}
+
+// applyItemAssignment assigns the item to a member of its list, or unassigns
+// it, with last-writer-wins. Assigning something that has been deleted is
+// skipped.
+func applyItemAssignment(tx db.Store, user string, history models.History, assign bool) (string, interface{}, error) {
+	var state assigneeState
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	if !assign {
+		state.AssigneeUUID = uuid.Nil
+	} else if state.AssigneeUUID == uuid.Nil {
+		return "", nil, errInvalidState
+	}
+
+	item, err := tx.RetrieveItem(user, state.UUID.String())
+	if err != nil {
+		return missingLinked(tx, user, state.UUID, errItemNotFound)
+	}
+
+	if !canEdit(tx, user, item.ListUUID) {
+		return "", nil, errForbidden
+	}
+
+	if assign {
+		if member, err := isMember(tx, user, item.ListUUID, state.AssigneeUUID); err != nil {
+			return "", nil, err
+		} else if !member {
+			return "", nil, errInvalidAssignee
+		}
+	}
+
+	if history.Timestamp < assigneeClock(item) {
+		return StatusConflicted, assigneeState{UUID: item.UUID, AssigneeUUID: item.Assignee()}, nil
+	}
+
+	if item.Assignee() == state.AssigneeUUID {
+		return StatusSkipped, state, nil
+	}
+
+	item.Assign(state.AssigneeUUID)
+	item.Clock.Set("assignee", history.Timestamp)
+	if err := tx.UpdateItem(user, item); err != nil {
+		return "", nil, accessError(err, errItemNotFound)
+	}
+
+	return StatusApplied, state, nil
+}
+
+// applyItemComment adds a comment written by the user. A comment on an item
+// or a reply to a comment that has since been deleted is deleted with it,
+// and conflicts.
+func applyItemComment(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state models.Comment
+	if _, err := decodeState(history.State, &state); err != nil {
+		return "", nil, err
+	}
+
+	if state.ItemUUID == uuid.Nil || state.Created == 0 || !validCommentBody(state.Body) {
+		return "", nil, errInvalidState
+	}
+
+	if state.Author.String() != user {
+		return "", nil, errForbidden
+	}
+
+	if deleted, err := tx.IsTombstoned(user, state.UUID); err != nil {
+		return "", nil, err
+	} else if deleted {
+		return StatusConflicted, deletedState{UUID: state.UUID, Deleted: true}, nil
+	}
+
+	if comment, err := tx.RetrieveComment(user, state.UUID.String()); err == nil {
+		return StatusSkipped, comment, nil
+	}
+
+	// the comment goes with whatever it was written on
+	gone := func(id uuid.UUID, notFound error) (string, interface{}, error) {
+		status, _, err := missingEntity(tx, user, id, notFound)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if err := tx.CreateTombstone(user, state.UUID, history.Timestamp); err != nil {
+			return "", nil, err
+		}
+		return status, deletedState{UUID: state.UUID, Deleted: true}, nil
+	}
+
+	item, err := tx.RetrieveItem(user, state.ItemUUID.String())
+	if err != nil {
+		return gone(state.ItemUUID, errItemNotFound)
+	}
+
+	if state.ParentUUID != uuid.Nil {
+		parent, err := tx.RetrieveComment(user, state.ParentUUID.String())
+		if err != nil {
+			return gone(state.ParentUUID, errInvalidParent)
+		}
+
+		if parent.ItemUUID != state.ItemUUID {
+			return "", nil, errInvalidParent
+		}
+	}
+
+	if !canEdit(tx, user, item.ListUUID) {
+		return "", nil, errForbidden
+	}
+
+	if err := tx.CreateComment(user, state); err != nil {
+		return "", nil, accessError(err, errItemNotFound)
+	}
+
+	return StatusApplied, state, nil
+}
+
+// decodeComment decodes an ITEM COMMENT UPDATE or ITEM COMMENT DELETE entry
+// and loads the comment, which has to be the user's. status is set if the
+// comment has been deleted.
+func decodeComment(tx db.Store, user string, history models.History, state interface{}) (models.Comment, string, interface{}, error) {
+	if _, err := decodeState(history.State, state); err != nil {
+		return models.Comment{}, "", nil, err
+	}
+
+	var id uuidState
+	if err := json.Unmarshal(history.State, &id); err != nil {
+		return models.Comment{}, "", nil, errInvalidState
+	}
+
+	comment, err := tx.RetrieveComment(user, id.UUID.String())
+	if err != nil {
+		status, server, err := missingEntity(tx, user, id.UUID, errCommentNotFound)
+		return models.Comment{}, status, server, err
+	}
+
+	item, err := tx.RetrieveItem(user, comment.ItemUUID.String())
+	if err != nil || comment.Author.String() != user || !canEdit(tx, user, item.ListUUID) {
+		return models.Comment{}, "", nil, errForbidden
+	}
+
+	return comment, "", nil, nil
+}
+
+// applyItemCommentUpdate edits the comment with last-writer-wins, an edit
+// older than the comment's last one conflicts.
+func applyItemCommentUpdate(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state commentState
+	comment, status, server, err := decodeComment(tx, user, history, &state)
+	if err != nil || status != "" {
+		return status, server, err
+	}
+
+	if !validCommentBody(state.Body) {
+		return "", nil, errInvalidState
+	}
+
+	if history.Timestamp < comment.Edited {
+		return StatusConflicted, comment, nil
+	}
+
+	if comment.Body == state.Body {
+		return StatusSkipped, comment, nil
+	}
+
+	comment.Body = state.Body
+	comment.Edited = history.Timestamp
+	if err := tx.UpdateComment(user, comment); err != nil {
+		return "", nil, accessError(err, errCommentNotFound)
+	}
+
+	return StatusApplied, comment, nil
+}
+
+func applyItemCommentDelete(tx db.Store, user string, history models.History) (string, interface{}, error) {
+	var state uuidState
+	comment, status, server, err := decodeComment(tx, user, history, &state)
+	if err != nil || status != "" {
+		if status == StatusConflicted {
+			status = StatusSkipped
+		}
+		return status, server, err
+	}
+
+	if err := deleteComment(tx, user, comment, history.Timestamp); err != nil {
+		return "", nil, accessError(err, errCommentNotFound)
+	}
+
+	return StatusApplied, deletedState{UUID: comment.UUID, Deleted: true}, nil
+}
+
+// deleteList moves the list and its items to the trash, leaving tombstones so
+// later updates to them from other devices are reported as conflicts.
+func deleteList(tx db.Store, user string, list models.List, ts int64) error {
+	items, err := tx.RetrieveAllItems(user, list.UUID.String())
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := tx.CreateTombstone(user, item.UUID, ts); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.CreateTombstone(user, list.UUID, ts); err != nil {
+		return err
+	}
+
+	return tx.TrashList(user, list, ts)
+}
+
+// deleteItem moves the item and its subtasks to the trash like deleteList.
+func deleteItem(tx db.Store, user string, item models.Item, ts int64) error {
+	items, err := tx.RetrieveAllItems(user, item.ListUUID.String())
+	if err != nil {
+		return err
+	}
+
+	for _, subtask := range subtasksOf(items, item.UUID) {
+		if err := tx.CreateTombstone(user, subtask.UUID, ts); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.CreateTombstone(user, item.UUID, ts); err != nil {
+		return err
+	}
+
+	return tx.TrashItem(user, item, ts)
+}
+
+// restoreList takes the list and the items trashed with it out of the trash
+// and removes their tombstones. list must come from RetrieveTrashedList.
+func restoreList(tx db.Store, user string, list models.List) error {
+	if err := tx.RestoreList(user, list); err != nil {
+		return err
+	}
+
+	items, err := tx.RetrieveAllItems(user, list.UUID.String())
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := tx.DeleteTombstone(user, item.UUID); err != nil {
+			return err
+		}
+	}
+
+	return tx.DeleteTombstone(user, list.UUID)
+}
+
+// restoreItem is restoreList for an item and its subtasks. item must come
+// from RetrieveTrashedItem.
+func restoreItem(tx db.Store, user string, item models.Item) error {
+	if err := tx.RestoreItem(user, item); err != nil {
+		return err
+	}
+
+	items, err := tx.RetrieveAllItems(user, item.ListUUID.String())
+	if err != nil {
+		return err
+	}
+
+	for _, subtask := range subtasksOf(items, item.UUID) {
+		if err := tx.DeleteTombstone(user, subtask.UUID); err != nil {
+			return err
+		}
+	}
+
+	return tx.DeleteTombstone(user, item.UUID)
+}
+
+// restorable reports whether a trashed item can be restored, which needs its
+// list and parent to be out of the trash.
+func restorable(tx db.Store, user string, item models.Item) bool {
+	if _, err := tx.RetrieveList(user, item.ListUUID.String()); err != nil {
+		return false
+	}
+
+	if item.ParentUUID != uuid.Nil {
+		if _, err := tx.RetrieveItem(user, item.ParentUUID.String()); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
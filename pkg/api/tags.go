@@ -0,0 +1,417 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// itemTagState is the state of ITEM TAG and ITEM UNTAG history entries.
+type itemTagState struct {
+	UUID    uuid.UUID `json:"uuid"`
+	TagUUID uuid.UUID `json:"tag_uuid"`
+}
+
+func validTagName(name string) bool {
+	return strings.TrimSpace(name) != ""
+}
+
+// validColor checks the colors of tags and workflow states.
+func validColor(color string) bool {
+	return color == "" || hexColor.MatchString(color)
+}
+
+// hasTag reports whether the item already has the tag.
+func hasTag(tx db.Store, user string, item, tag uuid.UUID) (bool, error) {
+	tags, err := tx.RetrieveItemTags(user, item.String())
+	if err != nil {
+		return false, err
+	}
+
+	for _, t := range tags {
+		if t.UUID == tag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteTag deletes the tag and tombstones it so offline changes to it
+// conflict instead of bringing it back.
+func deleteTag(tx db.Store, user string, tag models.Tag, ts int64) error {
+	if err := tx.CreateTombstone(user, tag.UUID, ts); err != nil {
+		return err
+	}
+
+	return tx.DeleteTag(user, tag)
+}
+
+func getTagsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		tags, err := store.RetrieveAllTags(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Tags []models.Tag `json:"tags"`
+		}{Tags: tags})
+	}
+}
+
+func postTagHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		now := time.Now().UTC().Unix()
+
+		var request struct {
+			Name  *string `json:"name,omitempty"`
+			Color string  `json:"color"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || request.Name == nil || !validTagName(*request.Name) || !validColor(request.Color) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		id, err := uuid.NewRandom()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		tag := models.Tag{
+			UUID:     id,
+			Name:     strings.TrimSpace(*request.Name),
+			Color:    request.Color,
+			Created:  now,
+			Modified: now,
+		}
+
+		err = store.Transaction(func(tx db.Store) error {
+			if err := tx.CreateTag(user, tag); err != nil {
+				return err
+			}
+
+			return createHistoryForState(tx, CmdTagCreate, user, now, tag)
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, tag)
+	}
+}
+
+func getTagHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		tagId := chi.URLParam(r, "tagId")
+
+		tag, err := store.RetrieveTag(user, tagId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, tag)
+	}
+}
+
+// patchTagHandler renames or recolors a tag.
+func patchTagHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		tagId := chi.URLParam(r, "tagId")
+
+		var request struct {
+			Name  *string `json:"name,omitempty"`
+			Color *string `json:"color,omitempty"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil ||
+			(request.Name != nil && !validTagName(*request.Name)) ||
+			(request.Color != nil && !validColor(*request.Color)) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		tag, err := store.RetrieveTag(user, tagId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		update := false
+		if request.Name != nil {
+			tag.Name = strings.TrimSpace(*request.Name)
+			tag.Clock.Set("name", now)
+			update = true
+		}
+
+		if request.Color != nil {
+			tag.Color = *request.Color
+			tag.Clock.Set("color", now)
+			update = true
+		}
+
+		if update {
+			tag.Modified = now
+
+			err = store.Transaction(func(tx db.Store) error {
+				if err := tx.UpdateTag(user, tag); err != nil {
+					return err
+				}
+
+				return createHistoryForState(tx, CmdTagUpdate, user, now, tag)
+			})
+
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+		}
+
+		respondWithJSON(w, http.StatusOK, tag)
+	}
+}
+
+func deleteTagHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		tagId := chi.URLParam(r, "tagId")
+
+		tag, err := store.RetrieveTag(user, tagId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := deleteTag(tx, user, tag, now); err != nil {
+				return err
+			}
+
+			return createHistoryForState(tx, CmdTagDelete, user, now, uuidState{UUID: tag.UUID})
+		})
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func getItemTagsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+
+		if _, err := store.RetrieveItem(user, itemId); err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		tags, err := store.RetrieveItemTags(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Tags []models.Tag `json:"tags"`
+		}{Tags: tags})
+	}
+}
+
+// putItemTagHandler puts a tag on an item, doing nothing if it already has it.
+func putItemTagHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+		tagId := chi.URLParam(r, "tagId")
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		tag, err := store.RetrieveTag(user, tagId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if tagged, err := hasTag(tx, user, item.UUID, tag.UUID); err != nil || tagged {
+				return err
+			}
+
+			if err := tx.TagItem(user, item.UUID, tag.UUID, now); err != nil {
+				return err
+			}
+
+			state := itemTagState{UUID: item.UUID, TagUUID: tag.UUID}
+			return createHistoryForState(tx, CmdItemTag, user, now, state)
+		})
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func deleteItemTagHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		itemId := chi.URLParam(r, "itemId")
+		tagId := chi.URLParam(r, "tagId")
+
+		item, err := store.RetrieveItem(user, itemId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		tag, err := store.RetrieveTag(user, tagId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := tx.UntagItem(user, item.UUID, tag.UUID); err != nil {
+				return err
+			}
+
+			state := itemTagState{UUID: item.UUID, TagUUID: tag.UUID}
+			return createHistoryForState(tx, CmdItemUntag, user, now, state)
+		})
+
+		if err == db.ErrNoAccess {
+			// the item does not have the tag
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// findTags returns the user's tags matching a ?tag= value, which is either a
+// tag uuid or a name compared case insensitively.
+func findTags(store db.Store, user, value string) ([]models.Tag, error) {
+	if _, err := uuid.Parse(value); err == nil {
+		tag, err := store.RetrieveTag(user, value)
+		if err != nil {
+			return []models.Tag{}, nil
+		}
+		return []models.Tag{tag}, nil
+	}
+
+	tags, err := store.RetrieveAllTags(user)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]models.Tag, 0)
+	for _, tag := range tags {
+		if strings.EqualFold(tag.Name, strings.TrimSpace(value)) {
+			matches = append(matches, tag)
+		}
+	}
+	return matches, nil
+}
+
+// getTaggedItemsHandler returns the items from every list that have all of
+// the tags given with ?tag=.
+func getTaggedItemsHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		values := r.URL.Query()["tag"]
+		if len(values) == 0 {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		var matched map[uuid.UUID]models.Item
+		for _, value := range values {
+			tags, err := findTags(store, user, value)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+
+			if len(tags) == 0 {
+				respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+				return
+			}
+
+			tagged := make(map[uuid.UUID]models.Item)
+			for _, tag := range tags {
+				items, err := store.RetrieveTaggedItems(user, tag.UUID.String())
+				if err != nil {
+					respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+
+				for _, item := range items {
+					if _, ok := matched[item.UUID]; matched == nil || ok {
+						tagged[item.UUID] = item
+					}
+				}
+			}
+			matched = tagged
+		}
+
+		items := make([]models.Item, 0, len(matched))
+		for _, item := range matched {
+			items = append(items, item)
+		}
+
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].Created == items[j].Created {
+				return items[i].UUID.String() < items[j].UUID.String()
+			}
+			return items[i].Created < items[j].Created
+		})
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Items []models.Item `json:"items"`
+		}{Items: items})
+	}
+}
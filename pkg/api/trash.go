@@ -0,0 +1,48 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+// DefaultTrashRetention is how long deleted lists and items stay in the trash
+// before they are purged.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// getTrashHandler returns the deleted lists and the items that were deleted on
+// their own. Items deleted with their list are restored with it.
+func getTrashHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		lists, err := store.RetrieveTrashedLists(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		items, err := store.RetrieveTrashedItems(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Lists []models.List `json:"lists"`
+			Items []models.Item `json:"items"`
+		}{Lists: lists, Items: items})
+	}
+}
+
+func (api *Api) purgeTrash() {
+	for range time.Tick(time.Hour) {
+		before := time.Now().UTC().Add(-api.TrashRetention).Unix()
+		if err := api.store.PurgeTrash(before); err != nil {
+			log.Println("Failed to purge trash:", err)
+		}
+	}
+}
@@ -21,7 +21,9 @@ func respondWithError(w http.ResponseWriter, status int, msg string) {
 	respondWithJSON(w, status, map[string]string{"message": msg})
 }
 
-func createHistoryForState(conn db.Conn, cmd, user string, now int64, state interface{}) error {
+// createHistoryForState records a change made by the user. The other members
+// of the given lists get a copy of the entry, see shareHistory.
+func createHistoryForState(store db.Store, cmd, user string, now int64, state interface{}, lists ...uuid.UUID) error {
 	encoded, err := json.Marshal(state)
 	if err != nil {
 		return err
@@ -39,9 +41,9 @@ func createHistoryForState(conn db.Conn, cmd, user string, now int64, state inte
 		Timestamp: now,
 		Created:   now,
 	}
-	if err := db.CreateHistory(conn, user, history); err != nil {
+	if err := store.CreateHistory(user, history); err != nil {
 		return err
 	}
 
-	return nil
+	return shareHistory(store, user, history, lists...)
 }
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+const (
+	defaultUpcomingDays = 7
+	maxUpcomingDays     = 365
+)
+
+// The date views work on calendar days in the user's time zone and leave out
+// items that are finished according to their list's workflow:
+//
+//	overdue  - due before today
+//	today    - due today, or started and not overdue
+//	upcoming - due or starting in the days after today
+type viewResponse struct {
+	TimeZone string        `json:"time_zone"`
+	From     int64         `json:"from,omitempty"`
+	To       int64         `json:"to"`
+	Items    []models.Item `json:"items"`
+}
+
+// userToday returns the start of the current day in the user's time zone.
+func userToday(store db.Store, user string) (time.Time, error) {
+	u, err := store.FindUserByUUID(user)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now().In(userLocation(u.TimeZone))
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()), nil
+}
+
+// viewDate is the date an item is listed under, its due date if it has one.
+func viewDate(item models.Item) int64 {
+	if item.Due != 0 {
+		return item.Due
+	}
+	return item.Start
+}
+
+func within(ts, from, to int64) bool {
+	return ts != 0 && ts >= from && ts < to
+}
+
+// respondWithView returns the items scheduled before to that match include,
+// ordered by their view date.
+func respondWithView(w http.ResponseWriter, store db.Store, user string, loc *time.Location, from, to int64, include func(models.Item) bool) {
+	scheduled, err := store.RetrieveScheduledItems(user, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	workflows := newListWorkflows(store, user)
+	items := make([]models.Item, 0)
+	for _, item := range scheduled {
+		if !workflows.finished(item) && include(item) {
+			items = append(items, item)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return viewDate(items[i]) < viewDate(items[j])
+	})
+
+	respondWithJSON(w, http.StatusOK, viewResponse{
+		TimeZone: loc.String(),
+		From:     from,
+		To:       to,
+		Items:    items,
+	})
+}
+
+func getOverdueHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		today, err := userToday(store, user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		to := today.Unix()
+		respondWithView(w, store, user, today.Location(), 0, to, func(item models.Item) bool {
+			return item.Due != 0 && item.Due < to
+		})
+	}
+}
+
+func getTodayHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		today, err := userToday(store, user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		from, to := today.Unix(), today.AddDate(0, 0, 1).Unix()
+		respondWithView(w, store, user, today.Location(), from, to, func(item models.Item) bool {
+			if item.Due != 0 && item.Due < from {
+				// overdue
+				return false
+			}
+			return within(item.Due, from, to) || (item.Start != 0 && item.Start < to)
+		})
+	}
+}
+
+// getUpcomingHandler returns what is due or starts in the ?days= days after
+// today, 7 by default.
+func getUpcomingHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		days := defaultUpcomingDays
+		if param := r.URL.Query().Get("days"); param != "" {
+			n, err := strconv.Atoi(param)
+			if err != nil || n < 1 || n > maxUpcomingDays {
+				respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+				return
+			}
+			days = n
+		}
+
+		today, err := userToday(store, user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		// AddDate keeps days aligned to midnight across daylight saving changes
+		from, to := today.AddDate(0, 0, 1).Unix(), today.AddDate(0, 0, 1+days).Unix()
+		respondWithView(w, store, user, today.Location(), from, to, func(item models.Item) bool {
+			return within(item.Due, from, to) || within(item.Start, from, to)
+		})
+	}
+}
+
+// hasState reports whether the item is in one of the states, which are given
+// by their value or their name in the item's list.
+func hasState(workflows *listWorkflows, item models.Item, states []string) bool {
+	state, _ := workflows.list(item).State(item.State)
+	for _, s := range states {
+		if s == strconv.Itoa(int(item.State)) || (state.Name != "" && s == state.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// getAssignedHandler returns the items assigned to the user in every list
+// they are a member of, oldest first. ?state= leaves out the items in other
+// states and can be repeated.
+func getAssignedHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		states := r.URL.Query()["state"]
+
+		assigned, err := store.RetrieveAssignedItems(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		workflows := newListWorkflows(store, user)
+		items := make([]models.Item, 0, len(assigned))
+		for _, item := range assigned {
+			if len(states) == 0 || hasState(workflows, item, states) {
+				items = append(items, item)
+			}
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Items []models.Item `json:"items"`
+		}{Items: items})
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"strings"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// validWorkflow reports whether a list can use the workflow. It needs at least
+// one state, every state needs its own value and name and limits can't be
+// negative.
+func validWorkflow(workflow []models.WorkflowState) bool {
+	if len(workflow) == 0 {
+		return false
+	}
+
+	values := make(map[uint8]bool, len(workflow))
+	names := make(map[string]bool, len(workflow))
+	for _, s := range workflow {
+		name := strings.ToLower(strings.TrimSpace(s.Name))
+		if name == "" || values[s.Value] || names[name] || !validColor(s.Color) || s.Limit < 0 {
+			return false
+		}
+
+		values[s.Value] = true
+		names[name] = true
+	}
+	return true
+}
+
+func sameWorkflow(a, b []models.WorkflowState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// initialState is the state new items in the list start in, the first state
+// of its workflow.
+func initialState(list models.List) uint8 {
+	return list.States()[0].Value
+}
+
+// listWorkflows loads the lists of items once to look up what their states
+// mean. Items of lists that cannot be loaded use the default workflow.
+type listWorkflows struct {
+	tx    db.Store
+	user  string
+	lists map[uuid.UUID]models.List
+}
+
+func newListWorkflows(tx db.Store, user string) *listWorkflows {
+	return &listWorkflows{tx: tx, user: user, lists: make(map[uuid.UUID]models.List)}
+}
+
+func (w *listWorkflows) list(item models.Item) models.List {
+	list, ok := w.lists[item.ListUUID]
+	if !ok {
+		list, _ = w.tx.RetrieveList(w.user, item.ListUUID.String())
+		w.lists[item.ListUUID] = list
+	}
+	return list
+}
+
+func (w *listWorkflows) finished(item models.Item) bool {
+	return w.list(item).Finished(item.State)
+}
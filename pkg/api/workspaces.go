@@ -0,0 +1,496 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// activeWorkspace returns the workspace from the user's access token,
+// uuid.Nil when they are working on their own lists.
+func activeWorkspace(r *http.Request) uuid.UUID {
+	id, err := uuid.Parse(r.Context().Value("workspace").(string))
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// validWorkspaceRole reports whether a workspace member can have the role,
+// unlike lists a workspace can have several owners.
+func validWorkspaceRole(role string) bool {
+	return role == models.RoleOwner || validMemberRole(role)
+}
+
+// inWorkspace reports whether the user is a member of the workspace.
+func inWorkspace(store db.Store, user string, workspace uuid.UUID) bool {
+	_, err := store.RetrieveWorkspace(user, workspace.String())
+	return err == nil
+}
+
+// lastOwner reports whether the member is the only owner of the workspace.
+func lastOwner(members []models.WorkspaceMember, member models.WorkspaceMember) bool {
+	if member.Role != models.RoleOwner {
+		return false
+	}
+
+	for _, m := range members {
+		if m.Role == models.RoleOwner && m.UserUUID != member.UserUUID {
+			return false
+		}
+	}
+	return true
+}
+
+func findWorkspaceMember(members []models.WorkspaceMember, memberId string) (models.WorkspaceMember, bool) {
+	for _, m := range members {
+		if m.UserUUID.String() == memberId {
+			return m, true
+		}
+	}
+	return models.WorkspaceMember{}, false
+}
+
+// joinWorkspaceLists gives the member their role on every list of the
+// workspace. Lists that are new to them go first in their lists and are
+// written into their history, like an accepted invitation.
+func joinWorkspaceLists(tx db.Store, member models.WorkspaceMember, now int64) error {
+	user := member.UserUUID.String()
+	lists, err := tx.RetrieveAllLists(user)
+	if err != nil {
+		return err
+	}
+
+	before := make(map[uuid.UUID]bool, len(lists))
+	for _, l := range lists {
+		before[l.UUID] = true
+	}
+
+	pos, err := newListPosition(tx, user)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.JoinWorkspaceLists(member.WorkspaceUUID, member.UserUUID, member.Role, pos, now); err != nil {
+		return err
+	}
+
+	if lists, err = tx.RetrieveAllLists(user); err != nil {
+		return err
+	}
+
+	for _, l := range lists {
+		if l.WorkspaceUUID != member.WorkspaceUUID || before[l.UUID] {
+			continue
+		}
+
+		if err := shareListSnapshot(tx, user, l, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addWorkspaceMembers makes the members of the new list's workspace members
+// of the list. The user who created it already has the list in their history,
+// the others get a LIST CREATE with their own position and role.
+func addWorkspaceMembers(tx db.Store, user string, list models.List, now int64) error {
+	members, err := tx.RetrieveWorkspaceMembers(user, list.WorkspaceUUID.String())
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		member := m.UserUUID.String()
+		pos, err := newListPosition(tx, member)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.JoinWorkspaceLists(list.WorkspaceUUID, m.UserUUID, m.Role, pos, now); err != nil {
+			return err
+		}
+
+		if member == user {
+			continue
+		}
+
+		l, err := tx.RetrieveList(member, list.UUID.String())
+		if err != nil {
+			return err
+		}
+
+		if err := createHistoryForState(tx, CmdListCreate, member, now, l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getWorkspacesHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		workspaces, err := store.RetrieveWorkspaces(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Workspaces []models.Workspace `json:"workspaces"`
+		}{Workspaces: workspaces})
+	}
+}
+
+// postWorkspaceHandler creates a workspace owned by the user.
+func postWorkspaceHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+
+		var request struct {
+			Name *string `json:"name,omitempty"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || request.Name == nil || strings.TrimSpace(*request.Name) == "" {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		id, err := uuid.NewRandom()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		workspace := models.Workspace{
+			UUID:     id,
+			Name:     strings.TrimSpace(*request.Name),
+			Role:     models.RoleOwner,
+			Created:  now,
+			Modified: now,
+		}
+
+		if err := store.CreateWorkspace(user, workspace); err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, workspace)
+	}
+}
+
+func getWorkspaceHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		workspaceId := chi.URLParam(r, "workspaceId")
+
+		workspace, err := store.RetrieveWorkspace(user, workspaceId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, workspace)
+	}
+}
+
+// patchWorkspaceHandler renames the workspace, owners only.
+func patchWorkspaceHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		workspaceId := chi.URLParam(r, "workspaceId")
+
+		var request struct {
+			Name *string `json:"name,omitempty"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || (request.Name != nil && strings.TrimSpace(*request.Name) == "") {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		workspace, err := store.RetrieveWorkspace(user, workspaceId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if workspace.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		if request.Name != nil {
+			workspace.Name = strings.TrimSpace(*request.Name)
+			workspace.Modified = time.Now().UTC().Unix()
+
+			if err := store.UpdateWorkspace(user, workspace); err != nil {
+				respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+		}
+
+		respondWithJSON(w, http.StatusOK, workspace)
+	}
+}
+
+func getWorkspaceMembersHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		workspaceId := chi.URLParam(r, "workspaceId")
+
+		if _, err := store.RetrieveWorkspace(user, workspaceId); err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		members, err := store.RetrieveWorkspaceMembers(user, workspaceId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			Members []models.WorkspaceMember `json:"members"`
+		}{Members: members})
+	}
+}
+
+// postWorkspaceMemberHandler adds the user with the email address to the
+// workspace, owners only. Unlike lists, workspaces only take users that
+// already have an account. The new member joins every list of the workspace.
+func postWorkspaceMemberHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		workspaceId := chi.URLParam(r, "workspaceId")
+
+		var request struct {
+			Email *string `json:"email,omitempty"`
+			Role  *string `json:"role,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == nil {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		role := models.RoleEditor
+		if request.Role != nil {
+			role = *request.Role
+		}
+
+		if !validWorkspaceRole(role) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		workspace, err := store.RetrieveWorkspace(user, workspaceId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if workspace.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		u, err := store.FindUserByEmail(normalizeEmail(*request.Email))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if inWorkspace(store, u.UUID.String(), workspace.UUID) {
+			respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		member := models.WorkspaceMember{
+			WorkspaceUUID: workspace.UUID,
+			UserUUID:      u.UUID,
+			Email:         u.Email,
+			Role:          role,
+			Created:       now,
+		}
+
+		// the user database isn't part of the transaction, so it is written
+		// last
+		err = store.Transaction(func(tx db.Store) error {
+			if err := joinWorkspaceLists(tx, member, now); err != nil {
+				return err
+			}
+
+			return tx.AddWorkspaceMember(user, member)
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, member)
+	}
+}
+
+// patchWorkspaceMemberHandler changes the role of a member of the workspace
+// and on each of its lists, owners only. The last owner keeps their role.
+func patchWorkspaceMemberHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		workspaceId := chi.URLParam(r, "workspaceId")
+		memberId := chi.URLParam(r, "userId")
+
+		var request struct {
+			Role *string `json:"role,omitempty"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || request.Role == nil || !validWorkspaceRole(*request.Role) {
+			respondWithError(w, http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity))
+			return
+		}
+
+		workspace, err := store.RetrieveWorkspace(user, workspaceId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		members, err := store.RetrieveWorkspaceMembers(user, workspaceId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		member, ok := findWorkspaceMember(members, memberId)
+		if !ok {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if workspace.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		if *request.Role != models.RoleOwner && lastOwner(members, member) {
+			respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		}
+
+		member.Role = *request.Role
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			if err := joinWorkspaceLists(tx, member, now); err != nil {
+				return err
+			}
+
+			return tx.UpdateWorkspaceMember(user, member)
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, member)
+	}
+}
+
+// deleteWorkspaceMemberHandler removes a member from the workspace and its
+// lists. Owners can remove anyone, other members can only leave, and the last
+// owner has to stay. The lists stay with the workspace and are deleted from
+// the removed member's history so their devices drop them.
+func deleteWorkspaceMemberHandler(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(string)
+		workspaceId := chi.URLParam(r, "workspaceId")
+		memberId := chi.URLParam(r, "userId")
+
+		workspace, err := store.RetrieveWorkspace(user, workspaceId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		members, err := store.RetrieveWorkspaceMembers(user, workspaceId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		member, ok := findWorkspaceMember(members, memberId)
+		if !ok {
+			respondWithError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+
+		if memberId != user && workspace.Role != models.RoleOwner {
+			respondWithError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+
+		if lastOwner(members, member) {
+			respondWithError(w, http.StatusConflict, http.StatusText(http.StatusConflict))
+			return
+		}
+
+		now := time.Now().UTC().Unix()
+		err = store.Transaction(func(tx db.Store) error {
+			lists, err := tx.RetrieveAllLists(memberId)
+			if err != nil {
+				return err
+			}
+
+			left := make([]uuid.UUID, 0, len(lists))
+			for _, l := range lists {
+				if l.WorkspaceUUID == workspace.UUID {
+					left = append(left, l.UUID)
+				}
+			}
+
+			if err := unassignLeaving(tx, user, memberId, left, now); err != nil {
+				return err
+			}
+
+			if err := tx.LeaveWorkspaceLists(workspace.UUID, member.UserUUID); err != nil {
+				return err
+			}
+
+			for _, l := range lists {
+				if l.WorkspaceUUID != workspace.UUID {
+					continue
+				}
+
+				state := uuidState{UUID: l.UUID}
+				if err := createHistoryForState(tx, CmdListDelete, memberId, now, state); err != nil {
+					return err
+				}
+			}
+
+			return tx.RemoveWorkspaceMember(user, workspace.UUID, member.UserUUID)
+		})
+
+		if err != nil {
+			log.Println("Failed to execute transaction:", err)
+			respondWithError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrNoSigningKey      = errors.New("No signing key available")
+	ErrUnknownAlgorithm  = errors.New("Unknown signing algorithm")
+	ErrInvalidSigningKey = errors.New("Invalid signing key")
+	ErrUnknownKeyId      = errors.New("Unknown key id")
+)
+
+// Keys is the keyring used to sign and verify access tokens.
+var Keys = NewKeyring()
+
+// Running apis reload their keys every KeyRefreshInterval. A new key only
+// starts signing KeyActivationDelay after it was created, by then every api
+// has loaded it and can verify the tokens it signs.
+const (
+	KeyRefreshInterval = time.Minute
+	KeyActivationDelay = 2 * KeyRefreshInterval
+)
+
+type Key struct {
+	Kid       string
+	Algorithm string
+	Created   int64
+
+	private crypto.Signer
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+func (k *Key) signingKey() interface{} {
+	return k.private
+}
+
+func (k *Key) verificationKey() interface{} {
+	return k.private.Public()
+}
+
+// Keyring holds every key that has not been retired. Tokens are signed with
+// the newest key that is older than KeyActivationDelay and can be verified by
+// any of them, so a new key can be added without invalidating tokens signed
+// by the old one or that other apis can't verify yet.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+	now  func() time.Time
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*Key), now: time.Now}
+}
+
+// Load replaces the keys in the keyring with the active keys in the store.
+func (k *Keyring) Load(store db.KeyStore) error {
+	stored, err := store.RetrieveSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*Key)
+	for _, s := range stored {
+		if s.Retired != 0 {
+			continue
+		}
+
+		key, err := ParseSigningKey(s)
+		if err != nil {
+			log.Printf("Skipping signing key %s: %s\n", s.Kid, err)
+			continue
+		}
+
+		keys[key.Kid] = key
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+	return nil
+}
+
+// Refresh reloads the keyring from the store every interval so keys added or
+// retired by the admin command are picked up.
+func (k *Keyring) Refresh(store db.KeyStore, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := k.Load(store); err != nil {
+			log.Println("Failed to refresh signing keys:", err)
+		}
+	}
+}
+
+// SigningKey returns the newest key that has been active for
+// KeyActivationDelay. When every key is newer than that, such as for the
+// first key, the oldest one signs since it has had the longest to be loaded.
+func (k *Keyring) SigningKey() (*Key, error) {
+	keys := k.All()
+	if len(keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	activated := k.now().Add(-KeyActivationDelay).Unix()
+	for _, key := range keys {
+		if key.Created <= activated {
+			return key, nil
+		}
+	}
+	return keys[len(keys)-1], nil
+}
+
+func (k *Keyring) VerificationKey(kid string) (*Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyId
+	}
+	return key, nil
+}
+
+// All returns the active keys newest first, including those that are not
+// signing yet.
+func (k *Keyring) All() []*Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created == keys[j].Created {
+			return keys[i].Kid > keys[j].Kid
+		}
+		return keys[i].Created > keys[j].Created
+	})
+	return keys
+}
+
+// GenerateSigningKey creates a new key pair for alg. The private key is
+// stored PKCS8 encoded.
+func GenerateSigningKey(alg string, now int64) (models.SigningKey, error) {
+	var private interface{}
+	switch alg {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return models.SigningKey{}, err
+		}
+		private = key
+	case AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return models.SigningKey{}, err
+		}
+		private = key
+	default:
+		return models.SigningKey{}, ErrUnknownAlgorithm
+	}
+
+	encoded, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	kid, err := uuid.NewRandom()
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	key := models.SigningKey{
+		Kid:        kid.String(),
+		Algorithm:  alg,
+		PrivateKey: encoded,
+		Created:    now,
+	}
+	return key, nil
+}
+
+func ParseSigningKey(stored models.SigningKey) (*Key, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(stored.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var private crypto.Signer
+	switch stored.Algorithm {
+	case AlgorithmRS256:
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, ErrInvalidSigningKey
+		}
+		private = key
+	case AlgorithmEdDSA:
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, ErrInvalidSigningKey
+		}
+		private = key
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+
+	key := &Key{
+		Kid:       stored.Kid,
+		Algorithm: stored.Algorithm,
+		Created:   stored.Created,
+		private:   private,
+	}
+	return key, nil
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+)
+
+func TestSigningKeyActivation(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	store := db.NewMemoryStore()
+	keyring := NewKeyring()
+	keyring.now = func() time.Time { return now }
+
+	add := func(created time.Time) string {
+		key, err := GenerateSigningKey(AlgorithmEdDSA, created.Unix())
+		if err != nil {
+			t.Fatalf("Failed to generate key: %s", err)
+		}
+		if err := store.CreateSigningKey(key); err != nil {
+			t.Fatalf("Failed to store key: %s", err)
+		}
+		if err := keyring.Load(store); err != nil {
+			t.Fatalf("Failed to load keys: %s", err)
+		}
+		return key.Kid
+	}
+
+	signing := func() string {
+		key, err := keyring.SigningKey()
+		if err != nil {
+			t.Fatalf("No signing key: %s", err)
+		}
+		return key.Kid
+	}
+
+	if _, err := keyring.SigningKey(); err != ErrNoSigningKey {
+		t.Errorf("expected ErrNoSigningKey, got %v", err)
+	}
+
+	// the first key signs straight away
+	first := add(now)
+	if signing() != first {
+		t.Error("the only key is not signing")
+	}
+
+	now = now.Add(time.Hour)
+	second := add(now)
+	if signing() != first {
+		t.Error("the new key signs before it is activated")
+	}
+	if _, err := keyring.VerificationKey(second); err != nil {
+		t.Error("the new key can't verify before it is activated")
+	}
+
+	now = now.Add(KeyActivationDelay)
+	if signing() != second {
+		t.Error("the new key does not sign once it is activated")
+	}
+}
@@ -9,42 +9,64 @@ import (
 	"ismacaulay/procrast-api/pkg/db"
 )
 
-func TokenSecurity(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenStr := ExtractBearerToken(r)
-		if tokenStr == "" {
-			log.Println("Missing token")
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
-
-		token, err := DecodeToken(tokenStr)
-		if err != nil {
-			log.Println("Invalid token")
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
-
-		claims := ExtractClaims(token)
-		userId := claims.User
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, "user", userId)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+func TokenSecurity(tokens db.TokenStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenStr := ExtractBearerToken(r)
+			if tokenStr == "" {
+				log.Println("Missing token")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			token, err := DecodeToken(tokenStr)
+			if err != nil {
+				log.Println("Invalid token")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			claims := ExtractClaims(token)
+			revoked, err := tokens.IsAccessTokenRevoked(claims.Id)
+			if err != nil || revoked {
+				log.Println("Revoked token")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			userId := claims.User
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, "user", userId)
+			ctx = context.WithValue(ctx, "workspace", claims.Workspace)
+			ctx = context.WithValue(ctx, "claims", claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
-func UserValidation(conn db.Conn) func(http.Handler) http.Handler {
+// UserValidation rejects tokens of users that no longer exist, and tokens
+// issued up to the user's NotBefore, such as those from before a password
+// reset. Tokens are issued in whole seconds, so one from the same second as
+// the reset is rejected too rather than risk accepting one from before it.
+func UserValidation(users db.UserStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			uuid := r.Context().Value("user").(string)
 
-			_, err := db.FindUserByUUID(conn, uuid)
+			user, err := users.FindUserByUUID(uuid)
 			if err != nil {
 				log.Println("Invalid user id from token")
 				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 				return
 			}
 
+			claims := r.Context().Value("claims").(*TokenClaims)
+			if claims.IssuedAt <= user.NotBefore {
+				log.Println("Token issued before the user's tokens were revoked")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
 			next.ServeHTTP(w, r)
 		})
 	}
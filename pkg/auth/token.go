@@ -7,27 +7,46 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
-var JWT_SECRET = []byte(os.Getenv("JWT_SECRET"))
+// Access tokens are short lived, clients use their refresh token to get a
+// new one.
+const (
+	AccessTokenExpiry  = time.Minute * 15
+	RefreshTokenExpiry = time.Hour * 24 * 30
+)
 
+// TokenClaims are the claims of an access token. Workspace is the workspace
+// the user is working in, empty for their own lists.
 type TokenClaims struct {
-	User string `json:"user,omitempty"`
+	User      string `json:"user,omitempty"`
+	Workspace string `json:"workspace,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateToken(id string) (string, error) {
+func GenerateToken(id, workspace string) (string, error) {
 	iat := time.Now()
-	// TODO: There is probably a better way of doing this
-	exp := iat.Add(time.Hour * 24 * 3) // 3 day exp (for now)
+	exp := iat.Add(AccessTokenExpiry)
 	iss, _ := os.Hostname()
 
-	standardClaims := jwt.StandardClaims{ExpiresAt: exp.Unix(), IssuedAt: iat.Unix(), Issuer: iss}
-	claims := TokenClaims{id, standardClaims}
+	jti, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	standardClaims := jwt.StandardClaims{Id: jti.String(), ExpiresAt: exp.Unix(), IssuedAt: iat.Unix(), Issuer: iss}
+	claims := TokenClaims{id, workspace, standardClaims}
 
-	tokenStr, err := token.SignedString(JWT_SECRET)
+	key, err := Keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.Kid
+
+	tokenStr, err := token.SignedString(key.signingKey())
 	if err != nil {
 		log.Fatalf("Unable to generate token: %f", err)
 		return "", err
@@ -37,11 +56,17 @@ func GenerateToken(id string) (string, error) {
 
 func DecodeToken(tokenStr string) (*jwt.Token, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		key, err := Keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Method.Alg() != key.method().Alg() {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return JWT_SECRET, nil
+		return key.verificationKey(), nil
 	})
 
 	if err != nil {
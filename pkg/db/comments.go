@@ -0,0 +1,138 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+const commentColumns = `c.id, c.item_id, c.parent_id, c.user_id, c.body, c.created, c.edited`
+
+func scanComment(row interface{ Scan(...interface{}) error }) (models.Comment, error) {
+	var comment models.Comment
+	err := row.Scan(&comment.UUID, &comment.ItemUUID, &comment.ParentUUID, &comment.Author,
+		&comment.Body, &comment.Created, &comment.Edited)
+	return comment, err
+}
+
+// commentParentId is the parent_id column for comment, comments on the item
+// itself have no parent.
+func commentParentId(comment models.Comment) interface{} {
+	if comment.ParentUUID == uuid.Nil {
+		return nil
+	}
+	return comment.ParentUUID
+}
+
+// RetrieveComments returns the comments on a live item in the order they were
+// written, starting after the comment with the id after unless it is empty.
+// A limit of 0 returns every comment.
+func RetrieveComments(conn Conn, user, itemId, after string, limit int) ([]models.Comment, error) {
+	sqlStatement := `
+		SELECT ` + commentColumns + `
+		FROM item_comments c
+		INNER JOIN items i ON (c.item_id = i.id)
+		INNER JOIN lists l ON (i.list_id = l.id)
+		WHERE ` + memberOf("l.id", "$1") + ` AND c.item_id = $2 AND i.deleted = 0
+			AND ($3::text = '' OR (c.created, c.id) > (SELECT a.created, a.id FROM item_comments a WHERE a.id::text = $3))
+		ORDER BY c.created ASC, c.id ASC
+		LIMIT NULLIF($4, 0)`
+
+	rows, err := conn.Query(sqlStatement, user, itemId, after, limit)
+	if err != nil {
+		log.Printf("Failed to load comments of item %s\nError: %s\n", itemId, err.Error())
+		return []models.Comment{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	comments := make([]models.Comment, 0)
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Comment{}, ErrFailedToScanRow
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, nil
+}
+
+func RetrieveComment(conn Conn, user, id string) (models.Comment, error) {
+	sqlStatement := `
+		SELECT ` + commentColumns + `
+		FROM item_comments c
+		INNER JOIN items i ON (c.item_id = i.id)
+		INNER JOIN lists l ON (i.list_id = l.id)
+		WHERE ` + memberOf("l.id", "$1") + ` AND c.id = $2 AND i.deleted = 0`
+
+	comment, err := scanComment(conn.QueryRow(sqlStatement, user, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to execute query: %s\n", err.Error())
+		}
+		return models.Comment{}, ErrFailedToLoadData
+	}
+
+	return comment, nil
+}
+
+// CreateComment adds a comment by the user to a live item of a list they can
+// edit. A reply has to answer a comment on the same item.
+func CreateComment(conn Conn, user string, comment models.Comment) error {
+	sqlStatement := `
+		INSERT INTO item_comments (id, item_id, parent_id, user_id, body, created, edited)
+		SELECT $2, i.id, $4, $1, $5, $6, $7
+		FROM items i
+		INNER JOIN lists l ON (i.list_id = l.id)
+		WHERE ` + editorOf("l.id", "$1") + ` AND i.id = $3 AND i.deleted = 0
+			AND ($4::uuid IS NULL OR EXISTS (SELECT 1 FROM item_comments p WHERE p.id = $4 AND p.item_id = i.id))`
+
+	res, err := conn.Exec(sqlStatement, user, comment.UUID, comment.ItemUUID, commentParentId(comment),
+		comment.Body, comment.Created, comment.Edited)
+	if err != nil {
+		log.Println("Failed to create comment:", err)
+		return ErrFailedToInsert
+	}
+
+	return requireRows(res)
+}
+
+// UpdateComment changes the body of a comment written by the user, who still
+// has to be able to edit the item's list.
+func UpdateComment(conn Conn, user string, comment models.Comment) error {
+	sqlStatement := `
+		UPDATE item_comments c
+		SET body = $3, edited = $4
+		FROM items i, lists l
+		WHERE c.item_id = i.id AND i.list_id = l.id AND ` + editorOf("l.id", "$1") + `
+			AND c.id = $2 AND c.user_id = $1 AND i.deleted = 0`
+
+	res, err := conn.Exec(sqlStatement, user, comment.UUID, comment.Body, comment.Edited)
+	if err != nil {
+		log.Println("Failed to update comment:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return requireRows(res)
+}
+
+// DeleteComment deletes a comment written by the user along with its replies.
+func DeleteComment(conn Conn, user string, comment models.Comment) error {
+	sqlStatement := `
+		DELETE FROM item_comments c
+		USING items i, lists l
+		WHERE c.item_id = i.id AND i.list_id = l.id AND ` + editorOf("l.id", "$1") + `
+			AND c.id = $2 AND c.user_id = $1 AND i.deleted = 0`
+
+	res, err := conn.Exec(sqlStatement, user, comment.UUID)
+	if err != nil {
+		log.Println("Failed to delete comment:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return requireRows(res)
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// AddDependency blocks item on blocker. Both have to be live items, the item in
+// a list the user can edit and the blocker in a list the user is a member of,
+// and the dependency must not already exist.
+func AddDependency(conn Conn, user string, item, blocker uuid.UUID, ts int64) error {
+	sqlStatement := `
+		INSERT INTO item_dependencies (item_id, blocker_id, created)
+		SELECT i.id, b.id, $4
+		FROM items i
+		INNER JOIN lists l ON (i.list_id = l.id)
+		INNER JOIN items b ON (b.id = $3)
+		INNER JOIN lists bl ON (b.list_id = bl.id)
+		WHERE ` + editorOf("l.id", "$1") + ` AND ` + memberOf("bl.id", "$1") + ` AND i.id = $2 AND i.id <> b.id
+			AND l.deleted = 0 AND i.deleted = 0 AND bl.deleted = 0 AND b.deleted = 0
+		ON CONFLICT DO NOTHING`
+
+	res, err := conn.Exec(sqlStatement, user, item, blocker, ts)
+	if err != nil {
+		log.Println("Failed to add dependency:", err)
+		return ErrFailedToInsert
+	}
+
+	return requireRows(res)
+}
+
+func RemoveDependency(conn Conn, user string, item, blocker uuid.UUID) error {
+	sqlStatement := `
+		DELETE FROM item_dependencies
+		USING items i, lists l
+		WHERE item_dependencies.item_id = i.id AND i.list_id = l.id AND ` + editorOf("l.id", "$1") + `
+			AND item_dependencies.item_id = $2 AND item_dependencies.blocker_id = $3`
+
+	res, err := conn.Exec(sqlStatement, user, item, blocker)
+	if err != nil {
+		log.Println("Failed to remove dependency:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return requireRows(res)
+}
+
+// RetrieveDependencies returns every dependency of the items in the lists the
+// user is a member of, including items in the trash.
+func RetrieveDependencies(conn Conn, user string) ([]models.Dependency, error) {
+	sqlStatement := `
+		SELECT d.item_id, d.blocker_id, d.created
+		FROM item_dependencies d
+		INNER JOIN items i ON (d.item_id = i.id)
+		INNER JOIN lists l ON (i.list_id = l.id)
+		WHERE ` + memberOf("l.id", "$1") + `
+		ORDER BY d.created ASC, d.item_id ASC, d.blocker_id ASC`
+
+	rows, err := conn.Query(sqlStatement, user)
+	if err != nil {
+		log.Printf("Failed to load dependencies for user %s\nError: %s\n", user, err.Error())
+		return []models.Dependency{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	dependencies := make([]models.Dependency, 0)
+	for rows.Next() {
+		var d models.Dependency
+		if err := rows.Scan(&d.ItemUUID, &d.BlockerUUID, &d.Created); err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Dependency{}, ErrFailedToScanRow
+		}
+		dependencies = append(dependencies, d)
+	}
+
+	return dependencies, nil
+}
@@ -7,12 +7,16 @@ import (
 	"github.com/google/uuid"
 )
 
+// GetHistorySince returns the history in the order it was created. Entries
+// created in the same second, such as those of one sync, keep the order they
+// were inserted in through seq, so an entry never comes before the list or
+// item it depends on.
 func GetHistorySince(conn Conn, user string, since uint64) ([]models.History, error) {
 	sqlStatement := `
 		SELECT id, command, state, ts, created
 		FROM history
 		WHERE user_id = $1 AND created >= $2
-		ORDER BY created ASC`
+		ORDER BY created ASC, seq ASC`
 
 	rows, err := conn.Query(sqlStatement, user, since)
 	if err != nil {
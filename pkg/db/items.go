@@ -8,28 +8,80 @@ import (
 	"github.com/google/uuid"
 )
 
-const selectAllItemsStatement = `
-	SELECT i.id, i.title, i.description, i.state, i.created, i.modified, l.id
+// itemColumns are the columns read by scanItem
+const itemColumns = `i.id, i.title, i.description, i.state, i.created, i.modified, l.id, i.parent_id, i.assignee_id, i.position, i.due, i.start, i.rrule, i.occurrence, i.previous_id, i.deleted, i.clock`
+
+// Items can be read by every member of their list.
+var selectAllItemsStatement = `
+	SELECT ` + itemColumns + `
 	FROM items i
 	INNER JOIN lists l ON (i.list_id = l.id)
-	WHERE l.user_id = $1 AND i.list_id = $2
-	ORDER BY i.created ASC`
+	WHERE ` + memberOf("l.id", "$1") + ` AND i.list_id = $2 AND i.deleted = 0
+	ORDER BY i.position COLLATE "C" ASC, i.id ASC`
 
-const selectItemStatement = `
-	SELECT i.id, i.title, i.description, i.state, i.created, i.modified, l.id
+var selectItemStatement = `
+	SELECT ` + itemColumns + `
 	FROM items i
 	INNER JOIN lists l ON (i.list_id = l.id)
-	WHERE l.user_id = $1 AND i.id = $2
-	ORDER BY i.created ASC`
+	WHERE ` + memberOf("l.id", "$1") + ` AND i.id = $2 AND i.deleted = 0`
+
+// Items are only written through a list the user can edit, so an item can
+// never be created in, or changed through, a list that is not shared with
+// them. A subtask's parent has to be a live item in the same list. Items are
+// only ever assigned to members of their list, anyone else is dropped.
+var insertItemStatement = `
+	INSERT INTO items (id, created, modified, title, description, state, list_id, clock, due, start, rrule, occurrence, position, parent_id, assignee_id, previous_id)
+	SELECT $1::uuid, $2::bigint, $3::bigint, $4::text, $5::text, $6::smallint, l.id, $8::text, $10::bigint, $11::bigint, $12::text, $13::int, $14::text, $15::uuid,
+		CASE WHEN ` + memberOf("l.id", "$16::uuid") + ` THEN $16::uuid END, $17::uuid
+	FROM lists l
+	WHERE l.id = $7 AND ` + editorOf("l.id", "$9") + ` AND l.deleted = 0
+		AND ($15::uuid IS NULL OR EXISTS (SELECT 1 FROM items p WHERE p.id = $15 AND p.list_id = l.id AND p.deleted = 0))
+	ON CONFLICT (id) DO NOTHING`
+
+// descendantsStatement selects the ids of the subtasks of item $1 at any
+// depth, whether or not they are in the trash.
+const descendantsStatement = `
+	WITH RECURSIVE descendants AS (
+		SELECT id FROM items WHERE parent_id = $1
+		UNION ALL
+		SELECT c.id FROM items c INNER JOIN descendants d ON (c.parent_id = d.id)
+	)`
+
+var deleteItemStatement = `
+	DELETE FROM items
+	USING lists l
+	WHERE items.id = $1 AND items.list_id = l.id AND ` + editorOf("l.id", "$2")
+
+func scanItem(row interface{ Scan(...interface{}) error }) (models.Item, error) {
+	var item models.Item
+	var clock string
+	err := row.Scan(&item.UUID, &item.Title, &item.Description, &item.State,
+		&item.Created, &item.Modified, &item.ListUUID, &item.ParentUUID, &item.AssigneeUUID, &item.Position, &item.Due, &item.Start,
+		&item.RRule, &item.Occurrence, &item.PreviousUUID, &item.Deleted, &clock)
+	if err != nil {
+		return models.Item{}, err
+	}
 
-const insertItemStatement = `
-	INSERT INTO items (id, created, modified, title, description, state, list_id)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	item.Clock = decodeClock(clock)
+	return item, nil
+}
 
-const deleteItemStatement = `
-	DELETE FROM items
-	USING lists
-	WHERE items.id = $1`
+// parentId is the parent_id column for item, top level items have no parent.
+func parentId(item models.Item) interface{} {
+	if item.ParentUUID == uuid.Nil {
+		return nil
+	}
+	return item.ParentUUID
+}
+
+// assigneeId is the assignee_id column for item, which is null when nobody
+// is assigned.
+func assigneeId(item models.Item) interface{} {
+	if item.Assignee() == uuid.Nil {
+		return nil
+	}
+	return item.Assignee()
+}
 
 func RetrieveAllItems(conn Conn, user, list_id string) ([]models.Item, error) {
 	rows, err := conn.Query(selectAllItemsStatement, user, list_id)
@@ -41,24 +93,11 @@ func RetrieveAllItems(conn Conn, user, list_id string) ([]models.Item, error) {
 
 	items := make([]models.Item, 0)
 	for rows.Next() {
-		var itemId, listId uuid.UUID
-		var title, description string
-		var state uint8
-		var created, modified int64
-		if err := rows.Scan(&itemId, &title, &description, &state, &created, &modified, &listId); err != nil {
+		item, err := scanItem(rows)
+		if err != nil {
 			log.Printf("Failed to scan row: %s\n", err.Error())
 			return []models.Item{}, ErrFailedToScanRow
 		}
-
-		item := models.Item{
-			UUID:        itemId,
-			Title:       title,
-			Description: description,
-			State:       state,
-			Created:     created,
-			Modified:    modified,
-			ListUUID:    listId,
-		}
 		items = append(items, item)
 	}
 
@@ -66,64 +105,135 @@ func RetrieveAllItems(conn Conn, user, list_id string) ([]models.Item, error) {
 }
 
 func RetrieveItem(conn Conn, user, id string) (models.Item, error) {
-	var itemId, listId uuid.UUID
-	var title, description string
-	var state uint8
-	var created, modified int64
-	err := conn.QueryRow(selectItemStatement, user, id).Scan(
-		&itemId, &title, &description, &state, &created, &modified, &listId)
+	item, err := scanItem(conn.QueryRow(selectItemStatement, user, id))
 	if err != nil {
 		log.Printf("Failed to execute query: %s\n", err.Error())
 		return models.Item{}, ErrFailedToLoadData
 	}
 
-	item := models.Item{
-		UUID:        itemId,
-		Title:       title,
-		Description: description,
-		State:       state,
-		Created:     created,
-		Modified:    modified,
-		ListUUID:    listId,
-	}
 	return item, nil
 }
 
-func CreateItem(conn Conn, item models.Item) error {
-	_, err := conn.Exec(insertItemStatement,
+func CreateItem(conn Conn, user string, item models.Item) error {
+	res, err := conn.Exec(insertItemStatement,
 		item.UUID, item.Created, item.Modified,
-		item.Title, item.Description, item.State, item.ListUUID)
+		item.Title, item.Description, item.State, item.ListUUID, encodeClock(item.Clock), user,
+		item.Due, item.Start, item.RRule, item.Occurrence, item.Position, parentId(item), assigneeId(item), item.PreviousUUID)
 	if err != nil {
 		log.Println("Failed to create item:", err)
 		return ErrFailedToInsert
 	}
 
-	return nil
+	return requireRows(res)
 }
 
-func UpdateItem(conn Conn, item models.Item) error {
+// UpdateItem moves the item to item.ListUUID if it changed, which has to be a
+// list the user can edit that is not in the trash. The item's subtasks are
+// moved to the list with it. Items moved to a list their assignee is not a
+// member of are unassigned.
+func UpdateItem(conn Conn, user string, item models.Item) error {
 	sqlStatement := `
 		UPDATE items
-		SET modified = $2, title = $3, description = $4, state = $5
-		FROM lists
-		WHERE items.id = $1`
-
-	_, err := conn.Exec(sqlStatement,
-		item.UUID, item.Modified, item.Title, item.Description, item.State,
+		SET modified = $2, title = $3, description = $4, state = $5, clock = $6, due = $8, start = $9, rrule = $10, position = $11, list_id = $12, parent_id = $13,
+			assignee_id = CASE WHEN ` + memberOf("$12", "$14::uuid") + ` THEN $14::uuid END
+		FROM lists l
+		WHERE items.id = $1 AND items.list_id = l.id AND ` + editorOf("l.id", "$7") + ` AND items.deleted = 0
+			AND EXISTS (SELECT 1 FROM lists target WHERE target.id = $12 AND ` + editorOf("target.id", "$7") + ` AND target.deleted = 0)
+			AND ($13::uuid IS NULL OR EXISTS (SELECT 1 FROM items p WHERE p.id = $13 AND p.list_id = $12 AND p.deleted = 0))`
+
+	res, err := conn.Exec(sqlStatement,
+		item.UUID, item.Modified, item.Title, item.Description, item.State, encodeClock(item.Clock), user,
+		item.Due, item.Start, item.RRule, item.Position, item.ListUUID, parentId(item), assigneeId(item),
 	)
 	if err != nil {
 		log.Println("Failed to update item:", err)
 		return ErrFailedToUpdateData
 	}
 
+	if err := requireRows(res); err != nil {
+		return err
+	}
+
+	sqlStatement = descendantsStatement + `
+		UPDATE items
+		SET list_id = $2, assignee_id = CASE WHEN ` + memberOf("$2", "items.assignee_id") + ` THEN assignee_id END
+		WHERE id IN (SELECT id FROM descendants) AND list_id <> $2`
+
+	if _, err := conn.Exec(sqlStatement, item.UUID, item.ListUUID); err != nil {
+		log.Println("Failed to move subtasks:", err)
+		return ErrFailedToUpdateData
+	}
+
 	return nil
 }
 
-func DeleteItem(conn Conn, item models.Item) error {
-	_, err := conn.Exec(deleteItemStatement, item.UUID)
+func DeleteItem(conn Conn, user string, item models.Item) error {
+	res, err := conn.Exec(deleteItemStatement, item.UUID, user)
 	if err != nil {
-		log.Println("Failed to update item:", err)
-		return ErrFailedToUpdateData
+		log.Println("Failed to delete item:", err)
+		return ErrFailedToDeleteData
 	}
-	return nil
+
+	return requireRows(res)
+}
+
+// RetrieveScheduledItems returns the items that are due or start before the
+// given time, across all of the lists the user is a member of.
+func RetrieveScheduledItems(conn Conn, user string, before int64) ([]models.Item, error) {
+	sqlStatement := `
+		SELECT ` + itemColumns + `
+		FROM items i
+		INNER JOIN lists l ON (i.list_id = l.id)
+		WHERE ` + memberOf("l.id", "$1") + ` AND l.deleted = 0 AND i.deleted = 0
+			AND ((i.due <> 0 AND i.due < $2) OR (i.start <> 0 AND i.start < $2))
+		ORDER BY i.created ASC`
+
+	rows, err := conn.Query(sqlStatement, user, before)
+	if err != nil {
+		log.Printf("Failed to load scheduled items for user %s\nError: %s\n", user, err.Error())
+		return []models.Item{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	items := make([]models.Item, 0)
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Item{}, ErrFailedToScanRow
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// RetrieveAssignedItems returns the live items assigned to the user, across
+// all of the lists they are a member of.
+func RetrieveAssignedItems(conn Conn, user string) ([]models.Item, error) {
+	sqlStatement := `
+		SELECT ` + itemColumns + `
+		FROM items i
+		INNER JOIN lists l ON (i.list_id = l.id)
+		WHERE ` + memberOf("l.id", "$1") + ` AND l.deleted = 0 AND i.deleted = 0 AND i.assignee_id = $1
+		ORDER BY i.created ASC, i.id ASC`
+
+	rows, err := conn.Query(sqlStatement, user)
+	if err != nil {
+		log.Printf("Failed to load assigned items for user %s\nError: %s\n", user, err.Error())
+		return []models.Item{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	items := make([]models.Item, 0)
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Item{}, ErrFailedToScanRow
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
 }
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+func CreateSigningKey(conn Conn, key models.SigningKey) error {
+	sqlStatement := `
+		INSERT INTO signing_keys (kid, alg, private_key, created)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err := conn.Exec(sqlStatement, key.Kid, key.Algorithm, key.PrivateKey, key.Created)
+	if err != nil {
+		log.Println("Failed to create signing key:", err)
+		return ErrFailedToInsert
+	}
+
+	return nil
+}
+
+func RetrieveSigningKeys(conn Conn) ([]models.SigningKey, error) {
+	sqlStatement := `
+		SELECT kid, alg, private_key, created, COALESCE(retired, 0)
+		FROM signing_keys
+		ORDER BY created DESC`
+
+	rows, err := conn.Query(sqlStatement)
+	if err != nil {
+		log.Println("Failed to load signing keys:", err)
+		return []models.SigningKey{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	keys := make([]models.SigningKey, 0)
+	for rows.Next() {
+		var kid, alg string
+		var privateKey []byte
+		var created, retired int64
+		if err := rows.Scan(&kid, &alg, &privateKey, &created, &retired); err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.SigningKey{}, ErrFailedToScanRow
+		}
+
+		key := models.SigningKey{
+			Kid:        kid,
+			Algorithm:  alg,
+			PrivateKey: privateKey,
+			Created:    created,
+			Retired:    retired,
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func RetireSigningKey(conn Conn, kid string, now int64) error {
+	sqlStatement := `
+		UPDATE signing_keys
+		SET retired = $2
+		WHERE kid = $1 AND retired IS NULL`
+
+	res, err := conn.Exec(sqlStatement, kid, now)
+	if err != nil {
+		log.Println("Failed to retire signing key:", err)
+		return ErrFailedToUpdateData
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrFailedToUpdateData
+	}
+
+	return nil
+}
@@ -7,10 +7,49 @@ import (
 	"github.com/google/uuid"
 )
 
+// listColumns are the columns read by scanList. The position and role come
+// from the membership m of the user the list is read for.
+const listColumns = `l.id, l.title, l.description, l.created, l.modified, m.position, m.position_ts, m.role, l.workspace_id, l.workflow, l.deleted, l.clock`
+
+// memberOf and editorOf are the access checks for the list with the given id
+// column: the user in the given parameter has to be a member of it, or a
+// member that can change it.
+func memberOf(list, user string) string {
+	return `EXISTS (SELECT 1 FROM list_members m WHERE m.list_id = ` + list + ` AND m.user_id = ` + user + `)`
+}
+
+func editorOf(list, user string) string {
+	return `EXISTS (SELECT 1 FROM list_members m WHERE m.list_id = ` + list + ` AND m.user_id = ` + user + ` AND m.role IN ('owner', 'editor'))`
+}
+
+// scanList reads listColumns. The position is written by the member so its
+// clock is kept with the membership instead of in the list's clock.
+func scanList(row interface{ Scan(...interface{}) error }) (models.List, error) {
+	var list models.List
+	var workflow, clock string
+	var positionTs int64
+	err := row.Scan(&list.UUID, &list.Title, &list.Description, &list.Created, &list.Modified,
+		&list.Position, &positionTs, &list.Role, &list.WorkspaceUUID, &workflow, &list.Deleted, &clock)
+	if err != nil {
+		return models.List{}, err
+	}
+
+	list.Workflow = decodeWorkflow(workflow)
+	list.Clock = decodeClock(clock)
+	delete(list.Clock, "position")
+	if positionTs != 0 {
+		list.Clock.Set("position", positionTs)
+	}
+	return list, nil
+}
+
+// RetrieveAllLists returns the lists the user is a member of.
 func RetrieveAllLists(conn Conn, user string) ([]models.List, error) {
 	sqlStatement := `
-		SELECT id, title, description, created, modified FROM lists
-		WHERE user_id = $1 ORDER BY created DESC`
+		SELECT ` + listColumns + `
+		FROM lists l
+		INNER JOIN list_members m ON (m.list_id = l.id AND m.user_id = $1)
+		WHERE l.deleted = 0 ORDER BY m.position COLLATE "C" ASC, l.id ASC`
 
 	rows, err := conn.Query(sqlStatement, user)
 	if err != nil {
@@ -21,21 +60,11 @@ func RetrieveAllLists(conn Conn, user string) ([]models.List, error) {
 
 	lists := make([]models.List, 0)
 	for rows.Next() {
-		var id uuid.UUID
-		var title, description string
-		var created, modified int64
-		if err := rows.Scan(&id, &title, &description, &created, &modified); err != nil {
+		list, err := scanList(rows)
+		if err != nil {
 			log.Printf("Failed to scan row: %s\n", err.Error())
 			return []models.List{}, ErrFailedToScanRow
 		}
-
-		list := models.List{
-			UUID:        id,
-			Title:       title,
-			Description: description,
-			Created:     created,
-			Modified:    modified,
-		}
 		lists = append(lists, list)
 	}
 
@@ -43,61 +72,99 @@ func RetrieveAllLists(conn Conn, user string) ([]models.List, error) {
 }
 
 func RetrieveList(conn Conn, user, id string) (models.List, error) {
-	sqlStatement := `SELECT id, title, description, created, modified FROM lists WHERE user_id = $1 AND id = $2`
+	sqlStatement := `
+		SELECT ` + listColumns + `
+		FROM lists l
+		INNER JOIN list_members m ON (m.list_id = l.id AND m.user_id = $1)
+		WHERE l.id = $2 AND l.deleted = 0`
 
-	var listId uuid.UUID
-	var title, description string
-	var created, modified int64
-	err := conn.QueryRow(sqlStatement, user, id).Scan(&listId, &title, &description, &created, &modified)
+	list, err := scanList(conn.QueryRow(sqlStatement, user, id))
 	if err != nil {
 		log.Printf("Failed to execute query: %s\n", err.Error())
 		return models.List{}, ErrFailedToLoadData
 	}
 
-	list := models.List{
-		UUID:        listId,
-		Title:       title,
-		Description: description,
-		Created:     created,
-		Modified:    modified,
-	}
 	return list, nil
 }
 
+// CreateList creates the list with the user as its owner. The members of the
+// list's workspace are added with JoinWorkspaceLists.
 func CreateList(conn Conn, user string, list models.List) error {
 	sqlStatement := `
-		INSERT INTO lists (id, created, modified, title, description, user_id)
-		VALUES ($1, $2, $3, $4, $5, $6)`
+		INSERT INTO lists (id, created, modified, title, description, user_id, workspace_id, clock, workflow)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO NOTHING`
 
-	_, err := conn.Exec(sqlStatement, list.UUID, list.Created, list.Modified, list.Title, list.Description, user)
+	res, err := conn.Exec(sqlStatement, list.UUID, list.Created, list.Modified, list.Title, list.Description, user, list.WorkspaceUUID, encodeListClock(list.Clock), encodeWorkflow(list.Workflow))
 	if err != nil {
 		log.Println("Failed to create list:", err)
 		return ErrFailedToInsert
 	}
 
+	if err := requireRows(res); err != nil {
+		return err
+	}
+
+	sqlStatement = `
+		INSERT INTO list_members (list_id, user_id, role, position, position_ts, created)
+		VALUES ($1, $2, 'owner', $3, $4, $5)`
+
+	_, err = conn.Exec(sqlStatement, list.UUID, user, list.Position, list.Clock.Get("position", 0), list.Created)
+	if err != nil {
+		log.Println("Failed to add list owner:", err)
+		return ErrFailedToInsert
+	}
+
 	return nil
 }
 
+// UpdateList writes the list for members that can change it. The position is
+// the user's own and is written with MoveList.
 func UpdateList(conn Conn, user string, list models.List) error {
 	sqlStatement := `
-		UPDATE lists
-		SET modified = $3, title = $4, description = $5
-		WHERE user_id = $1 AND id = $2`
+		UPDATE lists l
+		SET modified = $3, title = $4, description = $5, clock = $6, workflow = $7
+		WHERE l.id = $2 AND l.deleted = 0 AND ` + editorOf("l.id", "$1")
 
-	_, err := conn.Exec(sqlStatement, user, list.UUID, list.Modified, list.Title, list.Description)
+	res, err := conn.Exec(sqlStatement, user, list.UUID, list.Modified, list.Title, list.Description, encodeListClock(list.Clock), encodeWorkflow(list.Workflow))
 	if err != nil {
 		log.Println("Failed to update list:", err)
 		return ErrFailedToUpdateData
 	}
 
-	return nil
+	return requireRows(res)
+}
+
+// encodeListClock leaves out the position, which is clocked per member.
+func encodeListClock(clock models.Clock) string {
+	clock = clock.Copy()
+	delete(clock, "position")
+	return encodeClock(clock)
+}
+
+// MoveList sets the user's position of a live list they are a member of, ts
+// is recorded as when the position was written.
+func MoveList(conn Conn, user string, list uuid.UUID, position string, ts int64) error {
+	sqlStatement := `
+		UPDATE list_members m
+		SET position = $3, position_ts = $4
+		FROM lists l
+		WHERE m.list_id = l.id AND m.user_id = $1 AND l.id = $2 AND l.deleted = 0`
+
+	res, err := conn.Exec(sqlStatement, user, list, position, ts)
+	if err != nil {
+		log.Println("Failed to move list:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return requireRows(res)
 }
 
 func DeleteList(conn Conn, user string, list models.List) error {
 	sqlStatement := `
 		DELETE FROM items i
 		USING lists l
-		WHERE l.user_id = $1 AND l.id = i.list_id AND l.id = $2`
+		WHERE ` + ownerOf("l.id", "$1") + ` AND l.id = i.list_id AND l.id = $2`
 
 	_, err := conn.Exec(sqlStatement, user, list.UUID)
 	if err != nil {
@@ -106,14 +173,14 @@ func DeleteList(conn Conn, user string, list models.List) error {
 	}
 
 	sqlStatement = `
-		DELETE FROM lists
-		WHERE user_id = $1 AND id = $2`
+		DELETE FROM lists l
+		WHERE l.id = $2 AND ` + ownerOf("l.id", "$1")
 
-	_, err = conn.Exec(sqlStatement, user, list.UUID)
+	res, err := conn.Exec(sqlStatement, user, list.UUID)
 	if err != nil {
 		log.Println("Failed to delete list:", err)
 		return ErrFailedToDeleteData
 	}
 
-	return nil
+	return requireRows(res)
 }
@@ -0,0 +1,278 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// ownerOf is the access check for changing who a list is shared with, the user
+// in the given parameter has to own the list with the given id column.
+func ownerOf(list, user string) string {
+	return `EXISTS (SELECT 1 FROM list_members o WHERE o.list_id = ` + list + ` AND o.user_id = ` + user + ` AND o.role = 'owner')`
+}
+
+const invitationColumns = `inv.id, inv.list_id, inv.email, inv.role, inv.invited_by, inv.created`
+
+func scanInvitation(row interface{ Scan(...interface{}) error }) (models.Invitation, error) {
+	var inv models.Invitation
+	err := row.Scan(&inv.UUID, &inv.ListUUID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.Created)
+	return inv, err
+}
+
+func scanInvitations(rows *sql.Rows, err error) ([]models.Invitation, error) {
+	if err != nil {
+		log.Printf("Failed to load invitations\nError: %s\n", err.Error())
+		return []models.Invitation{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	invitations := make([]models.Invitation, 0)
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Invitation{}, ErrFailedToScanRow
+		}
+		invitations = append(invitations, inv)
+	}
+
+	return invitations, nil
+}
+
+// RetrieveMembers returns the members of a list the user is a member of, the
+// owner first. The list may be in the trash.
+func RetrieveMembers(conn Conn, user, listId string) ([]models.Member, error) {
+	sqlStatement := `
+		SELECT m.list_id, m.user_id, m.role, m.created
+		FROM list_members m
+		WHERE m.list_id = $2 AND ` + memberOf("$2", "$1") + `
+		ORDER BY m.role = 'owner' DESC, m.created ASC, m.user_id ASC`
+
+	rows, err := conn.Query(sqlStatement, user, listId)
+	if err != nil {
+		log.Printf("Failed to load members of list %s\nError: %s\n", listId, err.Error())
+		return []models.Member{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	members := make([]models.Member, 0)
+	for rows.Next() {
+		var m models.Member
+		if err := rows.Scan(&m.ListUUID, &m.UserUUID, &m.Role, &m.Created); err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Member{}, ErrFailedToScanRow
+		}
+		members = append(members, m)
+	}
+
+	return members, nil
+}
+
+// UpdateMember changes the role of a member. Only the owner can do this and
+// the owner's own role can't be changed.
+func UpdateMember(conn Conn, user string, member models.Member) error {
+	sqlStatement := `
+		UPDATE list_members m
+		SET role = $4
+		WHERE m.list_id = $2 AND m.user_id = $3 AND m.role <> 'owner' AND ` + ownerOf("$2", "$1")
+
+	res, err := conn.Exec(sqlStatement, user, member.ListUUID, member.UserUUID, member.Role)
+	if err != nil {
+		log.Println("Failed to update member:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return requireRows(res)
+}
+
+// RemoveMember stops sharing the list with member. The owner can remove
+// anyone but themselves, other members can only leave the list. The items
+// of the list assigned to the member are unassigned.
+func RemoveMember(conn Conn, user string, list, member uuid.UUID) error {
+	sqlStatement := `
+		DELETE FROM list_members m
+		WHERE m.list_id = $2 AND m.user_id = $3 AND m.role <> 'owner'
+			AND ($3::uuid = $1::uuid OR ` + ownerOf("$2", "$1") + `)`
+
+	res, err := conn.Exec(sqlStatement, user, list, member)
+	if err != nil {
+		log.Println("Failed to remove member:", err)
+		return ErrFailedToDeleteData
+	}
+
+	if err := requireRows(res); err != nil {
+		return err
+	}
+
+	sqlStatement = `
+		UPDATE items SET assignee_id = NULL
+		WHERE list_id = $1 AND assignee_id = $2`
+
+	if _, err := conn.Exec(sqlStatement, list, member); err != nil {
+		log.Println("Failed to unassign removed member:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return nil
+}
+
+// CreateInvitation invites the email address to a list owned by the user. An
+// address can only be invited to a list once at a time.
+func CreateInvitation(conn Conn, user string, inv models.Invitation) error {
+	sqlStatement := `
+		INSERT INTO list_invitations (id, list_id, email, role, invited_by, created)
+		SELECT $2, l.id, $4, $5, $1, $6
+		FROM lists l
+		WHERE l.id = $3 AND l.deleted = 0 AND ` + ownerOf("l.id", "$1") + `
+		ON CONFLICT DO NOTHING`
+
+	res, err := conn.Exec(sqlStatement, user, inv.UUID, inv.ListUUID, inv.Email, inv.Role, inv.Created)
+	if err != nil {
+		log.Println("Failed to create invitation:", err)
+		return ErrFailedToInsert
+	}
+
+	return requireRows(res)
+}
+
+// RetrieveListInvitations returns the open invitations to a list owned by the
+// user.
+func RetrieveListInvitations(conn Conn, user, listId string) ([]models.Invitation, error) {
+	sqlStatement := `
+		SELECT ` + invitationColumns + `
+		FROM list_invitations inv
+		WHERE inv.list_id = $2 AND ` + ownerOf("inv.list_id", "$1") + `
+		ORDER BY inv.created ASC, inv.id ASC`
+
+	return scanInvitations(conn.Query(sqlStatement, user, listId))
+}
+
+// RetrieveInvitations returns the open invitations for an email address to
+// lists that are not in the trash.
+func RetrieveInvitations(conn Conn, email string) ([]models.Invitation, error) {
+	sqlStatement := `
+		SELECT ` + invitationColumns + `
+		FROM list_invitations inv
+		INNER JOIN lists l ON (l.id = inv.list_id)
+		WHERE lower(inv.email) = lower($1) AND l.deleted = 0
+		ORDER BY inv.created ASC, inv.id ASC`
+
+	return scanInvitations(conn.Query(sqlStatement, email))
+}
+
+func RetrieveInvitation(conn Conn, email, id string) (models.Invitation, error) {
+	sqlStatement := `
+		SELECT ` + invitationColumns + `
+		FROM list_invitations inv
+		INNER JOIN lists l ON (l.id = inv.list_id)
+		WHERE lower(inv.email) = lower($1) AND inv.id = $2 AND l.deleted = 0`
+
+	inv, err := scanInvitation(conn.QueryRow(sqlStatement, email, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to execute query: %s\n", err.Error())
+		}
+		return models.Invitation{}, ErrFailedToLoadData
+	}
+
+	return inv, nil
+}
+
+// DeleteInvitation declines or withdraws an invitation. It can be deleted by
+// the user it was sent to, identified by email, or by the owner of the list.
+func DeleteInvitation(conn Conn, user, email string, id uuid.UUID) error {
+	sqlStatement := `
+		DELETE FROM list_invitations inv
+		WHERE inv.id = $3 AND (lower(inv.email) = lower($2) OR ` + ownerOf("inv.list_id", "$1") + `)`
+
+	res, err := conn.Exec(sqlStatement, user, email, id)
+	if err != nil {
+		log.Println("Failed to delete invitation:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return requireRows(res)
+}
+
+// AcceptInvitation makes the user, identified by email, a member of the list
+// with the invited role and uses up the invitation. position is where the list
+// goes in the user's lists, ts is when the user joined.
+func AcceptInvitation(conn Conn, user, email string, id uuid.UUID, position string, ts int64) error {
+	sqlStatement := `
+		INSERT INTO list_members (list_id, user_id, role, position, position_ts, created)
+		SELECT inv.list_id, $1, inv.role, $4, $5, $5
+		FROM list_invitations inv
+		INNER JOIN lists l ON (l.id = inv.list_id)
+		WHERE inv.id = $3 AND lower(inv.email) = lower($2) AND l.deleted = 0
+		ON CONFLICT DO NOTHING`
+
+	res, err := conn.Exec(sqlStatement, user, email, id, position, ts)
+	if err != nil {
+		log.Println("Failed to accept invitation:", err)
+		return ErrFailedToInsert
+	}
+
+	if err := requireRows(res); err != nil {
+		return err
+	}
+
+	sqlStatement = `DELETE FROM list_invitations WHERE id = $1`
+
+	if _, err := conn.Exec(sqlStatement, id); err != nil {
+		log.Println("Failed to delete invitation:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return nil
+}
+
+// JoinWorkspaceLists makes the user a member of every list of the workspace,
+// including those in the trash, with the given role. Lists the user is
+// already a member of keep their position and get the new role. Whether the
+// user belongs to the workspace is checked against the user database by the
+// caller.
+func JoinWorkspaceLists(conn Conn, workspace, user uuid.UUID, role, position string, ts int64) error {
+	sqlStatement := `
+		INSERT INTO list_members (list_id, user_id, role, position, position_ts, created)
+		SELECT l.id, $2, $3, $4, $5, $5
+		FROM lists l
+		WHERE l.workspace_id = $1
+		ON CONFLICT (list_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+
+	if _, err := conn.Exec(sqlStatement, workspace, user, role, position, ts); err != nil {
+		log.Println("Failed to join workspace lists:", err)
+		return ErrFailedToInsert
+	}
+
+	return nil
+}
+
+// LeaveWorkspaceLists removes the user from every list of the workspace and
+// unassigns the items of those lists assigned to them.
+func LeaveWorkspaceLists(conn Conn, workspace, user uuid.UUID) error {
+	sqlStatement := `
+		DELETE FROM list_members m
+		USING lists l
+		WHERE m.list_id = l.id AND l.workspace_id = $1 AND m.user_id = $2`
+
+	if _, err := conn.Exec(sqlStatement, workspace, user); err != nil {
+		log.Println("Failed to leave workspace lists:", err)
+		return ErrFailedToDeleteData
+	}
+
+	sqlStatement = `
+		UPDATE items SET assignee_id = NULL
+		FROM lists l
+		WHERE items.list_id = l.id AND l.workspace_id = $1 AND items.assignee_id = $2`
+
+	if _, err := conn.Exec(sqlStatement, workspace, user); err != nil {
+		log.Println("Failed to unassign workspace member:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return nil
+}
@@ -0,0 +1,2183 @@
+package db
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is a Store that keeps everything in memory. It is intended for
+// local development and tests, all data is lost when the process exits.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data *memoryData
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: newMemoryData()}
+}
+
+// Transaction runs f against a copy of the data which replaces the store's
+// data only if f succeeds. The store is locked for the duration of f, see
+// clone for what is copied.
+func (s *MemoryStore) Transaction(f func(Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryTx{s.data.clone()}
+	if err := f(tx); err != nil {
+		return err
+	}
+
+	s.data = tx.memoryData
+	return nil
+}
+
+func (s *MemoryStore) RetrieveAllLists(user string) ([]models.List, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveAllLists(user)
+}
+
+func (s *MemoryStore) RetrieveList(user, id string) (models.List, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveList(user, id)
+}
+
+func (s *MemoryStore) CreateList(user string, list models.List) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateList(user, list)
+}
+
+func (s *MemoryStore) UpdateList(user string, list models.List) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateList(user, list)
+}
+
+func (s *MemoryStore) DeleteList(user string, list models.List) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteList(user, list)
+}
+
+func (s *MemoryStore) RetrieveAllItems(user, listId string) ([]models.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveAllItems(user, listId)
+}
+
+func (s *MemoryStore) RetrieveItem(user, id string) (models.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveItem(user, id)
+}
+
+func (s *MemoryStore) CreateItem(user string, item models.Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateItem(user, item)
+}
+
+func (s *MemoryStore) UpdateItem(user string, item models.Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateItem(user, item)
+}
+
+func (s *MemoryStore) DeleteItem(user string, item models.Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteItem(user, item)
+}
+
+func (s *MemoryStore) RetrieveScheduledItems(user string, before int64) ([]models.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveScheduledItems(user, before)
+}
+
+func (s *MemoryStore) RetrieveAssignedItems(user string) ([]models.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveAssignedItems(user)
+}
+
+func (s *MemoryStore) TrashList(user string, list models.List, ts int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.TrashList(user, list, ts)
+}
+
+func (s *MemoryStore) RestoreList(user string, list models.List) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RestoreList(user, list)
+}
+
+func (s *MemoryStore) RetrieveTrashedLists(user string) ([]models.List, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveTrashedLists(user)
+}
+
+func (s *MemoryStore) RetrieveTrashedList(user, id string) (models.List, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveTrashedList(user, id)
+}
+
+func (s *MemoryStore) TrashItem(user string, item models.Item, ts int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.TrashItem(user, item, ts)
+}
+
+func (s *MemoryStore) RestoreItem(user string, item models.Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RestoreItem(user, item)
+}
+
+func (s *MemoryStore) RetrieveTrashedItems(user string) ([]models.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveTrashedItems(user)
+}
+
+func (s *MemoryStore) RetrieveTrashedItem(user, id string) (models.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveTrashedItem(user, id)
+}
+
+func (s *MemoryStore) PurgeTrash(before int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.PurgeTrash(before)
+}
+
+func (s *MemoryStore) RetrieveAllTags(user string) ([]models.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveAllTags(user)
+}
+
+func (s *MemoryStore) RetrieveTag(user, id string) (models.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveTag(user, id)
+}
+
+func (s *MemoryStore) CreateTag(user string, tag models.Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateTag(user, tag)
+}
+
+func (s *MemoryStore) UpdateTag(user string, tag models.Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateTag(user, tag)
+}
+
+func (s *MemoryStore) DeleteTag(user string, tag models.Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteTag(user, tag)
+}
+
+func (s *MemoryStore) TagItem(user string, item, tag uuid.UUID, ts int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.TagItem(user, item, tag, ts)
+}
+
+func (s *MemoryStore) UntagItem(user string, item, tag uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UntagItem(user, item, tag)
+}
+
+func (s *MemoryStore) RetrieveItemTags(user, itemId string) ([]models.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveItemTags(user, itemId)
+}
+
+func (s *MemoryStore) RetrieveTaggedItems(user, tagId string) ([]models.Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveTaggedItems(user, tagId)
+}
+
+func (s *MemoryStore) AddDependency(user string, item, blocker uuid.UUID, ts int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.AddDependency(user, item, blocker, ts)
+}
+
+func (s *MemoryStore) RemoveDependency(user string, item, blocker uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RemoveDependency(user, item, blocker)
+}
+
+func (s *MemoryStore) RetrieveDependencies(user string) ([]models.Dependency, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveDependencies(user)
+}
+
+func (s *MemoryStore) RetrieveComments(user, itemId, after string, limit int) ([]models.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveComments(user, itemId, after, limit)
+}
+
+func (s *MemoryStore) RetrieveComment(user, id string) (models.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveComment(user, id)
+}
+
+func (s *MemoryStore) CreateComment(user string, comment models.Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateComment(user, comment)
+}
+
+func (s *MemoryStore) UpdateComment(user string, comment models.Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateComment(user, comment)
+}
+
+func (s *MemoryStore) DeleteComment(user string, comment models.Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteComment(user, comment)
+}
+
+func (s *MemoryStore) RetrieveMembers(user, listId string) ([]models.Member, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveMembers(user, listId)
+}
+
+func (s *MemoryStore) UpdateMember(user string, member models.Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateMember(user, member)
+}
+
+func (s *MemoryStore) RemoveMember(user string, list, member uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RemoveMember(user, list, member)
+}
+
+func (s *MemoryStore) MoveList(user string, list uuid.UUID, position string, ts int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.MoveList(user, list, position, ts)
+}
+
+func (s *MemoryStore) CreateInvitation(user string, invitation models.Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateInvitation(user, invitation)
+}
+
+func (s *MemoryStore) RetrieveListInvitations(user, listId string) ([]models.Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveListInvitations(user, listId)
+}
+
+func (s *MemoryStore) RetrieveInvitations(email string) ([]models.Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveInvitations(email)
+}
+
+func (s *MemoryStore) RetrieveInvitation(email, id string) (models.Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveInvitation(email, id)
+}
+
+func (s *MemoryStore) DeleteInvitation(user, email string, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteInvitation(user, email, id)
+}
+
+func (s *MemoryStore) AcceptInvitation(user, email string, id uuid.UUID, position string, ts int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.AcceptInvitation(user, email, id, position, ts)
+}
+
+func (s *MemoryStore) JoinWorkspaceLists(workspace, user uuid.UUID, role, position string, ts int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.JoinWorkspaceLists(workspace, user, role, position, ts)
+}
+
+func (s *MemoryStore) LeaveWorkspaceLists(workspace, user uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.LeaveWorkspaceLists(workspace, user)
+}
+
+func (s *MemoryStore) CreateShareLink(user string, link models.ShareLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateShareLink(user, link)
+}
+
+func (s *MemoryStore) RetrieveShareLinks(user, listId string) ([]models.ShareLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveShareLinks(user, listId)
+}
+
+func (s *MemoryStore) DeleteShareLink(user string, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteShareLink(user, id)
+}
+
+func (s *MemoryStore) RetrieveShareLink(hash []byte, now int64) (models.ShareLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveShareLink(hash, now)
+}
+
+func (s *MemoryStore) RetrieveWorkspaces(user string) ([]models.Workspace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveWorkspaces(user)
+}
+
+func (s *MemoryStore) RetrieveWorkspace(user, id string) (models.Workspace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveWorkspace(user, id)
+}
+
+func (s *MemoryStore) CreateWorkspace(user string, workspace models.Workspace) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateWorkspace(user, workspace)
+}
+
+func (s *MemoryStore) UpdateWorkspace(user string, workspace models.Workspace) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateWorkspace(user, workspace)
+}
+
+func (s *MemoryStore) RetrieveWorkspaceMembers(user, workspaceId string) ([]models.WorkspaceMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveWorkspaceMembers(user, workspaceId)
+}
+
+func (s *MemoryStore) AddWorkspaceMember(user string, member models.WorkspaceMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.AddWorkspaceMember(user, member)
+}
+
+func (s *MemoryStore) UpdateWorkspaceMember(user string, member models.WorkspaceMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateWorkspaceMember(user, member)
+}
+
+func (s *MemoryStore) RemoveWorkspaceMember(user string, workspace, member uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RemoveWorkspaceMember(user, workspace, member)
+}
+
+func (s *MemoryStore) GetHistorySince(user string, since uint64) ([]models.History, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.GetHistorySince(user, since)
+}
+
+func (s *MemoryStore) GetHistory(user string, id uuid.UUID) (models.History, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.GetHistory(user, id)
+}
+
+func (s *MemoryStore) CreateHistory(user string, history models.History) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateHistory(user, history)
+}
+
+func (s *MemoryStore) CreateTombstone(user string, id uuid.UUID, ts int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateTombstone(user, id, ts)
+}
+
+func (s *MemoryStore) IsTombstoned(user string, id uuid.UUID) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.IsTombstoned(user, id)
+}
+
+func (s *MemoryStore) DeleteTombstone(user string, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteTombstone(user, id)
+}
+
+func (s *MemoryStore) FindUserByEmail(email string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.FindUserByEmail(email)
+}
+
+func (s *MemoryStore) FindUserByUUID(id string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.FindUserByUUID(id)
+}
+
+func (s *MemoryStore) CreateUser(user models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateUser(user)
+}
+
+func (s *MemoryStore) UpdateUser(user models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateUser(user)
+}
+
+func (s *MemoryStore) CreateUserToken(kind string, token models.UserToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateUserToken(kind, token)
+}
+
+func (s *MemoryStore) UseUserToken(kind string, hash []byte, now int64) (models.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UseUserToken(kind, hash, now)
+}
+
+func (s *MemoryStore) CreateRefreshToken(token models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateRefreshToken(token)
+}
+
+func (s *MemoryStore) RetrieveRefreshToken(hash []byte) (models.RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveRefreshToken(hash)
+}
+
+func (s *MemoryStore) UseRefreshToken(hash []byte, now int64) (models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UseRefreshToken(hash, now)
+}
+
+func (s *MemoryStore) RevokeRefreshTokenFamily(family uuid.UUID, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RevokeRefreshTokenFamily(family, now)
+}
+
+func (s *MemoryStore) RevokeUserRefreshTokens(user string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RevokeUserRefreshTokens(user, now)
+}
+
+func (s *MemoryStore) RevokeAccessToken(jti string, expires int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RevokeAccessToken(jti, expires)
+}
+
+func (s *MemoryStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.IsAccessTokenRevoked(jti)
+}
+
+func (s *MemoryStore) PurgeExpiredTokens(now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.PurgeExpiredTokens(now)
+}
+
+func (s *MemoryStore) CreateSigningKey(key models.SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateSigningKey(key)
+}
+
+func (s *MemoryStore) RetrieveSigningKeys() ([]models.SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.RetrieveSigningKeys()
+}
+
+func (s *MemoryStore) RetireSigningKey(kid string, now int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RetireSigningKey(kid, now)
+}
+
+// memoryTx is the Store handed to a MemoryStore transaction. The outer
+// store's lock is already held so it accesses the data directly.
+type memoryTx struct {
+	*memoryData
+}
+
+func (tx *memoryTx) Transaction(f func(Store) error) error {
+	return f(tx)
+}
+
+// memoryList is a list as it is stored, user is who created it. The position
+// and role are kept with the list's members.
+type memoryList struct {
+	user string
+	list models.List
+}
+
+type memoryMember struct {
+	role       string
+	position   string
+	positionTs int64
+	created    int64
+}
+
+type memoryWorkspace struct {
+	workspace models.Workspace
+	members   map[string]models.WorkspaceMember
+}
+
+type memoryTag struct {
+	user string
+	tag  models.Tag
+}
+
+type memoryHistory struct {
+	user    string
+	history models.History
+}
+
+type memoryData struct {
+	lists       map[uuid.UUID]memoryList
+	members     map[uuid.UUID]map[string]memoryMember
+	invitations map[uuid.UUID]models.Invitation
+	shareLinks  map[uuid.UUID]models.ShareLink
+	workspaces  map[uuid.UUID]memoryWorkspace
+	items       map[uuid.UUID]models.Item
+	tags        map[uuid.UUID]memoryTag
+	itemTags    map[uuid.UUID]map[uuid.UUID]int64
+	blockers    map[uuid.UUID]map[uuid.UUID]int64
+	comments    map[uuid.UUID]models.Comment
+	history     []memoryHistory
+	tombstones  map[uuid.UUID]string
+	users       map[uuid.UUID]models.User
+	tokens      map[string]map[string]models.UserToken
+	refresh     map[string]models.RefreshToken
+	revoked     map[string]int64
+	keys        map[string]models.SigningKey
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		lists:       make(map[uuid.UUID]memoryList),
+		members:     make(map[uuid.UUID]map[string]memoryMember),
+		invitations: make(map[uuid.UUID]models.Invitation),
+		shareLinks:  make(map[uuid.UUID]models.ShareLink),
+		workspaces:  make(map[uuid.UUID]memoryWorkspace),
+		items:       make(map[uuid.UUID]models.Item),
+		tags:        make(map[uuid.UUID]memoryTag),
+		itemTags:    make(map[uuid.UUID]map[uuid.UUID]int64),
+		blockers:    make(map[uuid.UUID]map[uuid.UUID]int64),
+		comments:    make(map[uuid.UUID]models.Comment),
+		history:     make([]memoryHistory, 0),
+		tombstones:  make(map[uuid.UUID]string),
+		users:       make(map[uuid.UUID]models.User),
+		tokens:      make(map[string]map[string]models.UserToken),
+		refresh:     make(map[string]models.RefreshToken),
+		revoked:     make(map[string]int64),
+		keys:        make(map[string]models.SigningKey),
+	}
+}
+
+// clone copies the data a transaction can roll back, which is everything in
+// the procrast database except its history. Like PostgresStore, the user
+// database doesn't take part in transactions so the users, tokens, keys and
+// workspaces are shared. History is only ever appended to, the copy shares
+// its backing array and what a rolled back transaction appended past the
+// store's length is overwritten by the next one. A transaction still costs
+// a copy of every list, item, tag and comment, which is fine for development
+// and tests but is why the MemoryStore is not meant for real data sets.
+func (d *memoryData) clone() *memoryData {
+	c := &memoryData{
+		lists:       make(map[uuid.UUID]memoryList, len(d.lists)),
+		members:     make(map[uuid.UUID]map[string]memoryMember, len(d.members)),
+		invitations: make(map[uuid.UUID]models.Invitation, len(d.invitations)),
+		shareLinks:  make(map[uuid.UUID]models.ShareLink, len(d.shareLinks)),
+		workspaces:  d.workspaces,
+		items:       make(map[uuid.UUID]models.Item, len(d.items)),
+		tags:        make(map[uuid.UUID]memoryTag, len(d.tags)),
+		itemTags:    make(map[uuid.UUID]map[uuid.UUID]int64, len(d.itemTags)),
+		blockers:    make(map[uuid.UUID]map[uuid.UUID]int64, len(d.blockers)),
+		comments:    make(map[uuid.UUID]models.Comment, len(d.comments)),
+		history:     d.history,
+		tombstones:  make(map[uuid.UUID]string, len(d.tombstones)),
+		users:       d.users,
+		tokens:      d.tokens,
+		refresh:     d.refresh,
+		revoked:     d.revoked,
+		keys:        d.keys,
+	}
+	for k, v := range d.lists {
+		c.lists[k] = v
+	}
+	for list, members := range d.members {
+		c.members[list] = make(map[string]memoryMember, len(members))
+		for k, v := range members {
+			c.members[list][k] = v
+		}
+	}
+	for k, v := range d.invitations {
+		c.invitations[k] = v
+	}
+	for k, v := range d.shareLinks {
+		c.shareLinks[k] = v
+	}
+	for k, v := range d.items {
+		c.items[k] = v
+	}
+	for k, v := range d.tags {
+		c.tags[k] = v
+	}
+	for item, tags := range d.itemTags {
+		c.itemTags[item] = make(map[uuid.UUID]int64, len(tags))
+		for k, v := range tags {
+			c.itemTags[item][k] = v
+		}
+	}
+	for item, blockers := range d.blockers {
+		c.blockers[item] = make(map[uuid.UUID]int64, len(blockers))
+		for k, v := range blockers {
+			c.blockers[item][k] = v
+		}
+	}
+	for k, v := range d.comments {
+		c.comments[k] = v
+	}
+	for k, v := range d.tombstones {
+		c.tombstones[k] = v
+	}
+	return c
+}
+
+// memberOf, editorOf and ownsList are the access checks used by every list
+// and item method, matching the list_members checks in the postgres queries.
+func (d *memoryData) memberOf(user string, id uuid.UUID) bool {
+	_, ok := d.members[id][user]
+	return ok
+}
+
+func (d *memoryData) editorOf(user string, id uuid.UUID) bool {
+	return models.CanEdit(d.members[id][user].role)
+}
+
+func (d *memoryData) ownsList(user string, id uuid.UUID) bool {
+	return d.members[id][user].role == models.RoleOwner
+}
+
+// itemMember and itemEditor apply memberOf and editorOf to the item's list.
+func (d *memoryData) itemMember(user string, id uuid.UUID) bool {
+	item, ok := d.items[id]
+	return ok && d.memberOf(user, item.ListUUID)
+}
+
+func (d *memoryData) itemEditor(user string, id uuid.UUID) bool {
+	item, ok := d.items[id]
+	return ok && d.editorOf(user, item.ListUUID)
+}
+
+// liveList and liveItem also require that the list or item is not in the
+// trash, matching the deleted = 0 filters. editableList and editableItem are
+// the same for members that can change them.
+func (d *memoryData) liveList(user string, id uuid.UUID) bool {
+	return d.memberOf(user, id) && d.lists[id].list.Deleted == 0
+}
+
+func (d *memoryData) liveItem(user string, id uuid.UUID) bool {
+	return d.itemMember(user, id) && d.items[id].Deleted == 0
+}
+
+func (d *memoryData) editableList(user string, id uuid.UUID) bool {
+	return d.editorOf(user, id) && d.lists[id].list.Deleted == 0
+}
+
+func (d *memoryData) editableItem(user string, id uuid.UUID) bool {
+	return d.itemEditor(user, id) && d.items[id].Deleted == 0
+}
+
+// listFor returns a copy of the list as it is seen by the member, with their
+// position and role.
+func (d *memoryData) listFor(user string, id uuid.UUID) models.List {
+	m := d.members[id][user]
+	list := d.lists[id].list
+	list.Position = m.position
+	list.Role = m.role
+	list.Workflow = append([]models.WorkflowState(nil), list.Workflow...)
+	list.Clock = list.Clock.Copy()
+	if m.positionTs != 0 {
+		list.Clock.Set("position", m.positionTs)
+	}
+	return list
+}
+
+// removeList deletes the list along with its members, invitations and share
+// links. Its items have to be removed first.
+func (d *memoryData) removeList(id uuid.UUID) {
+	delete(d.lists, id)
+	delete(d.members, id)
+	for invId, inv := range d.invitations {
+		if inv.ListUUID == id {
+			delete(d.invitations, invId)
+		}
+	}
+	for linkId, link := range d.shareLinks {
+		if link.ListUUID == id {
+			delete(d.shareLinks, linkId)
+		}
+	}
+}
+
+// liveParent reports whether the item is top level or its parent is a live
+// item in the item's list.
+func (d *memoryData) liveParent(user string, item models.Item) bool {
+	if item.ParentUUID == uuid.Nil {
+		return true
+	}
+	return d.liveItem(user, item.ParentUUID) && d.items[item.ParentUUID].ListUUID == item.ListUUID
+}
+
+// removeItem deletes the item along with its tags, dependencies and comments,
+// like the cascading foreign keys do.
+func (d *memoryData) removeItem(id uuid.UUID) {
+	delete(d.items, id)
+	delete(d.itemTags, id)
+	delete(d.blockers, id)
+	for _, blockers := range d.blockers {
+		delete(blockers, id)
+	}
+	for commentId, comment := range d.comments {
+		if comment.ItemUUID == id {
+			delete(d.comments, commentId)
+		}
+	}
+}
+
+// descendants returns the ids of the item's subtasks at any depth, whether or
+// not they are in the trash.
+func (d *memoryData) descendants(id uuid.UUID) []uuid.UUID {
+	ids := make([]uuid.UUID, 0)
+	for i := -1; i < len(ids); i++ {
+		parent := id
+		if i >= 0 {
+			parent = ids[i]
+		}
+
+		for childId, item := range d.items {
+			if item.ParentUUID == parent {
+				ids = append(ids, childId)
+			}
+		}
+	}
+	return ids
+}
+
+func (d *memoryData) RetrieveAllLists(user string) ([]models.List, error) {
+	lists := make([]models.List, 0)
+	for id := range d.lists {
+		if d.liveList(user, id) {
+			lists = append(lists, d.listFor(user, id))
+		}
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Position == lists[j].Position {
+			return lists[i].UUID.String() < lists[j].UUID.String()
+		}
+		return lists[i].Position < lists[j].Position
+	})
+	return lists, nil
+}
+
+func (d *memoryData) RetrieveList(user, id string) (models.List, error) {
+	listId, err := uuid.Parse(id)
+	if err != nil {
+		return models.List{}, ErrFailedToLoadData
+	}
+
+	if !d.liveList(user, listId) {
+		return models.List{}, ErrFailedToLoadData
+	}
+
+	return d.listFor(user, listId), nil
+}
+
+// listClock copies the clock without the position, which is clocked per
+// member.
+func listClock(clock models.Clock) models.Clock {
+	clock = clock.Copy()
+	delete(clock, "position")
+	return clock
+}
+
+func (d *memoryData) CreateList(user string, list models.List) error {
+	if _, ok := d.lists[list.UUID]; ok {
+		return ErrNoAccess
+	}
+
+	d.members[list.UUID] = map[string]memoryMember{
+		user: {
+			role:       models.RoleOwner,
+			position:   list.Position,
+			positionTs: list.Clock.Get("position", 0),
+			created:    list.Created,
+		},
+	}
+
+	list.Position = ""
+	list.Role = ""
+	list.Workflow = append([]models.WorkflowState(nil), list.States()...)
+	list.Clock = listClock(list.Clock)
+	d.lists[list.UUID] = memoryList{user: user, list: list}
+	return nil
+}
+
+func (d *memoryData) UpdateList(user string, list models.List) error {
+	if !d.editableList(user, list.UUID) {
+		return ErrNoAccess
+	}
+
+	l := d.lists[list.UUID]
+	l.list.Modified = list.Modified
+	l.list.Title = list.Title
+	l.list.Description = list.Description
+	l.list.Workflow = append([]models.WorkflowState(nil), list.States()...)
+	l.list.Clock = listClock(list.Clock)
+	d.lists[list.UUID] = l
+	return nil
+}
+
+func (d *memoryData) MoveList(user string, list uuid.UUID, position string, ts int64) error {
+	if !d.liveList(user, list) {
+		return ErrNoAccess
+	}
+
+	m := d.members[list][user]
+	m.position = position
+	m.positionTs = ts
+	d.members[list][user] = m
+	return nil
+}
+
+func (d *memoryData) DeleteList(user string, list models.List) error {
+	if !d.ownsList(user, list.UUID) {
+		return ErrNoAccess
+	}
+
+	for id, item := range d.items {
+		if item.ListUUID == list.UUID {
+			d.removeItem(id)
+		}
+	}
+	d.removeList(list.UUID)
+	return nil
+}
+
+func (d *memoryData) RetrieveAllItems(user, listId string) ([]models.Item, error) {
+	list, err := d.RetrieveList(user, listId)
+	if err != nil {
+		return []models.Item{}, nil
+	}
+
+	items := make([]models.Item, 0)
+	for _, item := range d.items {
+		if item.ListUUID == list.UUID && item.Deleted == 0 {
+			item.Clock = item.Clock.Copy()
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Position == items[j].Position {
+			return items[i].UUID.String() < items[j].UUID.String()
+		}
+		return items[i].Position < items[j].Position
+	})
+	return items, nil
+}
+
+func (d *memoryData) RetrieveItem(user, id string) (models.Item, error) {
+	itemId, err := uuid.Parse(id)
+	if err != nil {
+		return models.Item{}, ErrFailedToLoadData
+	}
+
+	if !d.liveItem(user, itemId) {
+		return models.Item{}, ErrFailedToLoadData
+	}
+
+	item := d.items[itemId]
+	item.Clock = item.Clock.Copy()
+	return item, nil
+}
+
+func (d *memoryData) CreateItem(user string, item models.Item) error {
+	if _, ok := d.items[item.UUID]; ok {
+		return ErrNoAccess
+	}
+
+	if !d.editableList(user, item.ListUUID) || !d.liveParent(user, item) {
+		return ErrNoAccess
+	}
+
+	if !d.memberOf(item.Assignee().String(), item.ListUUID) {
+		item.Assign(uuid.Nil)
+	}
+
+	item.Clock = item.Clock.Copy()
+	d.items[item.UUID] = item
+	return nil
+}
+
+func (d *memoryData) UpdateItem(user string, item models.Item) error {
+	if !d.editableItem(user, item.UUID) || !d.editableList(user, item.ListUUID) || !d.liveParent(user, item) {
+		return ErrNoAccess
+	}
+
+	stored := d.items[item.UUID]
+	stored.Modified = item.Modified
+	stored.Title = item.Title
+	stored.Description = item.Description
+	stored.State = item.State
+	stored.Due = item.Due
+	stored.Start = item.Start
+	stored.RRule = item.RRule
+	stored.Position = item.Position
+	stored.ListUUID = item.ListUUID
+	stored.ParentUUID = item.ParentUUID
+	stored.AssigneeUUID = item.AssigneeUUID
+	stored.Clock = item.Clock.Copy()
+	d.items[item.UUID] = stored
+
+	for _, id := range append(d.descendants(item.UUID), item.UUID) {
+		moved := d.items[id]
+		moved.ListUUID = item.ListUUID
+		if !d.memberOf(moved.Assignee().String(), moved.ListUUID) {
+			moved.Assign(uuid.Nil)
+		}
+		d.items[id] = moved
+	}
+	return nil
+}
+
+// unassign clears the assignments of the user on the items that match.
+func (d *memoryData) unassign(user uuid.UUID, match func(models.Item) bool) {
+	for id, item := range d.items {
+		if item.Assignee() == user && match(item) {
+			item.Assign(uuid.Nil)
+			d.items[id] = item
+		}
+	}
+}
+
+func (d *memoryData) DeleteItem(user string, item models.Item) error {
+	if !d.itemEditor(user, item.UUID) {
+		return ErrNoAccess
+	}
+
+	for _, id := range append(d.descendants(item.UUID), item.UUID) {
+		d.removeItem(id)
+	}
+	return nil
+}
+
+func (d *memoryData) RetrieveScheduledItems(user string, before int64) ([]models.Item, error) {
+	scheduled := func(ts int64) bool {
+		return ts != 0 && ts < before
+	}
+
+	items := make([]models.Item, 0)
+	for id, item := range d.items {
+		if d.liveItem(user, id) && d.liveList(user, item.ListUUID) && (scheduled(item.Due) || scheduled(item.Start)) {
+			item.Clock = item.Clock.Copy()
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Created == items[j].Created {
+			return items[i].UUID.String() < items[j].UUID.String()
+		}
+		return items[i].Created < items[j].Created
+	})
+	return items, nil
+}
+
+func (d *memoryData) RetrieveAssignedItems(user string) ([]models.Item, error) {
+	items := make([]models.Item, 0)
+	for id, item := range d.items {
+		if d.liveItem(user, id) && d.liveList(user, item.ListUUID) && item.Assignee().String() == user {
+			item.Clock = item.Clock.Copy()
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Created == items[j].Created {
+			return items[i].UUID.String() < items[j].UUID.String()
+		}
+		return items[i].Created < items[j].Created
+	})
+	return items, nil
+}
+
+func (d *memoryData) TrashList(user string, list models.List, ts int64) error {
+	if !d.liveList(user, list.UUID) || !d.ownsList(user, list.UUID) {
+		return ErrNoAccess
+	}
+
+	for id, item := range d.items {
+		if item.ListUUID == list.UUID && item.Deleted == 0 {
+			item.Deleted = ts
+			d.items[id] = item
+		}
+	}
+
+	l := d.lists[list.UUID]
+	l.list.Deleted = ts
+	d.lists[list.UUID] = l
+	return nil
+}
+
+func (d *memoryData) RestoreList(user string, list models.List) error {
+	if !d.ownsList(user, list.UUID) || d.lists[list.UUID].list.Deleted == 0 {
+		return ErrNoAccess
+	}
+
+	l := d.lists[list.UUID]
+	l.list.Deleted = 0
+	d.lists[list.UUID] = l
+
+	for id, item := range d.items {
+		if item.ListUUID == list.UUID && item.Deleted == list.Deleted {
+			item.Deleted = 0
+			d.items[id] = item
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) RetrieveTrashedLists(user string) ([]models.List, error) {
+	lists := make([]models.List, 0)
+	for id, l := range d.lists {
+		if d.ownsList(user, id) && l.list.Deleted != 0 {
+			lists = append(lists, d.listFor(user, id))
+		}
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Deleted > lists[j].Deleted
+	})
+	return lists, nil
+}
+
+func (d *memoryData) RetrieveTrashedList(user, id string) (models.List, error) {
+	listId, err := uuid.Parse(id)
+	if err != nil {
+		return models.List{}, ErrFailedToLoadData
+	}
+
+	if !d.ownsList(user, listId) || d.lists[listId].list.Deleted == 0 {
+		return models.List{}, ErrFailedToLoadData
+	}
+
+	return d.listFor(user, listId), nil
+}
+
+func (d *memoryData) TrashItem(user string, item models.Item, ts int64) error {
+	if !d.editableItem(user, item.UUID) {
+		return ErrNoAccess
+	}
+
+	stored := d.items[item.UUID]
+	stored.Deleted = ts
+	d.items[item.UUID] = stored
+
+	for _, id := range d.descendants(item.UUID) {
+		if child := d.items[id]; child.Deleted == 0 {
+			child.Deleted = ts
+			d.items[id] = child
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) RestoreItem(user string, item models.Item) error {
+	stored, ok := d.items[item.UUID]
+	if !ok || stored.Deleted == 0 || !d.editableList(user, stored.ListUUID) || !d.liveParent(user, stored) {
+		return ErrNoAccess
+	}
+
+	stored.Deleted = 0
+	d.items[item.UUID] = stored
+
+	for _, id := range d.descendants(item.UUID) {
+		if child := d.items[id]; child.Deleted == item.Deleted {
+			child.Deleted = 0
+			d.items[id] = child
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) RetrieveTrashedItems(user string) ([]models.Item, error) {
+	items := make([]models.Item, 0)
+	for _, item := range d.items {
+		if parent, ok := d.items[item.ParentUUID]; ok && parent.Deleted == item.Deleted {
+			continue
+		}
+
+		if item.Deleted != 0 && d.editableList(user, item.ListUUID) {
+			item.Clock = item.Clock.Copy()
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted > items[j].Deleted
+	})
+	return items, nil
+}
+
+func (d *memoryData) RetrieveTrashedItem(user, id string) (models.Item, error) {
+	itemId, err := uuid.Parse(id)
+	if err != nil {
+		return models.Item{}, ErrFailedToLoadData
+	}
+
+	if !d.itemEditor(user, itemId) || d.items[itemId].Deleted == 0 {
+		return models.Item{}, ErrFailedToLoadData
+	}
+
+	item := d.items[itemId]
+	item.Clock = item.Clock.Copy()
+	return item, nil
+}
+
+func (d *memoryData) PurgeTrash(before int64) error {
+	purged := func(deleted int64) bool {
+		return deleted != 0 && deleted < before
+	}
+
+	for id, item := range d.items {
+		if purged(item.Deleted) || purged(d.lists[item.ListUUID].list.Deleted) {
+			for _, child := range append(d.descendants(id), id) {
+				d.removeItem(child)
+			}
+		}
+	}
+
+	for id, l := range d.lists {
+		if purged(l.list.Deleted) {
+			d.removeList(id)
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) ownsTag(user string, id uuid.UUID) bool {
+	t, ok := d.tags[id]
+	return ok && t.user == user
+}
+
+func sortTags(tags []models.Tag) {
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Name == tags[j].Name {
+			return tags[i].UUID.String() < tags[j].UUID.String()
+		}
+		return tags[i].Name < tags[j].Name
+	})
+}
+
+func (d *memoryData) RetrieveAllTags(user string) ([]models.Tag, error) {
+	tags := make([]models.Tag, 0)
+	for _, t := range d.tags {
+		if t.user == user {
+			tag := t.tag
+			tag.Clock = tag.Clock.Copy()
+			tags = append(tags, tag)
+		}
+	}
+
+	sortTags(tags)
+	return tags, nil
+}
+
+func (d *memoryData) RetrieveTag(user, id string) (models.Tag, error) {
+	tagId, err := uuid.Parse(id)
+	if err != nil {
+		return models.Tag{}, ErrFailedToLoadData
+	}
+
+	if !d.ownsTag(user, tagId) {
+		return models.Tag{}, ErrFailedToLoadData
+	}
+
+	tag := d.tags[tagId].tag
+	tag.Clock = tag.Clock.Copy()
+	return tag, nil
+}
+
+func (d *memoryData) CreateTag(user string, tag models.Tag) error {
+	if _, ok := d.tags[tag.UUID]; ok {
+		return ErrFailedToInsert
+	}
+
+	tag.Clock = tag.Clock.Copy()
+	d.tags[tag.UUID] = memoryTag{user: user, tag: tag}
+	return nil
+}
+
+func (d *memoryData) UpdateTag(user string, tag models.Tag) error {
+	if !d.ownsTag(user, tag.UUID) {
+		return ErrNoAccess
+	}
+
+	t := d.tags[tag.UUID]
+	t.tag.Modified = tag.Modified
+	t.tag.Name = tag.Name
+	t.tag.Color = tag.Color
+	t.tag.Clock = tag.Clock.Copy()
+	d.tags[tag.UUID] = t
+	return nil
+}
+
+func (d *memoryData) DeleteTag(user string, tag models.Tag) error {
+	if !d.ownsTag(user, tag.UUID) {
+		return ErrNoAccess
+	}
+
+	for _, tags := range d.itemTags {
+		delete(tags, tag.UUID)
+	}
+	delete(d.tags, tag.UUID)
+	return nil
+}
+
+func (d *memoryData) TagItem(user string, item, tag uuid.UUID, ts int64) error {
+	if !d.liveItem(user, item) || !d.liveList(user, d.items[item].ListUUID) || !d.ownsTag(user, tag) {
+		return ErrNoAccess
+	}
+
+	if _, ok := d.itemTags[item][tag]; ok {
+		return ErrNoAccess
+	}
+
+	if d.itemTags[item] == nil {
+		d.itemTags[item] = make(map[uuid.UUID]int64)
+	}
+	d.itemTags[item][tag] = ts
+	return nil
+}
+
+func (d *memoryData) UntagItem(user string, item, tag uuid.UUID) error {
+	if _, ok := d.itemTags[item][tag]; !ok || !d.ownsTag(user, tag) {
+		return ErrNoAccess
+	}
+
+	delete(d.itemTags[item], tag)
+	return nil
+}
+
+func (d *memoryData) RetrieveItemTags(user, itemId string) ([]models.Tag, error) {
+	id, err := uuid.Parse(itemId)
+	if err != nil {
+		return []models.Tag{}, ErrFailedToLoadData
+	}
+
+	tags := make([]models.Tag, 0)
+	for tagId := range d.itemTags[id] {
+		if d.ownsTag(user, tagId) {
+			tag := d.tags[tagId].tag
+			tag.Clock = tag.Clock.Copy()
+			tags = append(tags, tag)
+		}
+	}
+
+	sortTags(tags)
+	return tags, nil
+}
+
+func (d *memoryData) RetrieveTaggedItems(user, tagId string) ([]models.Item, error) {
+	id, err := uuid.Parse(tagId)
+	if err != nil {
+		return []models.Item{}, ErrFailedToLoadData
+	}
+
+	items := make([]models.Item, 0)
+	for itemId, tags := range d.itemTags {
+		if _, ok := tags[id]; !ok || !d.liveItem(user, itemId) {
+			continue
+		}
+
+		item := d.items[itemId]
+		if d.liveList(user, item.ListUUID) {
+			item.Clock = item.Clock.Copy()
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Created == items[j].Created {
+			return items[i].UUID.String() < items[j].UUID.String()
+		}
+		return items[i].Created < items[j].Created
+	})
+	return items, nil
+}
+
+func (d *memoryData) AddDependency(user string, item, blocker uuid.UUID, ts int64) error {
+	live := func(id uuid.UUID) bool {
+		return d.liveItem(user, id) && d.liveList(user, d.items[id].ListUUID)
+	}
+
+	if item == blocker || !live(item) || !live(blocker) || !d.itemEditor(user, item) {
+		return ErrNoAccess
+	}
+
+	if _, ok := d.blockers[item][blocker]; ok {
+		return ErrNoAccess
+	}
+
+	if d.blockers[item] == nil {
+		d.blockers[item] = make(map[uuid.UUID]int64)
+	}
+	d.blockers[item][blocker] = ts
+	return nil
+}
+
+func (d *memoryData) RemoveDependency(user string, item, blocker uuid.UUID) error {
+	if _, ok := d.blockers[item][blocker]; !ok || !d.itemEditor(user, item) {
+		return ErrNoAccess
+	}
+
+	delete(d.blockers[item], blocker)
+	return nil
+}
+
+func (d *memoryData) RetrieveDependencies(user string) ([]models.Dependency, error) {
+	dependencies := make([]models.Dependency, 0)
+	for item, blockers := range d.blockers {
+		if !d.itemMember(user, item) {
+			continue
+		}
+
+		for blocker, created := range blockers {
+			dependencies = append(dependencies, models.Dependency{ItemUUID: item, BlockerUUID: blocker, Created: created})
+		}
+	}
+
+	sort.Slice(dependencies, func(i, j int) bool {
+		a, b := dependencies[i], dependencies[j]
+		if a.Created != b.Created {
+			return a.Created < b.Created
+		}
+		if a.ItemUUID != b.ItemUUID {
+			return a.ItemUUID.String() < b.ItemUUID.String()
+		}
+		return a.BlockerUUID.String() < b.BlockerUUID.String()
+	})
+	return dependencies, nil
+}
+
+// commentBefore orders comments by when they were written, then by uuid.
+func commentBefore(a, b models.Comment) bool {
+	if a.Created != b.Created {
+		return a.Created < b.Created
+	}
+	return a.UUID.String() < b.UUID.String()
+}
+
+func (d *memoryData) RetrieveComments(user, itemId, after string, limit int) ([]models.Comment, error) {
+	id, err := uuid.Parse(itemId)
+	if err != nil || !d.liveItem(user, id) {
+		return []models.Comment{}, nil
+	}
+
+	var start *models.Comment
+	if after != "" {
+		c, err := d.RetrieveComment(user, after)
+		if err != nil {
+			return []models.Comment{}, nil
+		}
+		start = &c
+	}
+
+	comments := make([]models.Comment, 0)
+	for _, comment := range d.comments {
+		if comment.ItemUUID == id && (start == nil || commentBefore(*start, comment)) {
+			comments = append(comments, comment)
+		}
+	}
+
+	sort.Slice(comments, func(i, j int) bool {
+		return commentBefore(comments[i], comments[j])
+	})
+
+	if limit > 0 && len(comments) > limit {
+		comments = comments[:limit]
+	}
+	return comments, nil
+}
+
+func (d *memoryData) RetrieveComment(user, id string) (models.Comment, error) {
+	commentId, err := uuid.Parse(id)
+	if err != nil {
+		return models.Comment{}, ErrFailedToLoadData
+	}
+
+	comment, ok := d.comments[commentId]
+	if !ok || !d.liveItem(user, comment.ItemUUID) {
+		return models.Comment{}, ErrFailedToLoadData
+	}
+	return comment, nil
+}
+
+// authorOf reports whether the user wrote the comment and can still edit the
+// item it is on.
+func (d *memoryData) authorOf(user string, id uuid.UUID) bool {
+	comment, ok := d.comments[id]
+	return ok && comment.Author.String() == user && d.editableItem(user, comment.ItemUUID)
+}
+
+func (d *memoryData) CreateComment(user string, comment models.Comment) error {
+	if _, ok := d.comments[comment.UUID]; ok {
+		return ErrFailedToInsert
+	}
+
+	if !d.editableItem(user, comment.ItemUUID) {
+		return ErrNoAccess
+	}
+
+	if comment.ParentUUID != uuid.Nil {
+		if parent, ok := d.comments[comment.ParentUUID]; !ok || parent.ItemUUID != comment.ItemUUID {
+			return ErrNoAccess
+		}
+	}
+
+	comment.Author, _ = uuid.Parse(user)
+	d.comments[comment.UUID] = comment
+	return nil
+}
+
+func (d *memoryData) UpdateComment(user string, comment models.Comment) error {
+	if !d.authorOf(user, comment.UUID) {
+		return ErrNoAccess
+	}
+
+	stored := d.comments[comment.UUID]
+	stored.Body = comment.Body
+	stored.Edited = comment.Edited
+	d.comments[comment.UUID] = stored
+	return nil
+}
+
+func (d *memoryData) DeleteComment(user string, comment models.Comment) error {
+	if !d.authorOf(user, comment.UUID) {
+		return ErrNoAccess
+	}
+
+	deleted := []uuid.UUID{comment.UUID}
+	for i := 0; i < len(deleted); i++ {
+		for id, reply := range d.comments {
+			if reply.ParentUUID == deleted[i] {
+				deleted = append(deleted, id)
+			}
+		}
+		delete(d.comments, deleted[i])
+	}
+	return nil
+}
+
+func (d *memoryData) RetrieveMembers(user, listId string) ([]models.Member, error) {
+	id, err := uuid.Parse(listId)
+	if err != nil || !d.memberOf(user, id) {
+		return []models.Member{}, nil
+	}
+
+	members := make([]models.Member, 0)
+	for userId, m := range d.members[id] {
+		member := models.Member{ListUUID: id, Role: m.role, Created: m.created}
+		member.UserUUID, _ = uuid.Parse(userId)
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if (a.Role == models.RoleOwner) != (b.Role == models.RoleOwner) {
+			return a.Role == models.RoleOwner
+		}
+		if a.Created != b.Created {
+			return a.Created < b.Created
+		}
+		return a.UserUUID.String() < b.UserUUID.String()
+	})
+	return members, nil
+}
+
+func (d *memoryData) UpdateMember(user string, member models.Member) error {
+	m, ok := d.members[member.ListUUID][member.UserUUID.String()]
+	if !ok || m.role == models.RoleOwner || !d.ownsList(user, member.ListUUID) {
+		return ErrNoAccess
+	}
+
+	m.role = member.Role
+	d.members[member.ListUUID][member.UserUUID.String()] = m
+	return nil
+}
+
+func (d *memoryData) RemoveMember(user string, list, member uuid.UUID) error {
+	m, ok := d.members[list][member.String()]
+	if !ok || m.role == models.RoleOwner || (member.String() != user && !d.ownsList(user, list)) {
+		return ErrNoAccess
+	}
+
+	delete(d.members[list], member.String())
+	d.unassign(member, func(item models.Item) bool {
+		return item.ListUUID == list
+	})
+	return nil
+}
+
+// sameEmail matches email addresses case insensitively, like the lower(email)
+// indexes on list_invitations and users.
+func sameEmail(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+func sortInvitations(invitations []models.Invitation) {
+	sort.Slice(invitations, func(i, j int) bool {
+		if invitations[i].Created == invitations[j].Created {
+			return invitations[i].UUID.String() < invitations[j].UUID.String()
+		}
+		return invitations[i].Created < invitations[j].Created
+	})
+}
+
+func (d *memoryData) CreateInvitation(user string, invitation models.Invitation) error {
+	if _, ok := d.invitations[invitation.UUID]; ok {
+		return ErrFailedToInsert
+	}
+
+	if !d.liveList(user, invitation.ListUUID) || !d.ownsList(user, invitation.ListUUID) {
+		return ErrNoAccess
+	}
+
+	for _, inv := range d.invitations {
+		if inv.ListUUID == invitation.ListUUID && sameEmail(inv.Email, invitation.Email) {
+			return ErrNoAccess
+		}
+	}
+
+	d.invitations[invitation.UUID] = invitation
+	return nil
+}
+
+func (d *memoryData) RetrieveListInvitations(user, listId string) ([]models.Invitation, error) {
+	id, err := uuid.Parse(listId)
+	if err != nil || !d.ownsList(user, id) {
+		return []models.Invitation{}, nil
+	}
+
+	invitations := make([]models.Invitation, 0)
+	for _, inv := range d.invitations {
+		if inv.ListUUID == id {
+			invitations = append(invitations, inv)
+		}
+	}
+
+	sortInvitations(invitations)
+	return invitations, nil
+}
+
+func (d *memoryData) RetrieveInvitations(email string) ([]models.Invitation, error) {
+	invitations := make([]models.Invitation, 0)
+	for _, inv := range d.invitations {
+		if sameEmail(inv.Email, email) && d.lists[inv.ListUUID].list.Deleted == 0 {
+			invitations = append(invitations, inv)
+		}
+	}
+
+	sortInvitations(invitations)
+	return invitations, nil
+}
+
+func (d *memoryData) RetrieveInvitation(email, id string) (models.Invitation, error) {
+	invId, err := uuid.Parse(id)
+	if err != nil {
+		return models.Invitation{}, ErrFailedToLoadData
+	}
+
+	inv, ok := d.invitations[invId]
+	if !ok || !sameEmail(inv.Email, email) || d.lists[inv.ListUUID].list.Deleted != 0 {
+		return models.Invitation{}, ErrFailedToLoadData
+	}
+	return inv, nil
+}
+
+func (d *memoryData) DeleteInvitation(user, email string, id uuid.UUID) error {
+	inv, ok := d.invitations[id]
+	if !ok || (!sameEmail(inv.Email, email) && !d.ownsList(user, inv.ListUUID)) {
+		return ErrNoAccess
+	}
+
+	delete(d.invitations, id)
+	return nil
+}
+
+func (d *memoryData) AcceptInvitation(user, email string, id uuid.UUID, position string, ts int64) error {
+	inv, err := d.RetrieveInvitation(email, id.String())
+	if err != nil || d.memberOf(user, inv.ListUUID) {
+		return ErrNoAccess
+	}
+
+	d.members[inv.ListUUID][user] = memoryMember{
+		role:       inv.Role,
+		position:   position,
+		positionTs: ts,
+		created:    ts,
+	}
+	delete(d.invitations, id)
+	return nil
+}
+
+func (d *memoryData) CreateShareLink(user string, link models.ShareLink) error {
+	if _, ok := d.shareLinks[link.UUID]; ok {
+		return ErrFailedToInsert
+	}
+
+	if !d.liveList(user, link.ListUUID) || !d.ownsList(user, link.ListUUID) {
+		return ErrNoAccess
+	}
+
+	link.Token = ""
+	link.CreatedBy, _ = uuid.Parse(user)
+	d.shareLinks[link.UUID] = link
+	return nil
+}
+
+func (d *memoryData) RetrieveShareLinks(user, listId string) ([]models.ShareLink, error) {
+	id, err := uuid.Parse(listId)
+	if err != nil || !d.ownsList(user, id) {
+		return []models.ShareLink{}, nil
+	}
+
+	links := make([]models.ShareLink, 0)
+	for _, link := range d.shareLinks {
+		if link.ListUUID == id {
+			links = append(links, link)
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Created == links[j].Created {
+			return links[i].UUID.String() < links[j].UUID.String()
+		}
+		return links[i].Created < links[j].Created
+	})
+	return links, nil
+}
+
+func (d *memoryData) DeleteShareLink(user string, id uuid.UUID) error {
+	link, ok := d.shareLinks[id]
+	if !ok || !d.ownsList(user, link.ListUUID) {
+		return ErrNoAccess
+	}
+
+	delete(d.shareLinks, id)
+	return nil
+}
+
+func (d *memoryData) RetrieveShareLink(hash []byte, now int64) (models.ShareLink, error) {
+	for _, link := range d.shareLinks {
+		if !bytes.Equal(link.Hash, hash) || (link.Expires != 0 && link.Expires <= now) {
+			continue
+		}
+
+		if !d.ownsList(link.CreatedBy.String(), link.ListUUID) {
+			break
+		}
+		return link, nil
+	}
+	return models.ShareLink{}, ErrFailedToLoadData
+}
+
+func (d *memoryData) JoinWorkspaceLists(workspace, user uuid.UUID, role, position string, ts int64) error {
+	for id, l := range d.lists {
+		if l.list.WorkspaceUUID != workspace {
+			continue
+		}
+
+		m, ok := d.members[id][user.String()]
+		if !ok {
+			m = memoryMember{position: position, positionTs: ts, created: ts}
+		}
+		m.role = role
+		d.members[id][user.String()] = m
+	}
+	return nil
+}
+
+func (d *memoryData) LeaveWorkspaceLists(workspace, user uuid.UUID) error {
+	for id, l := range d.lists {
+		if l.list.WorkspaceUUID == workspace {
+			delete(d.members[id], user.String())
+		}
+	}
+	d.unassign(user, func(item models.Item) bool {
+		return d.lists[item.ListUUID].list.WorkspaceUUID == workspace
+	})
+	return nil
+}
+
+// workspaceRole is the user's role in the workspace, empty if they are not a
+// member.
+func (d *memoryData) workspaceRole(user string, id uuid.UUID) string {
+	return d.workspaces[id].members[user].Role
+}
+
+// keepsWorkspaceOwner reports whether the workspace still has an owner without
+// the member, matching the check in the postgres queries.
+func (d *memoryData) keepsWorkspaceOwner(id uuid.UUID, member string) bool {
+	if d.workspaceRole(member, id) != models.RoleOwner {
+		return true
+	}
+
+	for user, m := range d.workspaces[id].members {
+		if user != member && m.Role == models.RoleOwner {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *memoryData) RetrieveWorkspaces(user string) ([]models.Workspace, error) {
+	workspaces := make([]models.Workspace, 0)
+	for id, w := range d.workspaces {
+		if role := d.workspaceRole(user, id); role != "" {
+			workspace := w.workspace
+			workspace.Role = role
+			workspaces = append(workspaces, workspace)
+		}
+	}
+
+	sort.Slice(workspaces, func(i, j int) bool {
+		if workspaces[i].Name == workspaces[j].Name {
+			return workspaces[i].UUID.String() < workspaces[j].UUID.String()
+		}
+		return workspaces[i].Name < workspaces[j].Name
+	})
+	return workspaces, nil
+}
+
+func (d *memoryData) RetrieveWorkspace(user, id string) (models.Workspace, error) {
+	workspaceId, err := uuid.Parse(id)
+	if err != nil {
+		return models.Workspace{}, ErrFailedToLoadData
+	}
+
+	role := d.workspaceRole(user, workspaceId)
+	if role == "" {
+		return models.Workspace{}, ErrFailedToLoadData
+	}
+
+	workspace := d.workspaces[workspaceId].workspace
+	workspace.Role = role
+	return workspace, nil
+}
+
+func (d *memoryData) CreateWorkspace(user string, workspace models.Workspace) error {
+	if _, ok := d.workspaces[workspace.UUID]; ok {
+		return ErrFailedToInsert
+	}
+
+	owner := models.WorkspaceMember{Role: models.RoleOwner, Created: workspace.Created}
+	workspace.Role = ""
+	d.workspaces[workspace.UUID] = memoryWorkspace{
+		workspace: workspace,
+		members:   map[string]models.WorkspaceMember{user: owner},
+	}
+	return nil
+}
+
+func (d *memoryData) UpdateWorkspace(user string, workspace models.Workspace) error {
+	if d.workspaceRole(user, workspace.UUID) != models.RoleOwner {
+		return ErrNoAccess
+	}
+
+	w := d.workspaces[workspace.UUID]
+	w.workspace.Name = workspace.Name
+	w.workspace.Modified = workspace.Modified
+	d.workspaces[workspace.UUID] = w
+	return nil
+}
+
+func (d *memoryData) RetrieveWorkspaceMembers(user, workspaceId string) ([]models.WorkspaceMember, error) {
+	id, err := uuid.Parse(workspaceId)
+	if err != nil || d.workspaceRole(user, id) == "" {
+		return []models.WorkspaceMember{}, nil
+	}
+
+	members := make([]models.WorkspaceMember, 0)
+	for userId, m := range d.workspaces[id].members {
+		m.WorkspaceUUID = id
+		m.UserUUID, _ = uuid.Parse(userId)
+		m.Email = d.users[m.UserUUID].Email
+		members = append(members, m)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if (a.Role == models.RoleOwner) != (b.Role == models.RoleOwner) {
+			return a.Role == models.RoleOwner
+		}
+		if a.Created != b.Created {
+			return a.Created < b.Created
+		}
+		return a.UserUUID.String() < b.UserUUID.String()
+	})
+	return members, nil
+}
+
+func (d *memoryData) AddWorkspaceMember(user string, member models.WorkspaceMember) error {
+	id := member.WorkspaceUUID
+	if d.workspaceRole(user, id) != models.RoleOwner || d.workspaceRole(member.UserUUID.String(), id) != "" {
+		return ErrNoAccess
+	}
+
+	d.workspaces[id].members[member.UserUUID.String()] = models.WorkspaceMember{Role: member.Role, Created: member.Created}
+	return nil
+}
+
+func (d *memoryData) UpdateWorkspaceMember(user string, member models.WorkspaceMember) error {
+	id, userId := member.WorkspaceUUID, member.UserUUID.String()
+	if d.workspaceRole(user, id) != models.RoleOwner || d.workspaceRole(userId, id) == "" {
+		return ErrNoAccess
+	}
+
+	if member.Role != models.RoleOwner && !d.keepsWorkspaceOwner(id, userId) {
+		return ErrNoAccess
+	}
+
+	m := d.workspaces[id].members[userId]
+	m.Role = member.Role
+	d.workspaces[id].members[userId] = m
+	return nil
+}
+
+func (d *memoryData) RemoveWorkspaceMember(user string, workspace, member uuid.UUID) error {
+	userId := member.String()
+	if d.workspaceRole(userId, workspace) == "" || !d.keepsWorkspaceOwner(workspace, userId) {
+		return ErrNoAccess
+	}
+
+	if userId != user && d.workspaceRole(user, workspace) != models.RoleOwner {
+		return ErrNoAccess
+	}
+
+	delete(d.workspaces[workspace].members, userId)
+	return nil
+}
+
+func (d *memoryData) GetHistorySince(user string, since uint64) ([]models.History, error) {
+	history := make([]models.History, 0)
+	for _, h := range d.history {
+		if h.user == user && h.history.Created >= int64(since) {
+			history = append(history, h.history)
+		}
+	}
+
+	// the history is kept in insertion order, which stands in for seq
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Created < history[j].Created
+	})
+	return history, nil
+}
+
+func (d *memoryData) GetHistory(user string, id uuid.UUID) (models.History, error) {
+	for _, h := range d.history {
+		if h.user == user && h.history.UUID == id {
+			return h.history, nil
+		}
+	}
+	return models.History{}, ErrFailedToLoadData
+}
+
+func (d *memoryData) CreateHistory(user string, history models.History) error {
+	for _, h := range d.history {
+		if h.history.UUID == history.UUID {
+			return ErrFailedToInsert
+		}
+	}
+
+	d.history = append(d.history, memoryHistory{user: user, history: history})
+	return nil
+}
+
+func (d *memoryData) CreateTombstone(user string, id uuid.UUID, ts int64) error {
+	if _, ok := d.tombstones[id]; !ok {
+		d.tombstones[id] = user
+	}
+	return nil
+}
+
+// collaborates reports whether the users are the same or share a list.
+func (d *memoryData) collaborates(user, other string) bool {
+	if user == other {
+		return true
+	}
+
+	for _, members := range d.members {
+		_, a := members[user]
+		_, b := members[other]
+		if a && b {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *memoryData) IsTombstoned(user string, id uuid.UUID) (bool, error) {
+	owner, ok := d.tombstones[id]
+	return ok && d.collaborates(user, owner), nil
+}
+
+func (d *memoryData) DeleteTombstone(user string, id uuid.UUID) error {
+	if owner, ok := d.tombstones[id]; ok && d.collaborates(user, owner) {
+		delete(d.tombstones, id)
+	}
+	return nil
+}
+
+func (d *memoryData) FindUserByEmail(email string) (models.User, error) {
+	for _, user := range d.users {
+		if sameEmail(user.Email, email) {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrFailedToLoadData
+}
+
+func (d *memoryData) FindUserByUUID(id string) (models.User, error) {
+	userId, err := uuid.Parse(id)
+	if err != nil {
+		return models.User{}, ErrFailedToLoadData
+	}
+
+	user, ok := d.users[userId]
+	if !ok {
+		return models.User{}, ErrFailedToLoadData
+	}
+	return user, nil
+}
+
+func (d *memoryData) CreateUser(user models.User) error {
+	if _, ok := d.users[user.UUID]; ok {
+		return ErrFailedToInsert
+	}
+
+	// mirror the unique index on lower(users.email)
+	if _, err := d.FindUserByEmail(user.Email); err == nil {
+		return ErrFailedToInsert
+	}
+
+	d.users[user.UUID] = user
+	return nil
+}
+
+func (d *memoryData) UpdateUser(user models.User) error {
+	if _, ok := d.users[user.UUID]; !ok {
+		return nil
+	}
+
+	d.users[user.UUID] = user
+	return nil
+}
+
+func (d *memoryData) CreateUserToken(kind string, token models.UserToken) error {
+	if _, ok := userTokenTables[kind]; !ok {
+		return ErrFailedToInsert
+	}
+
+	if _, ok := d.users[token.UserUUID]; !ok {
+		return ErrFailedToInsert
+	}
+
+	tokens, ok := d.tokens[kind]
+	if !ok {
+		tokens = make(map[string]models.UserToken)
+		d.tokens[kind] = tokens
+	}
+
+	if _, ok := tokens[string(token.Hash)]; ok {
+		return ErrFailedToInsert
+	}
+
+	tokens[string(token.Hash)] = token
+	return nil
+}
+
+func (d *memoryData) UseUserToken(kind string, hash []byte, now int64) (models.UserToken, error) {
+	token, ok := d.tokens[kind][string(hash)]
+	if !ok || token.Used != 0 || token.Expires <= now {
+		return models.UserToken{}, ErrFailedToLoadData
+	}
+
+	token.Used = now
+	d.tokens[kind][string(hash)] = token
+	return token, nil
+}
+
+func (d *memoryData) CreateRefreshToken(token models.RefreshToken) error {
+	if _, ok := d.refresh[string(token.Hash)]; ok {
+		return ErrFailedToInsert
+	}
+
+	d.refresh[string(token.Hash)] = token
+	return nil
+}
+
+func (d *memoryData) RetrieveRefreshToken(hash []byte) (models.RefreshToken, error) {
+	token, ok := d.refresh[string(hash)]
+	if !ok {
+		return models.RefreshToken{}, ErrFailedToLoadData
+	}
+	return token, nil
+}
+
+func (d *memoryData) UseRefreshToken(hash []byte, now int64) (models.RefreshToken, error) {
+	token, ok := d.refresh[string(hash)]
+	if !ok || token.Used != 0 || token.Revoked != 0 || token.Expires <= now {
+		return models.RefreshToken{}, ErrFailedToLoadData
+	}
+
+	token.Used = now
+	d.refresh[string(hash)] = token
+	return token, nil
+}
+
+func (d *memoryData) RevokeRefreshTokenFamily(family uuid.UUID, now int64) error {
+	for k, token := range d.refresh {
+		if token.Family == family && token.Revoked == 0 {
+			token.Revoked = now
+			d.refresh[k] = token
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) RevokeUserRefreshTokens(user string, now int64) error {
+	for k, token := range d.refresh {
+		if token.UserUUID.String() == user && token.Revoked == 0 {
+			token.Revoked = now
+			d.refresh[k] = token
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) RevokeAccessToken(jti string, expires int64) error {
+	if _, ok := d.revoked[jti]; !ok {
+		d.revoked[jti] = expires
+	}
+	return nil
+}
+
+func (d *memoryData) IsAccessTokenRevoked(jti string) (bool, error) {
+	_, ok := d.revoked[jti]
+	return ok, nil
+}
+
+func (d *memoryData) PurgeExpiredTokens(now int64) error {
+	for jti, expires := range d.revoked {
+		if expires <= now {
+			delete(d.revoked, jti)
+		}
+	}
+	for k, token := range d.refresh {
+		if token.Expires <= now {
+			delete(d.refresh, k)
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) CreateSigningKey(key models.SigningKey) error {
+	if _, ok := d.keys[key.Kid]; ok {
+		return ErrFailedToInsert
+	}
+
+	d.keys[key.Kid] = key
+	return nil
+}
+
+func (d *memoryData) RetrieveSigningKeys() ([]models.SigningKey, error) {
+	keys := make([]models.SigningKey, 0, len(d.keys))
+	for _, key := range d.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created > keys[j].Created
+	})
+	return keys, nil
+}
+
+func (d *memoryData) RetireSigningKey(kid string, now int64) error {
+	key, ok := d.keys[kid]
+	if !ok || key.Retired != 0 {
+		return ErrFailedToUpdateData
+	}
+
+	key.Retired = now
+	d.keys[kid] = key
+	return nil
+}
@@ -2,10 +2,13 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 
+	"ismacaulay/procrast-api/pkg/models"
+
 	_ "github.com/lib/pq"
 )
 
@@ -29,6 +32,7 @@ var (
 	ErrFailedToInsert           = errors.New("Failed to insert into database")
 	ErrPasswordMismatch         = errors.New("Failed to validate password")
 	ErrFailedToStartTransaction = errors.New("Failed to start transaction")
+	ErrNoAccess                 = errors.New("Not found or not accessible by user")
 )
 
 type PostgresConfig struct {
@@ -37,6 +41,9 @@ type PostgresConfig struct {
 	User     string
 	Password string
 	Name     string
+
+	// Schema sets the search_path, defaults to public
+	Schema string
 }
 
 type PostgresDatabase struct {
@@ -47,6 +54,9 @@ func NewPostgresDatabase(config PostgresConfig) *PostgresDatabase {
 	log.Println("Initializing postgres")
 	dbinfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		config.Host, config.Port, config.User, config.Password, config.Name)
+	if config.Schema != "" {
+		dbinfo += " search_path=" + config.Schema
+	}
 	conn, err := sql.Open("postgres", dbinfo)
 	if err != nil {
 		log.Fatalf("Unable to open db: %s", err)
@@ -75,3 +85,59 @@ func Transaction(conn DB, f func(Conn) error) error {
 
 	return tx.Commit()
 }
+
+// requireRows returns ErrNoAccess if a scoped write did not match anything.
+func requireRows(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println("Failed to read affected rows:", err)
+		return ErrFailedToUpdateData
+	}
+
+	if n == 0 {
+		return ErrNoAccess
+	}
+
+	return nil
+}
+
+func encodeClock(clock models.Clock) string {
+	if len(clock) == 0 {
+		return "{}"
+	}
+
+	encoded, _ := json.Marshal(clock)
+	return string(encoded)
+}
+
+// encodeWorkflow stores an empty workflow as an empty string, which is read
+// back as the default workflow.
+func encodeWorkflow(workflow []models.WorkflowState) string {
+	if len(workflow) == 0 {
+		return ""
+	}
+
+	encoded, _ := json.Marshal(workflow)
+	return string(encoded)
+}
+
+func decodeWorkflow(encoded string) []models.WorkflowState {
+	if encoded == "" {
+		return append([]models.WorkflowState(nil), models.DefaultWorkflow...)
+	}
+
+	var workflow []models.WorkflowState
+	if err := json.Unmarshal([]byte(encoded), &workflow); err != nil {
+		log.Println("Failed to decode workflow:", err)
+		return append([]models.WorkflowState(nil), models.DefaultWorkflow...)
+	}
+	return workflow
+}
+
+func decodeClock(encoded string) models.Clock {
+	var clock models.Clock
+	if err := json.Unmarshal([]byte(encoded), &clock); err != nil {
+		log.Println("Failed to decode clock:", err)
+	}
+	return clock
+}
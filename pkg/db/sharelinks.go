@@ -0,0 +1,98 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+const shareLinkColumns = `s.id, s.list_id, s.token, s.created_by, s.created, s.expires`
+
+func scanShareLink(row interface{ Scan(...interface{}) error }) (models.ShareLink, error) {
+	var link models.ShareLink
+	err := row.Scan(&link.UUID, &link.ListUUID, &link.Hash, &link.CreatedBy, &link.Created, &link.Expires)
+	return link, err
+}
+
+// CreateShareLink adds a share link to a live list owned by the user.
+func CreateShareLink(conn Conn, user string, link models.ShareLink) error {
+	sqlStatement := `
+		INSERT INTO list_share_links (id, list_id, token, created_by, created, expires)
+		SELECT $2, l.id, $4, $1, $5, $6
+		FROM lists l
+		WHERE l.id = $3 AND l.deleted = 0 AND ` + ownerOf("l.id", "$1")
+
+	res, err := conn.Exec(sqlStatement, user, link.UUID, link.ListUUID, link.Hash, link.Created, link.Expires)
+	if err != nil {
+		log.Println("Failed to create share link:", err)
+		return ErrFailedToInsert
+	}
+
+	return requireRows(res)
+}
+
+// RetrieveShareLinks returns the share links of a list owned by the user,
+// including those that have expired.
+func RetrieveShareLinks(conn Conn, user, listId string) ([]models.ShareLink, error) {
+	sqlStatement := `
+		SELECT ` + shareLinkColumns + `
+		FROM list_share_links s
+		WHERE s.list_id = $2 AND ` + ownerOf("s.list_id", "$1") + `
+		ORDER BY s.created ASC, s.id ASC`
+
+	rows, err := conn.Query(sqlStatement, user, listId)
+	if err != nil {
+		log.Printf("Failed to load share links of list %s\nError: %s\n", listId, err.Error())
+		return []models.ShareLink{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	links := make([]models.ShareLink, 0)
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.ShareLink{}, ErrFailedToScanRow
+		}
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// DeleteShareLink revokes a share link of a list owned by the user.
+func DeleteShareLink(conn Conn, user string, id uuid.UUID) error {
+	sqlStatement := `
+		DELETE FROM list_share_links s
+		WHERE s.id = $2 AND ` + ownerOf("s.list_id", "$1")
+
+	res, err := conn.Exec(sqlStatement, user, id)
+	if err != nil {
+		log.Println("Failed to delete share link:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return requireRows(res)
+}
+
+// RetrieveShareLink returns the share link with the token hash, as long as it
+// has not expired and whoever created it still owns the list.
+func RetrieveShareLink(conn Conn, hash []byte, now int64) (models.ShareLink, error) {
+	sqlStatement := `
+		SELECT ` + shareLinkColumns + `
+		FROM list_share_links s
+		WHERE s.token = $1 AND (s.expires = 0 OR s.expires > $2) AND ` + ownerOf("s.list_id", "s.created_by")
+
+	link, err := scanShareLink(conn.QueryRow(sqlStatement, hash, now))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to execute query: %s\n", err.Error())
+		}
+		return models.ShareLink{}, ErrFailedToLoadData
+	}
+
+	return link, nil
+}
@@ -0,0 +1,571 @@
+package db
+
+import (
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// ListStore and ItemStore are scoped to the user making the request. Lists
+// and items of lists the user is not a member of behave as if they do not
+// exist, writes to them, or by a member whose role can't make them, return
+// ErrNoAccess. So does creating a list or item with an id that is taken. Only
+// the owner can delete a list.
+type ListStore interface {
+	RetrieveAllLists(user string) ([]models.List, error)
+	RetrieveList(user, id string) (models.List, error)
+	CreateList(user string, list models.List) error
+	UpdateList(user string, list models.List) error
+	DeleteList(user string, list models.List) error
+}
+
+// ItemStore keeps subtasks in the same list as their parent, moving an item
+// to another list moves its subtasks with it. Items are only assigned to
+// members of their list, an item moved to a list its assignee is not a
+// member of is unassigned.
+type ItemStore interface {
+	RetrieveAllItems(user, listId string) ([]models.Item, error)
+	RetrieveItem(user, id string) (models.Item, error)
+	CreateItem(user string, item models.Item) error
+	UpdateItem(user string, item models.Item) error
+	DeleteItem(user string, item models.Item) error
+
+	// RetrieveScheduledItems returns items in any list that are due or start
+	// before the given time.
+	RetrieveScheduledItems(user string, before int64) ([]models.Item, error)
+
+	// RetrieveAssignedItems returns the items in any list that are assigned
+	// to the user.
+	RetrieveAssignedItems(user string) ([]models.Item, error)
+}
+
+// TrashStore manages soft deleted lists and items. The ListStore and
+// ItemStore methods only see what is not in the trash. Trashing a list or
+// item trashes what is in it, and restoring brings that back with it.
+type TrashStore interface {
+	TrashList(user string, list models.List, ts int64) error
+	RestoreList(user string, list models.List) error
+	RetrieveTrashedLists(user string) ([]models.List, error)
+	RetrieveTrashedList(user, id string) (models.List, error)
+
+	TrashItem(user string, item models.Item, ts int64) error
+	RestoreItem(user string, item models.Item) error
+	RetrieveTrashedItems(user string) ([]models.Item, error)
+	RetrieveTrashedItem(user, id string) (models.Item, error)
+
+	PurgeTrash(before int64) error
+}
+
+// TagStore is scoped to the user like ListStore. Tags are personal, they can
+// be put on live items of any list the user is a member of and only the user
+// sees them. Writes to anything else return ErrNoAccess.
+type TagStore interface {
+	RetrieveAllTags(user string) ([]models.Tag, error)
+	RetrieveTag(user, id string) (models.Tag, error)
+	CreateTag(user string, tag models.Tag) error
+	UpdateTag(user string, tag models.Tag) error
+	DeleteTag(user string, tag models.Tag) error
+
+	TagItem(user string, item, tag uuid.UUID, ts int64) error
+	UntagItem(user string, item, tag uuid.UUID) error
+	RetrieveItemTags(user, itemId string) ([]models.Tag, error)
+	RetrieveTaggedItems(user, tagId string) ([]models.Item, error)
+}
+
+// DependencyStore is scoped to the user like ItemStore. Dependencies can only
+// be added to live items the user can edit, on live items the user can see.
+// Writes to anything else return ErrNoAccess.
+type DependencyStore interface {
+	AddDependency(user string, item, blocker uuid.UUID, ts int64) error
+	RemoveDependency(user string, item, blocker uuid.UUID) error
+	RetrieveDependencies(user string) ([]models.Dependency, error)
+}
+
+// CommentStore is scoped to the user like ItemStore. Members of a list can
+// read the comments on its live items and those that can edit it can comment.
+// Only the author can change or delete a comment, deleting it deletes its
+// replies. Writes to anything else return ErrNoAccess.
+type CommentStore interface {
+	RetrieveComments(user, itemId, after string, limit int) ([]models.Comment, error)
+	RetrieveComment(user, id string) (models.Comment, error)
+	CreateComment(user string, comment models.Comment) error
+	UpdateComment(user string, comment models.Comment) error
+	DeleteComment(user string, comment models.Comment) error
+}
+
+// MemberStore manages who lists are shared with. Only the owner of a list can
+// change its members and invite others, members can leave a list themselves.
+// Invitations are sent to an email address and are read and accepted by the
+// user with that address. MoveList sets the user's own position of a list.
+// JoinWorkspaceLists and LeaveWorkspaceLists keep the members of a workspace's
+// lists in step with the workspace. Members that leave a list are unassigned
+// from its items.
+type MemberStore interface {
+	RetrieveMembers(user, listId string) ([]models.Member, error)
+	UpdateMember(user string, member models.Member) error
+	RemoveMember(user string, list, member uuid.UUID) error
+	MoveList(user string, list uuid.UUID, position string, ts int64) error
+
+	CreateInvitation(user string, invitation models.Invitation) error
+	RetrieveListInvitations(user, listId string) ([]models.Invitation, error)
+	RetrieveInvitations(email string) ([]models.Invitation, error)
+	RetrieveInvitation(email, id string) (models.Invitation, error)
+	DeleteInvitation(user, email string, id uuid.UUID) error
+	AcceptInvitation(user, email string, id uuid.UUID, position string, ts int64) error
+
+	JoinWorkspaceLists(workspace, user uuid.UUID, role, position string, ts int64) error
+	LeaveWorkspaceLists(workspace, user uuid.UUID) error
+}
+
+// ShareLinkStore manages the public links to lists, only owners of a list can
+// create, see and revoke its links. RetrieveShareLink finds a link by the hash
+// of its token for anyone, as long as the link can still be used.
+type ShareLinkStore interface {
+	CreateShareLink(user string, link models.ShareLink) error
+	RetrieveShareLinks(user, listId string) ([]models.ShareLink, error)
+	DeleteShareLink(user string, id uuid.UUID) error
+	RetrieveShareLink(hash []byte, now int64) (models.ShareLink, error)
+}
+
+// WorkspaceStore manages workspaces in the user database. Only owners can
+// rename a workspace and change its members, members can leave it themselves.
+// A workspace always keeps an owner, changes that would leave it without one
+// return ErrNoAccess.
+type WorkspaceStore interface {
+	RetrieveWorkspaces(user string) ([]models.Workspace, error)
+	RetrieveWorkspace(user, id string) (models.Workspace, error)
+	CreateWorkspace(user string, workspace models.Workspace) error
+	UpdateWorkspace(user string, workspace models.Workspace) error
+
+	RetrieveWorkspaceMembers(user, workspaceId string) ([]models.WorkspaceMember, error)
+	AddWorkspaceMember(user string, member models.WorkspaceMember) error
+	UpdateWorkspaceMember(user string, member models.WorkspaceMember) error
+	RemoveWorkspaceMember(user string, workspace, member uuid.UUID) error
+}
+
+type HistoryStore interface {
+	GetHistorySince(user string, since uint64) ([]models.History, error)
+	GetHistory(user string, id uuid.UUID) (models.History, error)
+	CreateHistory(user string, history models.History) error
+}
+
+// Tombstones are shared by the users that share a list, any of them can
+// delete its lists and items.
+type TombstoneStore interface {
+	CreateTombstone(user string, id uuid.UUID, ts int64) error
+	IsTombstoned(user string, id uuid.UUID) (bool, error)
+	DeleteTombstone(user string, id uuid.UUID) error
+}
+
+type UserStore interface {
+	FindUserByEmail(email string) (models.User, error)
+	FindUserByUUID(id string) (models.User, error)
+	CreateUser(user models.User) error
+	UpdateUser(user models.User) error
+
+	CreateUserToken(kind string, token models.UserToken) error
+	UseUserToken(kind string, hash []byte, now int64) (models.UserToken, error)
+}
+
+type TokenStore interface {
+	CreateRefreshToken(token models.RefreshToken) error
+	RetrieveRefreshToken(hash []byte) (models.RefreshToken, error)
+	UseRefreshToken(hash []byte, now int64) (models.RefreshToken, error)
+	RevokeRefreshTokenFamily(family uuid.UUID, now int64) error
+	RevokeUserRefreshTokens(user string, now int64) error
+
+	RevokeAccessToken(jti string, expires int64) error
+	IsAccessTokenRevoked(jti string) (bool, error)
+
+	PurgeExpiredTokens(now int64) error
+}
+
+type KeyStore interface {
+	CreateSigningKey(key models.SigningKey) error
+	RetrieveSigningKeys() ([]models.SigningKey, error)
+	RetireSigningKey(kid string, now int64) error
+}
+
+// Store is the storage backend used by the api. Transaction runs f against a
+// Store whose changes are only committed if f returns nil.
+type Store interface {
+	ListStore
+	ItemStore
+	TrashStore
+	TagStore
+	DependencyStore
+	CommentStore
+	MemberStore
+	ShareLinkStore
+	WorkspaceStore
+	HistoryStore
+	TombstoneStore
+	UserStore
+	TokenStore
+	KeyStore
+
+	Transaction(f func(Store) error) error
+}
+
+// PostgresStore implements Store on top of the procrast and user databases.
+// Only the procrast database takes part in transactions.
+type PostgresStore struct {
+	db     DB
+	conn   Conn
+	userDb Conn
+}
+
+func NewPostgresStore(db DB, userDb Conn) *PostgresStore {
+	return &PostgresStore{db: db, conn: db, userDb: userDb}
+}
+
+func (s *PostgresStore) Transaction(f func(Store) error) error {
+	if s.db == nil {
+		// already inside a transaction
+		return f(s)
+	}
+
+	return Transaction(s.db, func(tx Conn) error {
+		return f(&PostgresStore{conn: tx, userDb: s.userDb})
+	})
+}
+
+func (s *PostgresStore) RetrieveAllLists(user string) ([]models.List, error) {
+	return RetrieveAllLists(s.conn, user)
+}
+
+func (s *PostgresStore) RetrieveList(user, id string) (models.List, error) {
+	return RetrieveList(s.conn, user, id)
+}
+
+func (s *PostgresStore) CreateList(user string, list models.List) error {
+	return CreateList(s.conn, user, list)
+}
+
+func (s *PostgresStore) UpdateList(user string, list models.List) error {
+	return UpdateList(s.conn, user, list)
+}
+
+func (s *PostgresStore) DeleteList(user string, list models.List) error {
+	return DeleteList(s.conn, user, list)
+}
+
+func (s *PostgresStore) RetrieveAllItems(user, listId string) ([]models.Item, error) {
+	return RetrieveAllItems(s.conn, user, listId)
+}
+
+func (s *PostgresStore) RetrieveItem(user, id string) (models.Item, error) {
+	return RetrieveItem(s.conn, user, id)
+}
+
+func (s *PostgresStore) CreateItem(user string, item models.Item) error {
+	return CreateItem(s.conn, user, item)
+}
+
+func (s *PostgresStore) UpdateItem(user string, item models.Item) error {
+	return UpdateItem(s.conn, user, item)
+}
+
+func (s *PostgresStore) DeleteItem(user string, item models.Item) error {
+	return DeleteItem(s.conn, user, item)
+}
+
+func (s *PostgresStore) RetrieveScheduledItems(user string, before int64) ([]models.Item, error) {
+	return RetrieveScheduledItems(s.conn, user, before)
+}
+
+func (s *PostgresStore) RetrieveAssignedItems(user string) ([]models.Item, error) {
+	return RetrieveAssignedItems(s.conn, user)
+}
+
+func (s *PostgresStore) TrashList(user string, list models.List, ts int64) error {
+	return TrashList(s.conn, user, list, ts)
+}
+
+func (s *PostgresStore) RestoreList(user string, list models.List) error {
+	return RestoreList(s.conn, user, list)
+}
+
+func (s *PostgresStore) RetrieveTrashedLists(user string) ([]models.List, error) {
+	return RetrieveTrashedLists(s.conn, user)
+}
+
+func (s *PostgresStore) RetrieveTrashedList(user, id string) (models.List, error) {
+	return RetrieveTrashedList(s.conn, user, id)
+}
+
+func (s *PostgresStore) TrashItem(user string, item models.Item, ts int64) error {
+	return TrashItem(s.conn, user, item, ts)
+}
+
+func (s *PostgresStore) RestoreItem(user string, item models.Item) error {
+	return RestoreItem(s.conn, user, item)
+}
+
+func (s *PostgresStore) RetrieveTrashedItems(user string) ([]models.Item, error) {
+	return RetrieveTrashedItems(s.conn, user)
+}
+
+func (s *PostgresStore) RetrieveTrashedItem(user, id string) (models.Item, error) {
+	return RetrieveTrashedItem(s.conn, user, id)
+}
+
+func (s *PostgresStore) PurgeTrash(before int64) error {
+	return PurgeTrash(s.conn, before)
+}
+
+func (s *PostgresStore) RetrieveAllTags(user string) ([]models.Tag, error) {
+	return RetrieveAllTags(s.conn, user)
+}
+
+func (s *PostgresStore) RetrieveTag(user, id string) (models.Tag, error) {
+	return RetrieveTag(s.conn, user, id)
+}
+
+func (s *PostgresStore) CreateTag(user string, tag models.Tag) error {
+	return CreateTag(s.conn, user, tag)
+}
+
+func (s *PostgresStore) UpdateTag(user string, tag models.Tag) error {
+	return UpdateTag(s.conn, user, tag)
+}
+
+func (s *PostgresStore) DeleteTag(user string, tag models.Tag) error {
+	return DeleteTag(s.conn, user, tag)
+}
+
+func (s *PostgresStore) TagItem(user string, item, tag uuid.UUID, ts int64) error {
+	return TagItem(s.conn, user, item, tag, ts)
+}
+
+func (s *PostgresStore) UntagItem(user string, item, tag uuid.UUID) error {
+	return UntagItem(s.conn, user, item, tag)
+}
+
+func (s *PostgresStore) RetrieveItemTags(user, itemId string) ([]models.Tag, error) {
+	return RetrieveItemTags(s.conn, user, itemId)
+}
+
+func (s *PostgresStore) RetrieveTaggedItems(user, tagId string) ([]models.Item, error) {
+	return RetrieveTaggedItems(s.conn, user, tagId)
+}
+
+func (s *PostgresStore) AddDependency(user string, item, blocker uuid.UUID, ts int64) error {
+	return AddDependency(s.conn, user, item, blocker, ts)
+}
+
+func (s *PostgresStore) RemoveDependency(user string, item, blocker uuid.UUID) error {
+	return RemoveDependency(s.conn, user, item, blocker)
+}
+
+func (s *PostgresStore) RetrieveDependencies(user string) ([]models.Dependency, error) {
+	return RetrieveDependencies(s.conn, user)
+}
+
+func (s *PostgresStore) RetrieveComments(user, itemId, after string, limit int) ([]models.Comment, error) {
+	return RetrieveComments(s.conn, user, itemId, after, limit)
+}
+
+func (s *PostgresStore) RetrieveComment(user, id string) (models.Comment, error) {
+	return RetrieveComment(s.conn, user, id)
+}
+
+func (s *PostgresStore) CreateComment(user string, comment models.Comment) error {
+	return CreateComment(s.conn, user, comment)
+}
+
+func (s *PostgresStore) UpdateComment(user string, comment models.Comment) error {
+	return UpdateComment(s.conn, user, comment)
+}
+
+func (s *PostgresStore) DeleteComment(user string, comment models.Comment) error {
+	return DeleteComment(s.conn, user, comment)
+}
+
+func (s *PostgresStore) RetrieveMembers(user, listId string) ([]models.Member, error) {
+	return RetrieveMembers(s.conn, user, listId)
+}
+
+func (s *PostgresStore) UpdateMember(user string, member models.Member) error {
+	return UpdateMember(s.conn, user, member)
+}
+
+func (s *PostgresStore) RemoveMember(user string, list, member uuid.UUID) error {
+	return RemoveMember(s.conn, user, list, member)
+}
+
+func (s *PostgresStore) MoveList(user string, list uuid.UUID, position string, ts int64) error {
+	return MoveList(s.conn, user, list, position, ts)
+}
+
+func (s *PostgresStore) CreateInvitation(user string, invitation models.Invitation) error {
+	return CreateInvitation(s.conn, user, invitation)
+}
+
+func (s *PostgresStore) RetrieveListInvitations(user, listId string) ([]models.Invitation, error) {
+	return RetrieveListInvitations(s.conn, user, listId)
+}
+
+func (s *PostgresStore) RetrieveInvitations(email string) ([]models.Invitation, error) {
+	return RetrieveInvitations(s.conn, email)
+}
+
+func (s *PostgresStore) RetrieveInvitation(email, id string) (models.Invitation, error) {
+	return RetrieveInvitation(s.conn, email, id)
+}
+
+func (s *PostgresStore) DeleteInvitation(user, email string, id uuid.UUID) error {
+	return DeleteInvitation(s.conn, user, email, id)
+}
+
+func (s *PostgresStore) AcceptInvitation(user, email string, id uuid.UUID, position string, ts int64) error {
+	return AcceptInvitation(s.conn, user, email, id, position, ts)
+}
+
+func (s *PostgresStore) JoinWorkspaceLists(workspace, user uuid.UUID, role, position string, ts int64) error {
+	return JoinWorkspaceLists(s.conn, workspace, user, role, position, ts)
+}
+
+func (s *PostgresStore) LeaveWorkspaceLists(workspace, user uuid.UUID) error {
+	return LeaveWorkspaceLists(s.conn, workspace, user)
+}
+
+func (s *PostgresStore) CreateShareLink(user string, link models.ShareLink) error {
+	return CreateShareLink(s.conn, user, link)
+}
+
+func (s *PostgresStore) RetrieveShareLinks(user, listId string) ([]models.ShareLink, error) {
+	return RetrieveShareLinks(s.conn, user, listId)
+}
+
+func (s *PostgresStore) DeleteShareLink(user string, id uuid.UUID) error {
+	return DeleteShareLink(s.conn, user, id)
+}
+
+func (s *PostgresStore) RetrieveShareLink(hash []byte, now int64) (models.ShareLink, error) {
+	return RetrieveShareLink(s.conn, hash, now)
+}
+
+func (s *PostgresStore) RetrieveWorkspaces(user string) ([]models.Workspace, error) {
+	return RetrieveWorkspaces(s.userDb, user)
+}
+
+func (s *PostgresStore) RetrieveWorkspace(user, id string) (models.Workspace, error) {
+	return RetrieveWorkspace(s.userDb, user, id)
+}
+
+func (s *PostgresStore) CreateWorkspace(user string, workspace models.Workspace) error {
+	return CreateWorkspace(s.userDb, user, workspace)
+}
+
+func (s *PostgresStore) UpdateWorkspace(user string, workspace models.Workspace) error {
+	return UpdateWorkspace(s.userDb, user, workspace)
+}
+
+func (s *PostgresStore) RetrieveWorkspaceMembers(user, workspaceId string) ([]models.WorkspaceMember, error) {
+	return RetrieveWorkspaceMembers(s.userDb, user, workspaceId)
+}
+
+func (s *PostgresStore) AddWorkspaceMember(user string, member models.WorkspaceMember) error {
+	return AddWorkspaceMember(s.userDb, user, member)
+}
+
+func (s *PostgresStore) UpdateWorkspaceMember(user string, member models.WorkspaceMember) error {
+	return UpdateWorkspaceMember(s.userDb, user, member)
+}
+
+func (s *PostgresStore) RemoveWorkspaceMember(user string, workspace, member uuid.UUID) error {
+	return RemoveWorkspaceMember(s.userDb, user, workspace, member)
+}
+
+func (s *PostgresStore) GetHistorySince(user string, since uint64) ([]models.History, error) {
+	return GetHistorySince(s.conn, user, since)
+}
+
+func (s *PostgresStore) GetHistory(user string, id uuid.UUID) (models.History, error) {
+	return GetHistory(s.conn, user, id)
+}
+
+func (s *PostgresStore) CreateHistory(user string, history models.History) error {
+	return CreateHistory(s.conn, user, history)
+}
+
+func (s *PostgresStore) CreateTombstone(user string, id uuid.UUID, ts int64) error {
+	return CreateTombstone(s.conn, user, id, ts)
+}
+
+func (s *PostgresStore) IsTombstoned(user string, id uuid.UUID) (bool, error) {
+	return IsTombstoned(s.conn, user, id)
+}
+
+func (s *PostgresStore) DeleteTombstone(user string, id uuid.UUID) error {
+	return DeleteTombstone(s.conn, user, id)
+}
+
+func (s *PostgresStore) FindUserByEmail(email string) (models.User, error) {
+	return FindUserByEmail(s.userDb, email)
+}
+
+func (s *PostgresStore) FindUserByUUID(id string) (models.User, error) {
+	return FindUserByUUID(s.userDb, id)
+}
+
+func (s *PostgresStore) CreateUser(user models.User) error {
+	return CreateUser(s.userDb, user)
+}
+
+func (s *PostgresStore) UpdateUser(user models.User) error {
+	return UpdateUser(s.userDb, user)
+}
+
+func (s *PostgresStore) CreateUserToken(kind string, token models.UserToken) error {
+	return CreateUserToken(s.userDb, kind, token)
+}
+
+func (s *PostgresStore) UseUserToken(kind string, hash []byte, now int64) (models.UserToken, error) {
+	return UseUserToken(s.userDb, kind, hash, now)
+}
+
+func (s *PostgresStore) CreateRefreshToken(token models.RefreshToken) error {
+	return CreateRefreshToken(s.userDb, token)
+}
+
+func (s *PostgresStore) RetrieveRefreshToken(hash []byte) (models.RefreshToken, error) {
+	return RetrieveRefreshToken(s.userDb, hash)
+}
+
+func (s *PostgresStore) UseRefreshToken(hash []byte, now int64) (models.RefreshToken, error) {
+	return UseRefreshToken(s.userDb, hash, now)
+}
+
+func (s *PostgresStore) RevokeRefreshTokenFamily(family uuid.UUID, now int64) error {
+	return RevokeRefreshTokenFamily(s.userDb, family, now)
+}
+
+func (s *PostgresStore) RevokeUserRefreshTokens(user string, now int64) error {
+	return RevokeUserRefreshTokens(s.userDb, user, now)
+}
+
+func (s *PostgresStore) RevokeAccessToken(jti string, expires int64) error {
+	return RevokeAccessToken(s.userDb, jti, expires)
+}
+
+func (s *PostgresStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	return IsAccessTokenRevoked(s.userDb, jti)
+}
+
+func (s *PostgresStore) PurgeExpiredTokens(now int64) error {
+	return PurgeExpiredTokens(s.userDb, now)
+}
+
+func (s *PostgresStore) CreateSigningKey(key models.SigningKey) error {
+	return CreateSigningKey(s.userDb, key)
+}
+
+func (s *PostgresStore) RetrieveSigningKeys() ([]models.SigningKey, error) {
+	return RetrieveSigningKeys(s.userDb)
+}
+
+func (s *PostgresStore) RetireSigningKey(kid string, now int64) error {
+	return RetireSigningKey(s.userDb, kid, now)
+}
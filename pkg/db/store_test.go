@@ -0,0 +1,271 @@
+package db_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+	"ismacaulay/procrast-api/pkg/migrate"
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// The same tests run against both stores so the MemoryStore keeps behaving
+// like the PostgresStore. The postgres ones only run when PROCRAST_TEST_POSTGRES
+// is set, using the POSTGRES_* variables of env.sh.
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) db.Store {
+		return db.NewMemoryStore()
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	if os.Getenv("PROCRAST_TEST_POSTGRES") == "" {
+		t.Skip("PROCRAST_TEST_POSTGRES is not set")
+	}
+
+	testStore(t, newPostgresStore)
+}
+
+// newPostgresStore migrates the procrast and user databases into schemas of
+// their own, which are dropped when the test finishes.
+func newPostgresStore(t *testing.T) db.Store {
+	config := db.PostgresConfig{
+		Host:     os.Getenv("POSTGRES_HOST"),
+		Port:     os.Getenv("POSTGRES_PORT"),
+		User:     os.Getenv("POSTGRES_USER"),
+		Password: os.Getenv("POSTGRES_PASSWORD"),
+		Name:     os.Getenv("POSTGRES_DB"),
+	}
+	admin := db.NewPostgresDatabase(config)
+	t.Cleanup(func() { admin.Conn.Close() })
+
+	open := func(name string, migrations []migrate.Migration) db.DB {
+		schema := fmt.Sprintf("test_%s_%d", name, time.Now().UnixNano())
+		if _, err := admin.Conn.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("Failed to create schema: %s", err)
+		}
+		t.Cleanup(func() { admin.Conn.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+		config.Schema = schema
+		conn := db.NewPostgresDatabase(config).Conn
+		t.Cleanup(func() { conn.Close() })
+
+		migrator, err := migrate.New(conn, migrations)
+		if err != nil {
+			t.Fatalf("Invalid migrations: %s", err)
+		}
+		if err := migrator.Up(migrator.Latest()); err != nil {
+			t.Fatalf("Failed to migrate: %s", err)
+		}
+		return conn
+	}
+
+	data := open("procrast", migrate.ProcrastMigrations)
+	users := open("user", migrate.UserMigrations)
+	return db.NewPostgresStore(data, users)
+}
+
+func testStore(t *testing.T, newStore func(t *testing.T) db.Store) {
+	t.Run("access", func(t *testing.T) { testAccess(t, newStore(t)) })
+	t.Run("rollback", func(t *testing.T) { testRollback(t, newStore(t)) })
+	t.Run("emails", func(t *testing.T) { testEmails(t, newStore(t)) })
+	t.Run("history order", func(t *testing.T) { testHistoryOrder(t, newStore(t)) })
+}
+
+var (
+	owner    = uuid.MustParse("00000000-0000-0000-0000-00000000000a").String()
+	viewer   = uuid.MustParse("00000000-0000-0000-0000-00000000000b").String()
+	stranger = uuid.MustParse("00000000-0000-0000-0000-00000000000c").String()
+)
+
+func newList(t *testing.T, store db.Store, user, title string) models.List {
+	list := models.List{UUID: uuid.New(), Title: title, Created: 1, Modified: 1, Position: "m"}
+	if err := store.CreateList(user, list); err != nil {
+		t.Fatalf("Failed to create list: %s", err)
+	}
+	return list
+}
+
+func newItem(t *testing.T, store db.Store, user string, list models.List, title string) models.Item {
+	item := models.Item{UUID: uuid.New(), Title: title, Created: 1, Modified: 1, ListUUID: list.UUID, Position: "m"}
+	if err := store.CreateItem(user, item); err != nil {
+		t.Fatalf("Failed to create item: %s", err)
+	}
+	return item
+}
+
+func share(t *testing.T, store db.Store, list models.List, user, role string) {
+	email := user + "@example.com"
+	inv := models.Invitation{UUID: uuid.New(), ListUUID: list.UUID, Email: email, Role: role, Created: 1}
+	if err := store.CreateInvitation(owner, inv); err != nil {
+		t.Fatalf("Failed to invite: %s", err)
+	}
+	if err := store.AcceptInvitation(user, email, inv.UUID, "m", 1); err != nil {
+		t.Fatalf("Failed to accept invitation: %s", err)
+	}
+}
+
+func expectNoAccess(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != db.ErrNoAccess {
+		t.Errorf("%s: expected ErrNoAccess, got %v", what, err)
+	}
+}
+
+func testAccess(t *testing.T, store db.Store) {
+	list := newList(t, store, owner, "list")
+	item := newItem(t, store, owner, list, "item")
+	share(t, store, list, viewer, models.RoleViewer)
+
+	if _, err := store.RetrieveList(stranger, list.UUID.String()); err == nil {
+		t.Error("stranger retrieved the list")
+	}
+	if _, err := store.RetrieveItem(stranger, item.UUID.String()); err == nil {
+		t.Error("stranger retrieved the item")
+	}
+	if items, _ := store.RetrieveAllItems(stranger, list.UUID.String()); len(items) != 0 {
+		t.Error("stranger retrieved the items")
+	}
+	if _, err := store.RetrieveItem(viewer, item.UUID.String()); err != nil {
+		t.Errorf("viewer could not retrieve the item: %s", err)
+	}
+
+	for _, user := range []string{stranger, viewer} {
+		changed := list
+		changed.Title = "changed"
+		expectNoAccess(t, user+" UpdateList", store.UpdateList(user, changed))
+		expectNoAccess(t, user+" DeleteList", store.DeleteList(user, list))
+		expectNoAccess(t, user+" TrashList", store.TrashList(user, list, 2))
+
+		changedItem := item
+		changedItem.Title = "changed"
+		expectNoAccess(t, user+" UpdateItem", store.UpdateItem(user, changedItem))
+		expectNoAccess(t, user+" DeleteItem", store.DeleteItem(user, item))
+		expectNoAccess(t, user+" TrashItem", store.TrashItem(user, item, 2))
+
+		other := models.Item{UUID: uuid.New(), Title: "other", ListUUID: list.UUID, Position: "n"}
+		expectNoAccess(t, user+" CreateItem", store.CreateItem(user, other))
+	}
+
+	// moving an item into a list the user can't edit
+	own := newList(t, store, stranger, "own")
+	moved := newItem(t, store, stranger, own, "moved")
+	moved.ListUUID = list.UUID
+	expectNoAccess(t, "stranger moving an item in", store.UpdateItem(stranger, moved))
+
+	// nor out of one
+	stolen := item
+	stolen.ListUUID = own.UUID
+	expectNoAccess(t, "stranger moving an item out", store.UpdateItem(stranger, stolen))
+
+	stored, err := store.RetrieveList(owner, list.UUID.String())
+	if err != nil || stored.Title != "list" {
+		t.Errorf("list was changed: %+v %v", stored, err)
+	}
+
+	items, _ := store.RetrieveAllItems(owner, list.UUID.String())
+	if len(items) != 1 || items[0].UUID != item.UUID || items[0].Title != "item" {
+		t.Errorf("items were changed: %+v", items)
+	}
+}
+
+func testRollback(t *testing.T, store db.Store) {
+	errRollback := errors.New("rollback")
+	history := func(command string) models.History {
+		return models.History{UUID: uuid.New(), Command: command, State: []byte("{}"), Timestamp: 1, Created: 1}
+	}
+
+	kept := newList(t, store, owner, "kept")
+	var dropped models.List
+	err := store.Transaction(func(tx db.Store) error {
+		dropped = newList(t, tx, owner, "dropped")
+		newItem(t, tx, owner, kept, "dropped")
+
+		kept.Title = "changed"
+		if err := tx.UpdateList(owner, kept); err != nil {
+			return err
+		}
+
+		if err := tx.CreateHistory(owner, history("DROPPED")); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("expected the transaction's error, got %v", err)
+	}
+
+	if _, err := store.RetrieveList(owner, dropped.UUID.String()); err == nil {
+		t.Error("list created in a rolled back transaction exists")
+	}
+	if stored, _ := store.RetrieveList(owner, kept.UUID.String()); stored.Title != "kept" {
+		t.Errorf("update in a rolled back transaction was kept: %q", stored.Title)
+	}
+	if items, _ := store.RetrieveAllItems(owner, kept.UUID.String()); len(items) != 0 {
+		t.Errorf("item created in a rolled back transaction exists: %+v", items)
+	}
+
+	err = store.Transaction(func(tx db.Store) error {
+		return tx.CreateHistory(owner, history("KEPT"))
+	})
+	if err != nil {
+		t.Fatalf("Failed to commit: %s", err)
+	}
+
+	entries, _ := store.GetHistorySince(owner, 0)
+	if len(entries) != 1 || entries[0].Command != "KEPT" {
+		t.Errorf("expected only the committed history, got %+v", entries)
+	}
+}
+
+func testEmails(t *testing.T, store db.Store) {
+	user := models.User{UUID: uuid.New(), Email: "user@example.com", Created: 1, Modified: 1}
+	if err := store.CreateUser(user); err != nil {
+		t.Fatalf("Failed to create user: %s", err)
+	}
+
+	other := models.User{UUID: uuid.New(), Email: "User@Example.com", Created: 2, Modified: 2}
+	if err := store.CreateUser(other); err == nil {
+		t.Error("created a second account for the same address in another case")
+	}
+
+	found, err := store.FindUserByEmail("USER@example.com")
+	if err != nil || found.UUID != user.UUID {
+		t.Errorf("expected %s, got %+v %v", user.UUID, found, err)
+	}
+}
+
+// testHistoryOrder submits batches whose entries are all created in the same
+// second, which have to come back in the order they were inserted and not by
+// id or timestamp.
+func testHistoryOrder(t *testing.T, store db.Store) {
+	var want []uuid.UUID
+	for created := int64(1); created <= 2; created++ {
+		for i := 0; i < 50; i++ {
+			entry := models.History{UUID: uuid.New(), Command: "LIST UPDATE", State: []byte("{}"), Timestamp: int64(100 - i), Created: created}
+			if err := store.CreateHistory(owner, entry); err != nil {
+				t.Fatalf("Failed to create history: %s", err)
+			}
+			want = append(want, entry.UUID)
+		}
+	}
+
+	entries, err := store.GetHistorySince(owner, 0)
+	if err != nil {
+		t.Fatalf("Failed to get history: %s", err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.UUID != want[i] {
+			t.Fatalf("entry %d is out of order", i)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+const tagColumns = `t.id, t.name, t.color, t.created, t.modified, t.clock`
+
+func scanTag(row interface{ Scan(...interface{}) error }) (models.Tag, error) {
+	var tag models.Tag
+	var clock string
+	err := row.Scan(&tag.UUID, &tag.Name, &tag.Color, &tag.Created, &tag.Modified, &clock)
+	if err != nil {
+		return models.Tag{}, err
+	}
+
+	tag.Clock = decodeClock(clock)
+	return tag, nil
+}
+
+func scanTags(rows *sql.Rows) ([]models.Tag, error) {
+	defer rows.Close()
+
+	tags := make([]models.Tag, 0)
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Tag{}, ErrFailedToScanRow
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+func RetrieveAllTags(conn Conn, user string) ([]models.Tag, error) {
+	sqlStatement := `
+		SELECT ` + tagColumns + ` FROM tags t
+		WHERE t.user_id = $1
+		ORDER BY t.name ASC, t.id ASC`
+
+	rows, err := conn.Query(sqlStatement, user)
+	if err != nil {
+		log.Printf("Failed to load tags for user %s\nError: %s\n", user, err.Error())
+		return []models.Tag{}, ErrFailedToLoadData
+	}
+
+	return scanTags(rows)
+}
+
+func RetrieveTag(conn Conn, user, id string) (models.Tag, error) {
+	sqlStatement := `SELECT ` + tagColumns + ` FROM tags t WHERE t.user_id = $1 AND t.id = $2`
+
+	tag, err := scanTag(conn.QueryRow(sqlStatement, user, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to execute query: %s\n", err.Error())
+		}
+		return models.Tag{}, ErrFailedToLoadData
+	}
+
+	return tag, nil
+}
+
+func CreateTag(conn Conn, user string, tag models.Tag) error {
+	sqlStatement := `
+		INSERT INTO tags (id, user_id, name, color, created, modified, clock)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := conn.Exec(sqlStatement, tag.UUID, user, tag.Name, tag.Color, tag.Created, tag.Modified, encodeClock(tag.Clock))
+	if err != nil {
+		log.Println("Failed to create tag:", err)
+		return ErrFailedToInsert
+	}
+
+	return nil
+}
+
+func UpdateTag(conn Conn, user string, tag models.Tag) error {
+	sqlStatement := `
+		UPDATE tags
+		SET modified = $3, name = $4, color = $5, clock = $6
+		WHERE user_id = $1 AND id = $2`
+
+	res, err := conn.Exec(sqlStatement, user, tag.UUID, tag.Modified, tag.Name, tag.Color, encodeClock(tag.Clock))
+	if err != nil {
+		log.Println("Failed to update tag:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return requireRows(res)
+}
+
+// DeleteTag deletes the tag, removing it from every item it is on.
+func DeleteTag(conn Conn, user string, tag models.Tag) error {
+	sqlStatement := `DELETE FROM tags WHERE user_id = $1 AND id = $2`
+
+	res, err := conn.Exec(sqlStatement, user, tag.UUID)
+	if err != nil {
+		log.Println("Failed to delete tag:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return requireRows(res)
+}
+
+// TagItem puts the tag on a live item. The tag has to belong to the user, the
+// item to a list the user is a member of, and the item must not already have
+// the tag. Tags are personal, members of a shared list only see their own.
+func TagItem(conn Conn, user string, item, tag uuid.UUID, ts int64) error {
+	sqlStatement := `
+		INSERT INTO item_tags (item_id, tag_id, created)
+		SELECT i.id, t.id, $4
+		FROM items i
+		INNER JOIN lists l ON (i.list_id = l.id)
+		INNER JOIN tags t ON (t.user_id = $1)
+		WHERE ` + memberOf("l.id", "$1") + ` AND i.id = $2 AND t.id = $3 AND l.deleted = 0 AND i.deleted = 0
+		ON CONFLICT DO NOTHING`
+
+	res, err := conn.Exec(sqlStatement, user, item, tag, ts)
+	if err != nil {
+		log.Println("Failed to tag item:", err)
+		return ErrFailedToInsert
+	}
+
+	return requireRows(res)
+}
+
+func UntagItem(conn Conn, user string, item, tag uuid.UUID) error {
+	sqlStatement := `
+		DELETE FROM item_tags
+		USING tags t
+		WHERE item_tags.tag_id = t.id AND t.user_id = $1 AND item_tags.item_id = $2 AND t.id = $3`
+
+	res, err := conn.Exec(sqlStatement, user, item, tag)
+	if err != nil {
+		log.Println("Failed to untag item:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return requireRows(res)
+}
+
+func RetrieveItemTags(conn Conn, user, itemId string) ([]models.Tag, error) {
+	sqlStatement := `
+		SELECT ` + tagColumns + `
+		FROM tags t
+		INNER JOIN item_tags it ON (it.tag_id = t.id)
+		WHERE t.user_id = $1 AND it.item_id = $2
+		ORDER BY t.name ASC, t.id ASC`
+
+	rows, err := conn.Query(sqlStatement, user, itemId)
+	if err != nil {
+		log.Printf("Failed to load tags for item %s\nError: %s\n", itemId, err.Error())
+		return []models.Tag{}, ErrFailedToLoadData
+	}
+
+	return scanTags(rows)
+}
+
+// RetrieveTaggedItems returns the live items with the tag across all of the
+// lists the user is a member of.
+func RetrieveTaggedItems(conn Conn, user, tagId string) ([]models.Item, error) {
+	sqlStatement := `
+		SELECT ` + itemColumns + `
+		FROM items i
+		INNER JOIN lists l ON (i.list_id = l.id)
+		INNER JOIN item_tags it ON (it.item_id = i.id)
+		WHERE ` + memberOf("l.id", "$1") + ` AND it.tag_id = $2 AND l.deleted = 0 AND i.deleted = 0
+		ORDER BY i.created ASC`
+
+	rows, err := conn.Query(sqlStatement, user, tagId)
+	if err != nil {
+		log.Printf("Failed to load items tagged %s\nError: %s\n", tagId, err.Error())
+		return []models.Item{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	items := make([]models.Item, 0)
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Item{}, ErrFailedToScanRow
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+func CreateRefreshToken(conn Conn, token models.RefreshToken) error {
+	sqlStatement := `
+		INSERT INTO refresh_tokens (token, family, user_id, workspace_id, created, expires)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := conn.Exec(sqlStatement, token.Hash, token.Family, token.UserUUID, token.Workspace, token.Created, token.Expires)
+	if err != nil {
+		log.Println("Failed to create refresh token:", err)
+		return ErrFailedToInsert
+	}
+
+	return nil
+}
+
+func RetrieveRefreshToken(conn Conn, hash []byte) (models.RefreshToken, error) {
+	sqlStatement := `
+		SELECT family, user_id, workspace_id, created, expires, COALESCE(used, 0), COALESCE(revoked, 0)
+		FROM refresh_tokens
+		WHERE token = $1`
+
+	var family, userId, workspace uuid.UUID
+	var created, expires, used, revoked int64
+	err := conn.QueryRow(sqlStatement, hash).Scan(&family, &userId, &workspace, &created, &expires, &used, &revoked)
+	if err != nil {
+		return models.RefreshToken{}, ErrFailedToLoadData
+	}
+
+	token := models.RefreshToken{
+		Hash:      hash,
+		Family:    family,
+		UserUUID:  userId,
+		Workspace: workspace,
+		Created:   created,
+		Expires:   expires,
+		Used:      used,
+		Revoked:   revoked,
+	}
+	return token, nil
+}
+
+// UseRefreshToken marks the token as used and returns it. Tokens that are
+// expired, revoked or have already been used are not found.
+func UseRefreshToken(conn Conn, hash []byte, now int64) (models.RefreshToken, error) {
+	sqlStatement := `
+		UPDATE refresh_tokens
+		SET used = $2
+		WHERE token = $1 AND used IS NULL AND revoked IS NULL AND expires > $2
+		RETURNING family, user_id, workspace_id, created, expires`
+
+	var family, userId, workspace uuid.UUID
+	var created, expires int64
+	err := conn.QueryRow(sqlStatement, hash, now).Scan(&family, &userId, &workspace, &created, &expires)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Failed to use refresh token:", err)
+		}
+		return models.RefreshToken{}, ErrFailedToLoadData
+	}
+
+	token := models.RefreshToken{
+		Hash:      hash,
+		Family:    family,
+		UserUUID:  userId,
+		Workspace: workspace,
+		Created:   created,
+		Expires:   expires,
+		Used:      now,
+	}
+	return token, nil
+}
+
+func RevokeRefreshTokenFamily(conn Conn, family uuid.UUID, now int64) error {
+	sqlStatement := `
+		UPDATE refresh_tokens
+		SET revoked = $2
+		WHERE family = $1 AND revoked IS NULL`
+
+	_, err := conn.Exec(sqlStatement, family, now)
+	if err != nil {
+		log.Println("Failed to revoke refresh tokens:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return nil
+}
+
+func RevokeUserRefreshTokens(conn Conn, user string, now int64) error {
+	sqlStatement := `
+		UPDATE refresh_tokens
+		SET revoked = $2
+		WHERE user_id = $1 AND revoked IS NULL`
+
+	_, err := conn.Exec(sqlStatement, user, now)
+	if err != nil {
+		log.Println("Failed to revoke refresh tokens:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return nil
+}
+
+func RevokeAccessToken(conn Conn, jti string, expires int64) error {
+	sqlStatement := `
+		INSERT INTO revoked_tokens (jti, expires)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	_, err := conn.Exec(sqlStatement, jti, expires)
+	if err != nil {
+		log.Println("Failed to revoke access token:", err)
+		return ErrFailedToInsert
+	}
+
+	return nil
+}
+
+func IsAccessTokenRevoked(conn Conn, jti string) (bool, error) {
+	sqlStatement := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+
+	var revoked bool
+	if err := conn.QueryRow(sqlStatement, jti).Scan(&revoked); err != nil {
+		log.Println("Failed to check revoked tokens:", err)
+		return false, ErrFailedToLoadData
+	}
+
+	return revoked, nil
+}
+
+// PurgeExpiredTokens removes denylist entries and refresh tokens that have
+// expired and can no longer be used.
+func PurgeExpiredTokens(conn Conn, now int64) error {
+	if _, err := conn.Exec(`DELETE FROM revoked_tokens WHERE expires <= $1`, now); err != nil {
+		log.Println("Failed to purge revoked tokens:", err)
+		return ErrFailedToDeleteData
+	}
+
+	if _, err := conn.Exec(`DELETE FROM refresh_tokens WHERE expires <= $1`, now); err != nil {
+		log.Println("Failed to purge refresh tokens:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// Tombstones record the ids of deleted lists and items so that sync can tell
+// an update to a deleted entity apart from one that never existed.
+func CreateTombstone(conn Conn, user string, id uuid.UUID, ts int64) error {
+	sqlStatement := `
+		INSERT INTO tombstones (id, user_id, ts)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	_, err := conn.Exec(sqlStatement, id, user, ts)
+	if err != nil {
+		log.Println("Failed to create tombstone:", err)
+		return ErrFailedToInsert
+	}
+
+	return nil
+}
+
+// collaborators are the users that share a list with the user in $1. Lists and
+// items of a shared list can be deleted by any of them, so their tombstones
+// count for everyone.
+const collaborators = `
+	SELECT o.user_id FROM list_members o
+	INNER JOIN list_members m ON (m.list_id = o.list_id)
+	WHERE m.user_id = $1`
+
+func IsTombstoned(conn Conn, user string, id uuid.UUID) (bool, error) {
+	sqlStatement := `
+		SELECT EXISTS (
+			SELECT 1 FROM tombstones
+			WHERE id = $2 AND (user_id = $1 OR user_id IN (` + collaborators + `))
+		)`
+
+	var exists bool
+	if err := conn.QueryRow(sqlStatement, user, id).Scan(&exists); err != nil {
+		log.Println("Failed to check tombstones:", err)
+		return false, ErrFailedToLoadData
+	}
+
+	return exists, nil
+}
+
+// DeleteTombstone forgets that id was deleted, used when it is restored.
+func DeleteTombstone(conn Conn, user string, id uuid.UUID) error {
+	sqlStatement := `
+		DELETE FROM tombstones
+		WHERE id = $2 AND (user_id = $1 OR user_id IN (` + collaborators + `))`
+
+	if _, err := conn.Exec(sqlStatement, user, id); err != nil {
+		log.Println("Failed to delete tombstone:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return nil
+}
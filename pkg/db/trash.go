@@ -0,0 +1,247 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+)
+
+// Lists and items are soft deleted by setting deleted to when they were
+// trashed. Trashing a list trashes its items with the same timestamp, so
+// restoring the list brings back exactly the items that were trashed with it.
+// Trashing an item does the same for its subtasks.
+//
+// Only the owner of a list can trash and restore it, items can be trashed and
+// restored by every member that can edit their list.
+
+var selectTrashedListsStatement = `
+	SELECT ` + listColumns + `
+	FROM lists l
+	INNER JOIN list_members m ON (m.list_id = l.id AND m.user_id = $1)
+	WHERE m.role = 'owner' AND l.deleted <> 0
+	ORDER BY l.deleted DESC`
+
+var selectTrashedListStatement = `
+	SELECT ` + listColumns + `
+	FROM lists l
+	INNER JOIN list_members m ON (m.list_id = l.id AND m.user_id = $1)
+	WHERE m.role = 'owner' AND l.id = $2 AND l.deleted <> 0`
+
+// only items trashed on their own, items trashed with their list or parent
+// are restored through it
+var selectTrashedItemsStatement = `
+	SELECT ` + itemColumns + `
+	FROM items i
+	INNER JOIN lists l ON (i.list_id = l.id)
+	WHERE ` + editorOf("l.id", "$1") + ` AND l.deleted = 0 AND i.deleted <> 0
+		AND NOT EXISTS (SELECT 1 FROM items p WHERE p.id = i.parent_id AND p.deleted = i.deleted)
+	ORDER BY i.deleted DESC`
+
+var selectTrashedItemStatement = `
+	SELECT ` + itemColumns + `
+	FROM items i
+	INNER JOIN lists l ON (i.list_id = l.id)
+	WHERE ` + editorOf("l.id", "$1") + ` AND i.id = $2 AND i.deleted <> 0`
+
+func RetrieveTrashedLists(conn Conn, user string) ([]models.List, error) {
+	rows, err := conn.Query(selectTrashedListsStatement, user)
+	if err != nil {
+		log.Printf("Failed to load trashed lists for user %s\nError: %s\n", user, err.Error())
+		return []models.List{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	lists := make([]models.List, 0)
+	for rows.Next() {
+		list, err := scanList(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.List{}, ErrFailedToScanRow
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, nil
+}
+
+func RetrieveTrashedList(conn Conn, user, id string) (models.List, error) {
+	list, err := scanList(conn.QueryRow(selectTrashedListStatement, user, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to execute query: %s\n", err.Error())
+		}
+		return models.List{}, ErrFailedToLoadData
+	}
+
+	return list, nil
+}
+
+func TrashList(conn Conn, user string, list models.List, ts int64) error {
+	sqlStatement := `
+		UPDATE items SET deleted = $3
+		FROM lists l
+		WHERE items.list_id = l.id AND ` + ownerOf("l.id", "$1") + ` AND l.id = $2 AND l.deleted = 0 AND items.deleted = 0`
+
+	if _, err := conn.Exec(sqlStatement, user, list.UUID, ts); err != nil {
+		log.Println("Failed to trash items in list:", err)
+		return ErrFailedToDeleteData
+	}
+
+	sqlStatement = `
+		UPDATE lists l SET deleted = $3
+		WHERE l.id = $2 AND l.deleted = 0 AND ` + ownerOf("l.id", "$1")
+
+	res, err := conn.Exec(sqlStatement, user, list.UUID, ts)
+	if err != nil {
+		log.Println("Failed to trash list:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return requireRows(res)
+}
+
+// RestoreList restores the list and the items that were trashed with it,
+// list.Deleted must be when the list was trashed.
+func RestoreList(conn Conn, user string, list models.List) error {
+	sqlStatement := `
+		UPDATE lists l SET deleted = 0
+		WHERE l.id = $2 AND l.deleted <> 0 AND ` + ownerOf("l.id", "$1")
+
+	res, err := conn.Exec(sqlStatement, user, list.UUID)
+	if err != nil {
+		log.Println("Failed to restore list:", err)
+		return ErrFailedToUpdateData
+	}
+
+	if err := requireRows(res); err != nil {
+		return err
+	}
+
+	sqlStatement = `
+		UPDATE items SET deleted = 0
+		FROM lists l
+		WHERE items.list_id = l.id AND ` + ownerOf("l.id", "$1") + ` AND l.id = $2 AND items.deleted = $3`
+
+	if _, err := conn.Exec(sqlStatement, user, list.UUID, list.Deleted); err != nil {
+		log.Println("Failed to restore items in list:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return nil
+}
+
+func RetrieveTrashedItems(conn Conn, user string) ([]models.Item, error) {
+	rows, err := conn.Query(selectTrashedItemsStatement, user)
+	if err != nil {
+		log.Printf("Failed to load trashed items for user %s\nError: %s\n", user, err.Error())
+		return []models.Item{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	items := make([]models.Item, 0)
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Item{}, ErrFailedToScanRow
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func RetrieveTrashedItem(conn Conn, user, id string) (models.Item, error) {
+	item, err := scanItem(conn.QueryRow(selectTrashedItemStatement, user, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to execute query: %s\n", err.Error())
+		}
+		return models.Item{}, ErrFailedToLoadData
+	}
+
+	return item, nil
+}
+
+func TrashItem(conn Conn, user string, item models.Item, ts int64) error {
+	sqlStatement := `
+		UPDATE items SET deleted = $3
+		FROM lists l
+		WHERE items.id = $1 AND items.list_id = l.id AND ` + editorOf("l.id", "$2") + ` AND items.deleted = 0`
+
+	res, err := conn.Exec(sqlStatement, item.UUID, user, ts)
+	if err != nil {
+		log.Println("Failed to trash item:", err)
+		return ErrFailedToDeleteData
+	}
+
+	if err := requireRows(res); err != nil {
+		return err
+	}
+
+	sqlStatement = descendantsStatement + `
+		UPDATE items SET deleted = $2
+		WHERE id IN (SELECT id FROM descendants) AND deleted = 0`
+
+	if _, err := conn.Exec(sqlStatement, item.UUID, ts); err != nil {
+		log.Println("Failed to trash subtasks:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return nil
+}
+
+// RestoreItem restores the item and the subtasks that were trashed with it,
+// item.Deleted must be when the item was trashed. ErrNoAccess is returned if
+// the item's list or parent is in the trash.
+func RestoreItem(conn Conn, user string, item models.Item) error {
+	sqlStatement := `
+		UPDATE items SET deleted = 0
+		FROM lists l
+		WHERE items.id = $1 AND items.list_id = l.id AND ` + editorOf("l.id", "$2") + ` AND l.deleted = 0 AND items.deleted <> 0
+			AND (items.parent_id IS NULL OR EXISTS (SELECT 1 FROM items p WHERE p.id = items.parent_id AND p.deleted = 0))`
+
+	res, err := conn.Exec(sqlStatement, item.UUID, user)
+	if err != nil {
+		log.Println("Failed to restore item:", err)
+		return ErrFailedToUpdateData
+	}
+
+	if err := requireRows(res); err != nil {
+		return err
+	}
+
+	sqlStatement = descendantsStatement + `
+		UPDATE items SET deleted = 0
+		WHERE id IN (SELECT id FROM descendants) AND deleted = $2`
+
+	if _, err := conn.Exec(sqlStatement, item.UUID, item.Deleted); err != nil {
+		log.Println("Failed to restore subtasks:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return nil
+}
+
+// PurgeTrash permanently deletes everything trashed before the given time.
+func PurgeTrash(conn Conn, before int64) error {
+	sqlStatement := `
+		DELETE FROM items
+		WHERE (deleted <> 0 AND deleted < $1)
+			OR list_id IN (SELECT id FROM lists WHERE deleted <> 0 AND deleted < $1)`
+
+	if _, err := conn.Exec(sqlStatement, before); err != nil {
+		log.Println("Failed to purge trashed items:", err)
+		return ErrFailedToDeleteData
+	}
+
+	sqlStatement = `DELETE FROM lists WHERE deleted <> 0 AND deleted < $1`
+
+	if _, err := conn.Exec(sqlStatement, before); err != nil {
+		log.Println("Failed to purge trashed lists:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"log"
 
 	"ismacaulay/procrast-api/pkg/models"
@@ -8,62 +9,84 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	TokenVerification  = "verification"
+	TokenPasswordReset = "password_reset"
+)
+
+var userTokenTables = map[string]string{
+	TokenVerification:  "email_verifications",
+	TokenPasswordReset: "password_resets",
+}
+
+// FindUserByEmail matches the address case insensitively, which the unique
+// lower(email) index keeps to a single account.
 func FindUserByEmail(conn Conn, email string) (models.User, error) {
 	sqlStatement := `
-		SELECT id, email, passhash, created, modified
+		SELECT id, email, passhash, created, modified, verified, time_zone, not_before
 		FROM users
-		WHERE email = $1`
+		WHERE lower(email) = lower($1)`
 
 	var id uuid.UUID
 	var storedEmail string
 	var passHash []byte
-	var created, modified int64
-	err := conn.QueryRow(sqlStatement, email).Scan(&id, &storedEmail, &passHash, &created, &modified)
+	var created, modified, verified int64
+	var timeZone string
+	var notBefore int64
+	err := conn.QueryRow(sqlStatement, email).Scan(&id, &storedEmail, &passHash, &created, &modified, &verified, &timeZone, &notBefore)
 	if err != nil {
 		return models.User{}, ErrFailedToLoadData
 	}
 
 	user := models.User{
-		UUID:     id,
-		Email:    storedEmail,
-		PassHash: passHash,
-		Created:  created,
-		Modified: modified,
+		UUID:      id,
+		Email:     storedEmail,
+		PassHash:  passHash,
+		Created:   created,
+		Modified:  modified,
+		Verified:  verified,
+		TimeZone:  timeZone,
+		NotBefore: notBefore,
 	}
 	return user, nil
 }
 
 func FindUserByUUID(conn Conn, id string) (models.User, error) {
 	sqlStatement := `
-		SELECT id, email, passhash, created, modified
+		SELECT id, email, passhash, created, modified, verified, time_zone, not_before
 		FROM users
 		WHERE id = $1`
 
 	var storedId uuid.UUID
 	var storedEmail string
 	var passHash []byte
-	var created, modified int64
-	err := conn.QueryRow(sqlStatement, id).Scan(&storedId, &storedEmail, &passHash, &created, &modified)
+	var created, modified, verified int64
+	var timeZone string
+	var notBefore int64
+	err := conn.QueryRow(sqlStatement, id).Scan(&storedId, &storedEmail, &passHash, &created, &modified, &verified, &timeZone, &notBefore)
 	if err != nil {
 		return models.User{}, ErrFailedToLoadData
 	}
 
 	user := models.User{
-		UUID:     storedId,
-		Email:    storedEmail,
-		PassHash: passHash,
-		Created:  created,
-		Modified: modified,
+		UUID:      storedId,
+		Email:     storedEmail,
+		PassHash:  passHash,
+		Created:   created,
+		Modified:  modified,
+		Verified:  verified,
+		TimeZone:  timeZone,
+		NotBefore: notBefore,
 	}
 	return user, nil
 }
 
 func CreateUser(conn Conn, user models.User) error {
 	sqlStatement := `
-		INSERT INTO users (id, email, passhash, created, modified)
-		VALUES ($1, $2, $3, $4, $5)`
+		INSERT INTO users (id, email, passhash, created, modified, verified, time_zone, not_before)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
 
-	_, err := conn.Exec(sqlStatement, user.UUID, user.Email, user.PassHash, user.Created, user.Modified)
+	_, err := conn.Exec(sqlStatement, user.UUID, user.Email, user.PassHash, user.Created, user.Modified, user.Verified, user.TimeZone, user.NotBefore)
 	if err != nil {
 		log.Println("Failed to create user:", err)
 		return ErrFailedToInsert
@@ -71,3 +94,71 @@ func CreateUser(conn Conn, user models.User) error {
 
 	return nil
 }
+
+func UpdateUser(conn Conn, user models.User) error {
+	sqlStatement := `
+		UPDATE users
+		SET email = $2, passhash = $3, modified = $4, verified = $5, time_zone = $6, not_before = $7
+		WHERE id = $1`
+
+	_, err := conn.Exec(sqlStatement, user.UUID, user.Email, user.PassHash, user.Modified, user.Verified, user.TimeZone, user.NotBefore)
+	if err != nil {
+		log.Println("Failed to update user:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return nil
+}
+
+func CreateUserToken(conn Conn, kind string, token models.UserToken) error {
+	table, ok := userTokenTables[kind]
+	if !ok {
+		return ErrFailedToInsert
+	}
+
+	sqlStatement := `
+		INSERT INTO ` + table + ` (token, user_id, created, expires)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err := conn.Exec(sqlStatement, token.Hash, token.UserUUID, token.Created, token.Expires)
+	if err != nil {
+		log.Println("Failed to create user token:", err)
+		return ErrFailedToInsert
+	}
+
+	return nil
+}
+
+// UseUserToken marks the token as used and returns it. Tokens that are
+// expired or have already been used are not found.
+func UseUserToken(conn Conn, kind string, hash []byte, now int64) (models.UserToken, error) {
+	table, ok := userTokenTables[kind]
+	if !ok {
+		return models.UserToken{}, ErrFailedToLoadData
+	}
+
+	sqlStatement := `
+		UPDATE ` + table + `
+		SET used = $2
+		WHERE token = $1 AND used IS NULL AND expires > $2
+		RETURNING user_id, created, expires`
+
+	var userId uuid.UUID
+	var created, expires int64
+	err := conn.QueryRow(sqlStatement, hash, now).Scan(&userId, &created, &expires)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Failed to use user token:", err)
+		}
+		return models.UserToken{}, ErrFailedToLoadData
+	}
+
+	token := models.UserToken{
+		Hash:     hash,
+		UserUUID: userId,
+		Created:  created,
+		Expires:  expires,
+		Used:     now,
+	}
+	return token, nil
+}
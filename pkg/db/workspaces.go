@@ -0,0 +1,199 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	"ismacaulay/procrast-api/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// workspaceOwnerOf is the access check for changing a workspace, the user in
+// the given parameter has to own the workspace with the given id column.
+func workspaceOwnerOf(workspace, user string) string {
+	return `EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = ` + workspace + ` AND o.user_id = ` + user + ` AND o.role = 'owner')`
+}
+
+// keepsWorkspaceOwner holds unless w is the last owner of its workspace.
+const keepsWorkspaceOwner = `(w.role <> 'owner' OR EXISTS (
+	SELECT 1 FROM workspace_members o
+	WHERE o.workspace_id = w.workspace_id AND o.user_id <> w.user_id AND o.role = 'owner'))`
+
+const workspaceColumns = `ws.id, ws.name, w.role, ws.created, ws.modified`
+
+func scanWorkspace(row interface{ Scan(...interface{}) error }) (models.Workspace, error) {
+	var workspace models.Workspace
+	err := row.Scan(&workspace.UUID, &workspace.Name, &workspace.Role, &workspace.Created, &workspace.Modified)
+	return workspace, err
+}
+
+// RetrieveWorkspaces returns the workspaces the user is a member of.
+func RetrieveWorkspaces(conn Conn, user string) ([]models.Workspace, error) {
+	sqlStatement := `
+		SELECT ` + workspaceColumns + `
+		FROM workspaces ws
+		INNER JOIN workspace_members w ON (w.workspace_id = ws.id AND w.user_id = $1)
+		ORDER BY ws.name ASC, ws.id ASC`
+
+	rows, err := conn.Query(sqlStatement, user)
+	if err != nil {
+		log.Printf("Failed to load workspaces for user %s\nError: %s\n", user, err.Error())
+		return []models.Workspace{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	workspaces := make([]models.Workspace, 0)
+	for rows.Next() {
+		workspace, err := scanWorkspace(rows)
+		if err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.Workspace{}, ErrFailedToScanRow
+		}
+		workspaces = append(workspaces, workspace)
+	}
+
+	return workspaces, nil
+}
+
+func RetrieveWorkspace(conn Conn, user, id string) (models.Workspace, error) {
+	sqlStatement := `
+		SELECT ` + workspaceColumns + `
+		FROM workspaces ws
+		INNER JOIN workspace_members w ON (w.workspace_id = ws.id AND w.user_id = $1)
+		WHERE ws.id = $2`
+
+	workspace, err := scanWorkspace(conn.QueryRow(sqlStatement, user, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to execute query: %s\n", err.Error())
+		}
+		return models.Workspace{}, ErrFailedToLoadData
+	}
+
+	return workspace, nil
+}
+
+// CreateWorkspace creates the workspace with the user as its owner.
+func CreateWorkspace(conn Conn, user string, workspace models.Workspace) error {
+	sqlStatement := `
+		INSERT INTO workspaces (id, name, created, modified)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err := conn.Exec(sqlStatement, workspace.UUID, workspace.Name, workspace.Created, workspace.Modified)
+	if err != nil {
+		log.Println("Failed to create workspace:", err)
+		return ErrFailedToInsert
+	}
+
+	sqlStatement = `
+		INSERT INTO workspace_members (workspace_id, user_id, role, created)
+		VALUES ($1, $2, 'owner', $3)`
+
+	_, err = conn.Exec(sqlStatement, workspace.UUID, user, workspace.Created)
+	if err != nil {
+		log.Println("Failed to add workspace owner:", err)
+		return ErrFailedToInsert
+	}
+
+	return nil
+}
+
+func UpdateWorkspace(conn Conn, user string, workspace models.Workspace) error {
+	sqlStatement := `
+		UPDATE workspaces ws
+		SET name = $3, modified = $4
+		WHERE ws.id = $2 AND ` + workspaceOwnerOf("ws.id", "$1")
+
+	res, err := conn.Exec(sqlStatement, user, workspace.UUID, workspace.Name, workspace.Modified)
+	if err != nil {
+		log.Println("Failed to update workspace:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return requireRows(res)
+}
+
+// RetrieveWorkspaceMembers returns the members of a workspace the user is a
+// member of, the owners first.
+func RetrieveWorkspaceMembers(conn Conn, user, workspaceId string) ([]models.WorkspaceMember, error) {
+	sqlStatement := `
+		SELECT w.workspace_id, w.user_id, u.email, w.role, w.created
+		FROM workspace_members w
+		INNER JOIN users u ON (u.id = w.user_id)
+		WHERE w.workspace_id = $2
+			AND EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = $2 AND o.user_id = $1)
+		ORDER BY w.role = 'owner' DESC, w.created ASC, w.user_id ASC`
+
+	rows, err := conn.Query(sqlStatement, user, workspaceId)
+	if err != nil {
+		log.Printf("Failed to load members of workspace %s\nError: %s\n", workspaceId, err.Error())
+		return []models.WorkspaceMember{}, ErrFailedToLoadData
+	}
+	defer rows.Close()
+
+	members := make([]models.WorkspaceMember, 0)
+	for rows.Next() {
+		var m models.WorkspaceMember
+		if err := rows.Scan(&m.WorkspaceUUID, &m.UserUUID, &m.Email, &m.Role, &m.Created); err != nil {
+			log.Printf("Failed to scan row: %s\n", err.Error())
+			return []models.WorkspaceMember{}, ErrFailedToScanRow
+		}
+		members = append(members, m)
+	}
+
+	return members, nil
+}
+
+// AddWorkspaceMember adds a user to a workspace owned by the user. Users that
+// are already members are not added again.
+func AddWorkspaceMember(conn Conn, user string, member models.WorkspaceMember) error {
+	sqlStatement := `
+		INSERT INTO workspace_members (workspace_id, user_id, role, created)
+		SELECT $2, $3, $4, $5
+		WHERE ` + workspaceOwnerOf("$2::uuid", "$1") + `
+		ON CONFLICT DO NOTHING`
+
+	res, err := conn.Exec(sqlStatement, user, member.WorkspaceUUID, member.UserUUID, member.Role, member.Created)
+	if err != nil {
+		log.Println("Failed to add workspace member:", err)
+		return ErrFailedToInsert
+	}
+
+	return requireRows(res)
+}
+
+// UpdateWorkspaceMember changes the role of a member of a workspace owned by
+// the user, as long as the workspace keeps an owner.
+func UpdateWorkspaceMember(conn Conn, user string, member models.WorkspaceMember) error {
+	sqlStatement := `
+		UPDATE workspace_members w
+		SET role = $4
+		WHERE w.workspace_id = $2 AND w.user_id = $3 AND ` + workspaceOwnerOf("$2", "$1") + `
+			AND ($4 = 'owner' OR ` + keepsWorkspaceOwner + `)`
+
+	res, err := conn.Exec(sqlStatement, user, member.WorkspaceUUID, member.UserUUID, member.Role)
+	if err != nil {
+		log.Println("Failed to update workspace member:", err)
+		return ErrFailedToUpdateData
+	}
+
+	return requireRows(res)
+}
+
+// RemoveWorkspaceMember removes member from the workspace. Owners can remove
+// anyone, other members can only leave. The last owner can't be removed.
+func RemoveWorkspaceMember(conn Conn, user string, workspace, member uuid.UUID) error {
+	sqlStatement := `
+		DELETE FROM workspace_members w
+		WHERE w.workspace_id = $2 AND w.user_id = $3 AND ` + keepsWorkspaceOwner + `
+			AND ($3::uuid = $1::uuid OR ` + workspaceOwnerOf("$2", "$1") + `)`
+
+	res, err := conn.Exec(sqlStatement, user, workspace, member)
+	if err != nil {
+		log.Println("Failed to remove workspace member:", err)
+		return ErrFailedToDeleteData
+	}
+
+	return requireRows(res)
+}
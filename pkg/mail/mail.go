@@ -0,0 +1,92 @@
+package mail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	log.Printf("Mail to %s: %s\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes each message to its own file in Dir.
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(msg Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UTC().UnixNano(), sanitize(msg.To))
+	path := filepath.Join(m.Dir, name)
+	if err := ioutil.WriteFile(path, format("", msg), 0600); err != nil {
+		log.Println("Failed to write mail:", err)
+		return err
+	}
+
+	return nil
+}
+
+// SMTPMailer sends messages through an SMTP server. Auth is skipped when User
+// is empty.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	User     string
+	Password string
+}
+
+func (m SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.User != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.User, m.Password, host)
+	}
+
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, format(m.From, msg)); err != nil {
+		log.Println("Failed to send mail:", err)
+		return err
+	}
+
+	return nil
+}
+
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "\r\n%s\r\n", msg.Body)
+	return []byte(b.String())
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,229 @@
+package migrate
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"ismacaulay/procrast-api/pkg/db"
+)
+
+// Migration is a pair of .sql files, <version>_<name>.up.sql and
+// <version>_<name>.down.sql, with the words of the name separated by
+// underscores. Version is numbered from 1 and may be zero padded.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var (
+	ErrSchemaAhead       = errors.New("Database schema is newer than this binary")
+	ErrInvalidTarget     = errors.New("Invalid target version")
+	ErrInvalidMigrations = errors.New("Migrations must be ordered and start at version 1")
+)
+
+// Migrator applies migrations to a database and records each applied version
+// in the version table. When DryRun is set the statements are written to Out
+// instead of being executed.
+type Migrator struct {
+	conn       db.DB
+	migrations []Migration
+
+	DryRun bool
+	Out    io.Writer
+}
+
+// mustLoad reads the migrations in dir. The files are embedded in the binary
+// so any problem with them is a bug, which panics.
+func mustLoad(files embed.FS, dir string) []Migration {
+	entries, err := files.ReadDir(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		direction := path.Ext(base)
+		parts := strings.SplitN(strings.TrimSuffix(base, direction), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || (direction != ".up" && direction != ".down") {
+			panic(fmt.Sprintf("invalid migration file name: %s", entry.Name()))
+		}
+
+		contents, err := files.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		statements := strings.TrimSuffix(strings.TrimSpace(string(contents)), ";")
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: strings.ReplaceAll(parts[1], "_", " ")}
+			byVersion[version] = m
+		}
+
+		if direction == ".up" {
+			m.Up = statements
+		} else {
+			m.Down = statements
+		}
+	}
+
+	migrations := make([]Migration, len(byVersion))
+	for version, m := range byVersion {
+		if version < 1 || version > len(migrations) || m.Up == "" || m.Down == "" {
+			panic(fmt.Sprintf("migration %d in %s is missing or incomplete", version, dir))
+		}
+		migrations[version-1] = *m
+	}
+	return migrations
+}
+
+func New(conn db.DB, migrations []Migration) (*Migrator, error) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, ErrInvalidMigrations
+		}
+	}
+
+	return &Migrator{conn: conn, migrations: migrations}, nil
+}
+
+// Latest returns the newest version known to the binary.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Current returns the newest version applied to the database, or 0 if the
+// version table does not exist yet.
+func (m *Migrator) Current() (int, error) {
+	var exists bool
+	err := m.conn.QueryRow(`SELECT to_regclass('version') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		log.Println("Failed to check for version table:", err)
+		return 0, db.ErrFailedToLoadData
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err = m.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM version`).Scan(&version)
+	if err != nil {
+		log.Println("Failed to load schema version:", err)
+		return 0, db.ErrFailedToLoadData
+	}
+
+	return version, nil
+}
+
+// Check returns ErrSchemaAhead if the database has migrations applied that
+// this binary does not know about.
+func (m *Migrator) Check() error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	if current > m.Latest() {
+		return ErrSchemaAhead
+	}
+
+	return nil
+}
+
+// Up applies all migrations after the current version up to and including
+// target.
+func (m *Migrator) Up(target int) error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	if current > m.Latest() {
+		return ErrSchemaAhead
+	}
+
+	if target < current || target > m.Latest() {
+		return ErrInvalidTarget
+	}
+
+	for _, migration := range m.migrations[current:target] {
+		insert := fmt.Sprintf(
+			"INSERT INTO version (version, created) VALUES (%d, %d)",
+			migration.Version, time.Now().UTC().Unix())
+		if err := m.apply(migration, "up", migration.Up, insert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Down reverts all applied migrations newer than target.
+func (m *Migrator) Down(target int) error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	if current > m.Latest() {
+		return ErrSchemaAhead
+	}
+
+	if target < 0 || target > current {
+		return ErrInvalidTarget
+	}
+
+	for i := current; i > target; i-- {
+		migration := m.migrations[i-1]
+
+		// the first migration drops the version table itself
+		remove := ""
+		if migration.Version > 1 {
+			remove = fmt.Sprintf("DELETE FROM version WHERE version = %d", migration.Version)
+		}
+		if err := m.apply(migration, "down", migration.Down, remove); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) apply(migration Migration, direction string, statements ...string) error {
+	if m.DryRun {
+		fmt.Fprintf(m.Out, "-- %d %s (%s)\n", migration.Version, migration.Name, direction)
+		for _, s := range statements {
+			if s != "" {
+				fmt.Fprintf(m.Out, "%s;\n", s)
+			}
+		}
+		return nil
+	}
+
+	log.Printf("Migrating %s: %d %s\n", direction, migration.Version, migration.Name)
+	return db.Transaction(m.conn, func(tx db.Conn) error {
+		for _, s := range statements {
+			if s == "" {
+				continue
+			}
+
+			if _, err := tx.Exec(s); err != nil {
+				log.Printf("Failed to apply migration %d: %s\n", migration.Version, err)
+				return db.ErrFailedToUpdateData
+			}
+		}
+		return nil
+	})
+}
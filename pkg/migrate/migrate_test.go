@@ -0,0 +1,21 @@
+package migrate
+
+import "testing"
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for name, migrations := range map[string][]Migration{"procrast": ProcrastMigrations, "user": UserMigrations} {
+		if _, err := New(nil, migrations); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+
+		for _, m := range migrations {
+			if m.Name == "" || m.Up == "" || m.Down == "" {
+				t.Errorf("%s: migration %d is incomplete", name, m.Version)
+			}
+		}
+	}
+
+	if ProcrastMigrations[0].Name != "create tables" {
+		t.Errorf("unexpected name: %q", ProcrastMigrations[0].Name)
+	}
+}
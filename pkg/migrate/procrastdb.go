@@ -0,0 +1,10 @@
+package migrate
+
+import "embed"
+
+//go:embed sql/procrast/*.sql
+var procrastFiles embed.FS
+
+// ProcrastMigrations are the migrations for the procrast database in
+// sql/procrast. Version 1 matches db/procrastdb/1-tables.sql.
+var ProcrastMigrations = mustLoad(procrastFiles, "sql/procrast")
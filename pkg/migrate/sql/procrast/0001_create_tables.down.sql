@@ -0,0 +1,4 @@
+DROP TABLE history;
+DROP TABLE items;
+DROP TABLE lists;
+DROP TABLE version;
@@ -0,0 +1,3 @@
+DROP TABLE tombstones;
+ALTER TABLE items DROP COLUMN clock;
+ALTER TABLE lists DROP COLUMN clock;
@@ -0,0 +1,8 @@
+ALTER TABLE lists ADD COLUMN clock text NOT NULL DEFAULT '{}';
+ALTER TABLE items ADD COLUMN clock text NOT NULL DEFAULT '{}';
+
+CREATE TABLE tombstones (
+    id uuid PRIMARY KEY,
+    user_id uuid,
+    ts bigint
+);
@@ -0,0 +1,4 @@
+DELETE FROM items WHERE deleted <> 0 OR list_id IN (SELECT id FROM lists WHERE deleted <> 0);
+DELETE FROM lists WHERE deleted <> 0;
+ALTER TABLE items DROP COLUMN deleted;
+ALTER TABLE lists DROP COLUMN deleted;
@@ -0,0 +1,2 @@
+ALTER TABLE lists ADD COLUMN deleted bigint NOT NULL DEFAULT 0;
+ALTER TABLE items ADD COLUMN deleted bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE items DROP COLUMN start;
+ALTER TABLE items DROP COLUMN due;
@@ -0,0 +1,2 @@
+ALTER TABLE items ADD COLUMN due bigint NOT NULL DEFAULT 0;
+ALTER TABLE items ADD COLUMN start bigint NOT NULL DEFAULT 0;
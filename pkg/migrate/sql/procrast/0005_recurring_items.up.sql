@@ -0,0 +1,2 @@
+ALTER TABLE items ADD COLUMN rrule text NOT NULL DEFAULT '';
+ALTER TABLE items ADD COLUMN occurrence int NOT NULL DEFAULT 0;
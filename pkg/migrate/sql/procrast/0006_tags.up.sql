@@ -0,0 +1,20 @@
+CREATE TABLE tags (
+    id uuid PRIMARY KEY,
+    user_id uuid NOT NULL,
+    name text NOT NULL,
+    color text NOT NULL DEFAULT '',
+    created bigint NOT NULL,
+    modified bigint NOT NULL,
+    clock text NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX tags_user_id ON tags (user_id);
+
+CREATE TABLE item_tags (
+    item_id uuid REFERENCES items(id) ON DELETE CASCADE,
+    tag_id uuid REFERENCES tags(id) ON DELETE CASCADE,
+    created bigint NOT NULL,
+    PRIMARY KEY (item_id, tag_id)
+);
+
+CREATE INDEX item_tags_tag_id ON item_tags (tag_id);
@@ -0,0 +1,2 @@
+ALTER TABLE items DROP COLUMN position;
+ALTER TABLE lists DROP COLUMN position;
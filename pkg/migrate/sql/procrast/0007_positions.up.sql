@@ -0,0 +1,18 @@
+ALTER TABLE lists ADD COLUMN position text NOT NULL DEFAULT '';
+ALTER TABLE items ADD COLUMN position text NOT NULL DEFAULT '';
+
+-- keep the old order, newest lists first and items oldest first. Positions
+-- are base 62 fractions that cannot end in a zero.
+UPDATE lists SET position = p.position
+FROM (
+    SELECT id, lpad(to_hex(row_number() OVER (PARTITION BY user_id ORDER BY created DESC, id)), 8, '0') || 'V' AS position
+    FROM lists
+) p
+WHERE lists.id = p.id;
+
+UPDATE items SET position = p.position
+FROM (
+    SELECT id, lpad(to_hex(row_number() OVER (PARTITION BY list_id ORDER BY created ASC, id)), 8, '0') || 'V' AS position
+    FROM items
+) p
+WHERE items.id = p.id;
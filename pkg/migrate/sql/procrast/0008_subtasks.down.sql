@@ -0,0 +1 @@
+ALTER TABLE items DROP COLUMN parent_id;
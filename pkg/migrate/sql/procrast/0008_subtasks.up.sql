@@ -0,0 +1,3 @@
+ALTER TABLE items ADD COLUMN parent_id uuid REFERENCES items(id) ON DELETE CASCADE;
+
+CREATE INDEX items_parent_id ON items (parent_id);
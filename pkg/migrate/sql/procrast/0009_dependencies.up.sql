@@ -0,0 +1,8 @@
+CREATE TABLE item_dependencies (
+    item_id uuid REFERENCES items(id) ON DELETE CASCADE,
+    blocker_id uuid REFERENCES items(id) ON DELETE CASCADE,
+    created bigint NOT NULL,
+    PRIMARY KEY (item_id, blocker_id)
+);
+
+CREATE INDEX item_dependencies_blocker_id ON item_dependencies (blocker_id);
@@ -0,0 +1 @@
+ALTER TABLE lists DROP COLUMN workflow;
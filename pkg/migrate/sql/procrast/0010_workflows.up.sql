@@ -0,0 +1,2 @@
+-- an empty workflow is the default todo and done workflow
+ALTER TABLE lists ADD COLUMN workflow text NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+ALTER TABLE lists ADD COLUMN position text NOT NULL DEFAULT '';
+
+UPDATE lists SET position = m.position
+FROM list_members m
+WHERE m.list_id = lists.id AND m.user_id = lists.user_id;
+
+DROP TABLE list_invitations;
+DROP TABLE list_members;
@@ -0,0 +1,30 @@
+CREATE TABLE list_members (
+    list_id uuid REFERENCES lists(id) ON DELETE CASCADE,
+    user_id uuid NOT NULL,
+    role text NOT NULL,
+    position text NOT NULL DEFAULT '',
+    position_ts bigint NOT NULL DEFAULT 0,
+    created bigint NOT NULL,
+    PRIMARY KEY (list_id, user_id)
+);
+
+CREATE INDEX list_members_user_id ON list_members (user_id);
+
+-- lists.user_id stays the owner, who is also a member. Positions are per
+-- member from now on, along with when they were last written.
+INSERT INTO list_members (list_id, user_id, role, position, position_ts, created)
+SELECT id, user_id, 'owner', position, COALESCE((clock::json->>'position')::bigint, 0), created
+FROM lists;
+
+ALTER TABLE lists DROP COLUMN position;
+
+CREATE TABLE list_invitations (
+    id uuid PRIMARY KEY,
+    list_id uuid REFERENCES lists(id) ON DELETE CASCADE,
+    email text NOT NULL,
+    role text NOT NULL,
+    invited_by uuid NOT NULL,
+    created bigint NOT NULL
+);
+
+CREATE UNIQUE INDEX list_invitations_email ON list_invitations (list_id, lower(email));
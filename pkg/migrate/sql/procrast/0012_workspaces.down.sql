@@ -0,0 +1,2 @@
+DROP INDEX lists_workspace_id;
+ALTER TABLE lists DROP COLUMN workspace_id;
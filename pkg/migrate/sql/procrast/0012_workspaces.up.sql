@@ -0,0 +1,6 @@
+-- the workspaces live in the user database, the nil uuid is no workspace.
+-- lists.user_id is who created the list from now on, workspace lists can have
+-- several owners
+ALTER TABLE lists ADD COLUMN workspace_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+
+CREATE INDEX lists_workspace_id ON lists (workspace_id);
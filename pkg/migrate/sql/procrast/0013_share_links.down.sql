@@ -0,0 +1 @@
+DROP TABLE list_share_links;
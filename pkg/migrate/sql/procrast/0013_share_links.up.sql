@@ -0,0 +1,10 @@
+CREATE TABLE list_share_links (
+    id uuid PRIMARY KEY,
+    list_id uuid REFERENCES lists(id) ON DELETE CASCADE,
+    token bytea NOT NULL UNIQUE,
+    created_by uuid NOT NULL,
+    created bigint NOT NULL,
+    expires bigint NOT NULL DEFAULT 0
+);
+
+CREATE INDEX list_share_links_list_id ON list_share_links (list_id);
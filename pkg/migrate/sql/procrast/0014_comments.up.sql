@@ -0,0 +1,13 @@
+-- replies are deleted with the comment they answer
+CREATE TABLE item_comments (
+    id uuid PRIMARY KEY,
+    item_id uuid NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    parent_id uuid REFERENCES item_comments(id) ON DELETE CASCADE,
+    user_id uuid NOT NULL,
+    body text NOT NULL,
+    created bigint NOT NULL,
+    edited bigint NOT NULL DEFAULT 0
+);
+
+CREATE INDEX item_comments_item_id ON item_comments (item_id, created, id);
+CREATE INDEX item_comments_parent_id ON item_comments (parent_id);
@@ -0,0 +1,2 @@
+DROP INDEX items_assignee_id;
+ALTER TABLE items DROP COLUMN assignee_id;
@@ -0,0 +1,3 @@
+ALTER TABLE items ADD COLUMN assignee_id uuid;
+
+CREATE INDEX items_assignee_id ON items (assignee_id);
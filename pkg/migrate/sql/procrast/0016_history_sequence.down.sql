@@ -0,0 +1,2 @@
+DROP INDEX history_user_id_created_seq;
+ALTER TABLE history DROP COLUMN seq;
@@ -0,0 +1,3 @@
+ALTER TABLE history ADD COLUMN seq bigserial;
+
+CREATE INDEX history_user_id_created_seq ON history (user_id, created, seq);
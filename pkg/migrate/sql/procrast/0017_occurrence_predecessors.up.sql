@@ -0,0 +1 @@
+ALTER TABLE items ADD COLUMN previous_id uuid;
@@ -0,0 +1,12 @@
+CREATE TABLE users (
+    id uuid PRIMARY KEY,
+    email text,
+    passhash bytea,
+    created bigint,
+    modified bigint
+);
+
+CREATE TABLE version (
+    version int PRIMARY KEY,
+    created bigint
+);
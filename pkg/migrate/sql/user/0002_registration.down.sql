@@ -0,0 +1,4 @@
+DROP TABLE password_resets;
+DROP TABLE email_verifications;
+DROP INDEX users_email_key;
+ALTER TABLE users DROP COLUMN verified;
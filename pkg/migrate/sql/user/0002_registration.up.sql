@@ -0,0 +1,19 @@
+ALTER TABLE users ADD COLUMN verified bigint NOT NULL DEFAULT 0;
+UPDATE users SET verified = created;
+CREATE UNIQUE INDEX users_email_key ON users (email);
+
+CREATE TABLE email_verifications (
+    token bytea PRIMARY KEY,
+    user_id uuid REFERENCES users(id),
+    created bigint,
+    expires bigint,
+    used bigint
+);
+
+CREATE TABLE password_resets (
+    token bytea PRIMARY KEY,
+    user_id uuid REFERENCES users(id),
+    created bigint,
+    expires bigint,
+    used bigint
+);
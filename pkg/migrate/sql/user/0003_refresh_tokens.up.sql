@@ -0,0 +1,15 @@
+CREATE TABLE refresh_tokens (
+    token bytea PRIMARY KEY,
+    family uuid,
+    user_id uuid REFERENCES users(id),
+    created bigint,
+    expires bigint,
+    used bigint,
+    revoked bigint
+);
+CREATE INDEX refresh_tokens_family ON refresh_tokens (family);
+
+CREATE TABLE revoked_tokens (
+    jti text PRIMARY KEY,
+    expires bigint
+);
@@ -0,0 +1,7 @@
+CREATE TABLE signing_keys (
+    kid text PRIMARY KEY,
+    alg text,
+    private_key bytea,
+    created bigint,
+    retired bigint
+);
@@ -0,0 +1,3 @@
+ALTER TABLE refresh_tokens DROP COLUMN workspace_id;
+DROP TABLE workspace_members;
+DROP TABLE workspaces;
@@ -0,0 +1,19 @@
+CREATE TABLE workspaces (
+    id uuid PRIMARY KEY,
+    name text NOT NULL,
+    created bigint NOT NULL,
+    modified bigint NOT NULL
+);
+
+CREATE TABLE workspace_members (
+    workspace_id uuid REFERENCES workspaces(id) ON DELETE CASCADE,
+    user_id uuid REFERENCES users(id),
+    role text NOT NULL,
+    created bigint NOT NULL,
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX workspace_members_user_id ON workspace_members (user_id);
+
+-- the nil uuid is no active workspace
+ALTER TABLE refresh_tokens ADD COLUMN workspace_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
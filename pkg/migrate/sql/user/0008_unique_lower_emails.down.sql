@@ -0,0 +1,2 @@
+DROP INDEX users_lower_email;
+CREATE INDEX users_lower_email ON users (lower(email));
@@ -0,0 +1,11 @@
+UPDATE users SET email = email || '#duplicate-' || id
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, row_number() OVER (PARTITION BY lower(email) ORDER BY created ASC, id ASC) AS n
+        FROM users
+        WHERE email IS NOT NULL
+    ) duplicates
+    WHERE n > 1
+);
+DROP INDEX users_lower_email;
+CREATE UNIQUE INDEX users_lower_email ON users (lower(email));
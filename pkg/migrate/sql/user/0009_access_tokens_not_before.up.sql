@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN not_before bigint NOT NULL DEFAULT 0;
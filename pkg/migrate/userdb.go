@@ -0,0 +1,10 @@
+package migrate
+
+import "embed"
+
+//go:embed sql/user/*.sql
+var userFiles embed.FS
+
+// UserMigrations are the migrations for the user database in sql/user.
+// Version 1 matches db/userdb/1-tables.sql.
+var UserMigrations = mustLoad(userFiles, "sql/user")
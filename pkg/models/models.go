@@ -4,22 +4,246 @@ import (
 	"github.com/google/uuid"
 )
 
+// Lists and items are ordered by Position, see the position package. Equal
+// positions are ordered by uuid. Workflow is the states the list's items can
+// be in, in the order they are shown. Lists without one use DefaultWorkflow.
+// Position and Role belong to the membership of the user the list was
+// retrieved for, every member orders their lists themselves.
+// WorkspaceUUID is the workspace that owns the list, uuid.Nil for lists that
+// belong to the user who created them.
 type List struct {
-	UUID        uuid.UUID `json:"uuid"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Created     int64     `json:"created"`
-	Modified    int64     `json:"modified"`
+	UUID          uuid.UUID       `json:"uuid"`
+	Title         string          `json:"title"`
+	Description   string          `json:"description"`
+	Created       int64           `json:"created"`
+	Modified      int64           `json:"modified"`
+	Position      string          `json:"position"`
+	Workflow      []WorkflowState `json:"workflow"`
+	Role          string          `json:"role,omitempty"`
+	WorkspaceUUID uuid.UUID       `json:"workspace_uuid"`
+	Deleted       int64           `json:"deleted,omitempty"`
+	Clock         Clock           `json:"-"`
+}
+
+// Roles of the members of a list. The owner shares, deletes and restores the
+// list, editors change the list and its items and viewers can only read them.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// CanEdit reports whether the role can change the list and its items.
+func CanEdit(role string) bool {
+	return role == RoleOwner || role == RoleEditor
+}
+
+// Member is a user a list is shared with. Email is filled in by the api from
+// the user database.
+type Member struct {
+	ListUUID uuid.UUID `json:"list_uuid"`
+	UserUUID uuid.UUID `json:"user_uuid"`
+	Email    string    `json:"email,omitempty"`
+	Role     string    `json:"role"`
+	Created  int64     `json:"created"`
+}
+
+// Invitation offers membership of a list to whoever has the email address,
+// which does not need to have an account yet.
+type Invitation struct {
+	UUID      uuid.UUID `json:"uuid"`
+	ListUUID  uuid.UUID `json:"list_uuid"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy uuid.UUID `json:"invited_by"`
+	Created   int64     `json:"created"`
+}
+
+// ShareLink gives anyone with its token read only access to a list, until it
+// expires or is revoked. Only the hash of the token is stored, Token is only
+// set when the link is created. Expires is 0 for links that don't expire.
+type ShareLink struct {
+	UUID      uuid.UUID `json:"uuid"`
+	ListUUID  uuid.UUID `json:"list_uuid"`
+	Token     string    `json:"token,omitempty"`
+	Hash      []byte    `json:"-"`
+	CreatedBy uuid.UUID `json:"created_by"`
+	Created   int64     `json:"created"`
+	Expires   int64     `json:"expires,omitempty"`
 }
 
+// Workspace owns the lists of a team, its members are members of each of its
+// lists with the same role. Role is the role of the user the workspace was
+// retrieved for.
+type Workspace struct {
+	UUID     uuid.UUID `json:"uuid"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role,omitempty"`
+	Created  int64     `json:"created"`
+	Modified int64     `json:"modified"`
+}
+
+type WorkspaceMember struct {
+	WorkspaceUUID uuid.UUID `json:"workspace_uuid"`
+	UserUUID      uuid.UUID `json:"user_uuid"`
+	Email         string    `json:"email,omitempty"`
+	Role          string    `json:"role"`
+	Created       int64     `json:"created"`
+}
+
+// States returns the list's workflow.
+func (l List) States() []WorkflowState {
+	if len(l.Workflow) == 0 {
+		return DefaultWorkflow
+	}
+	return l.Workflow
+}
+
+// State returns the workflow state with the given value.
+func (l List) State(value uint8) (WorkflowState, bool) {
+	for _, s := range l.States() {
+		if s.Value == value {
+			return s, true
+		}
+	}
+	return WorkflowState{}, false
+}
+
+// Finished reports whether an item in the given state is finished. Finished
+// items are left out of the date views, do not block other items and
+// complete an occurrence of a repeating item.
+func (l List) Finished(value uint8) bool {
+	s, ok := l.State(value)
+	return ok && s.Terminal
+}
+
+// WorkflowState is a named value for Item.State. Items in a terminal state are
+// finished. Color is a #rrggbb hex color, or empty for no color. Limit is how
+// many top level items the state's column on the board takes, 0 for no limit.
+type WorkflowState struct {
+	Value    uint8  `json:"value"`
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Terminal bool   `json:"terminal"`
+	Limit    int    `json:"limit"`
+}
+
+// ItemStateDone is the done state of DefaultWorkflow.
+const ItemStateDone uint8 = 1
+
+// DefaultWorkflow keeps the meaning states had before lists had workflows.
+var DefaultWorkflow = []WorkflowState{
+	{Value: 0, Name: "todo"},
+	{Value: ItemStateDone, Name: "done", Terminal: true},
+}
+
+// Due and Start are optional unix timestamps, 0 when not set. RRule makes the
+// item repeat, Occurrence is its position in the series starting at 1 and
+// PreviousUUID is the occurrence it was spawned from, nil for the first.
+// ParentUUID is the item this is a subtask of, uuid.Nil for top level items.
+// Subtasks are always in the same list as their parent. AssigneeUUID is the
+// member of the list the item is assigned to, nil for nobody, use Assignee and
+// Assign rather than setting it. Blocked is not stored, it is set by the api
+// when a dependency of the item is not done.
 type Item struct {
-	UUID        uuid.UUID `json:"uuid"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	State       uint8     `json:"state"`
+	UUID         uuid.UUID  `json:"uuid"`
+	Title        string     `json:"title"`
+	Description  string     `json:"description"`
+	State        uint8      `json:"state"`
+	Created      int64      `json:"created"`
+	Modified     int64      `json:"modified"`
+	ListUUID     uuid.UUID  `json:"list_uuid"`
+	ParentUUID   uuid.UUID  `json:"parent_uuid"`
+	AssigneeUUID *uuid.UUID `json:"assignee_uuid,omitempty"`
+	Position     string     `json:"position"`
+	Due          int64      `json:"due,omitempty"`
+	Start        int64      `json:"start,omitempty"`
+	RRule        string     `json:"rrule,omitempty"`
+	Occurrence   int        `json:"occurrence,omitempty"`
+	PreviousUUID *uuid.UUID `json:"previous_uuid,omitempty"`
+	Deleted      int64      `json:"deleted,omitempty"`
+	Blocked      bool       `json:"blocked,omitempty"`
+	Clock        Clock      `json:"-"`
+}
+
+// Assignee returns the member the item is assigned to, uuid.Nil for nobody.
+func (i Item) Assignee() uuid.UUID {
+	if i.AssigneeUUID == nil {
+		return uuid.Nil
+	}
+	return *i.AssigneeUUID
+}
+
+// Assign assigns the item to the member, uuid.Nil unassigns it.
+func (i *Item) Assign(member uuid.UUID) {
+	if member == uuid.Nil {
+		i.AssigneeUUID = nil
+		return
+	}
+	i.AssigneeUUID = &member
+}
+
+// Dependency means the item is blocked by BlockerUUID until that item is
+// done. The items can be in different lists.
+type Dependency struct {
+	ItemUUID    uuid.UUID `json:"item_uuid"`
+	BlockerUUID uuid.UUID `json:"blocker_uuid"`
 	Created     int64     `json:"created"`
-	Modified    int64     `json:"modified"`
-	ListUUID    uuid.UUID `json:"list_uuid"`
+}
+
+// Comment is a message on an item. Replies have the comment they answer as
+// ParentUUID, comments on the item itself uuid.Nil. Edited is when the author
+// last changed Body, 0 if they never did.
+type Comment struct {
+	UUID       uuid.UUID `json:"uuid"`
+	ItemUUID   uuid.UUID `json:"item_uuid"`
+	ParentUUID uuid.UUID `json:"parent_uuid"`
+	Author     uuid.UUID `json:"author"`
+	Body       string    `json:"body"`
+	Created    int64     `json:"created"`
+	Edited     int64     `json:"edited"`
+}
+
+// Tag is a label owned by a user that can be put on items in any of their
+// lists. Color is a #rrggbb hex color, or empty for no color.
+type Tag struct {
+	UUID     uuid.UUID `json:"uuid"`
+	Name     string    `json:"name"`
+	Color    string    `json:"color"`
+	Created  int64     `json:"created"`
+	Modified int64     `json:"modified"`
+	Clock    Clock     `json:"-"`
+}
+
+// Clock records when each field was last written so conflicting updates can
+// be resolved per field. Fields without an entry were last written at the
+// entity's Modified time.
+type Clock map[string]int64
+
+func (c Clock) Get(field string, fallback int64) int64 {
+	if ts, ok := c[field]; ok {
+		return ts
+	}
+	return fallback
+}
+
+func (c *Clock) Set(field string, ts int64) {
+	if *c == nil {
+		*c = make(Clock)
+	}
+	(*c)[field] = ts
+}
+
+func (c Clock) Copy() Clock {
+	if c == nil {
+		return nil
+	}
+
+	copied := make(Clock, len(c))
+	for k, v := range c {
+		copied[k] = v
+	}
+	return copied
 }
 
 type History struct {
@@ -36,4 +260,45 @@ type User struct {
 	PassHash []byte
 	Created  int64
 	Modified int64
+	Verified int64
+
+	// IANA time zone used for the date views, empty means UTC
+	TimeZone string
+
+	// access tokens issued before this unix time are no longer accepted
+	NotBefore int64
+}
+
+// UserToken is a single use token emailed to a user, only the hash of the
+// token is stored.
+type UserToken struct {
+	Hash     []byte
+	UserUUID uuid.UUID
+	Created  int64
+	Expires  int64
+	Used     int64
+}
+
+// RefreshToken is a rotating token used to get new access tokens. Every token
+// issued from the same login shares a Family. Workspace is the active
+// workspace of the access tokens it is exchanged for, uuid.Nil for none.
+type RefreshToken struct {
+	Hash      []byte
+	Family    uuid.UUID
+	UserUUID  uuid.UUID
+	Workspace uuid.UUID
+	Created   int64
+	Expires   int64
+	Used      int64
+	Revoked   int64
+}
+
+// SigningKey is a key pair used to sign access tokens. PrivateKey is PKCS8
+// encoded.
+type SigningKey struct {
+	Kid        string
+	Algorithm  string
+	PrivateKey []byte
+	Created    int64
+	Retired    int64
 }
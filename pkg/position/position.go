@@ -0,0 +1,122 @@
+// Package position generates positions for manually ordered lists and items.
+// A position is a string of base 62 digits read as a fraction, so there is
+// always room for another position between two different ones. Positions
+// compare byte by byte, which is what sorting the strings does.
+package position
+
+import (
+	"errors"
+	"strings"
+)
+
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// MaxLength is the longest position accepted from clients.
+const MaxLength = 255
+
+// ErrReversed is returned by Between when its bounds are the wrong way round.
+var ErrReversed = errors.New("Position bounds are reversed")
+
+// Valid reports whether p is a non empty position made of base 62 digits.
+// Positions cannot end in a zero, "1" and "10" would be the same fraction.
+func Valid(p string) bool {
+	if p == "" || len(p) > MaxLength || p[len(p)-1] == '0' {
+		return false
+	}
+
+	for i := 0; i < len(p); i++ {
+		if strings.IndexByte(digits, p[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Between returns a position that sorts after a and before b, both of which
+// must be valid or empty. An empty a is the start and an empty b the end. If
+// a and b are the same, which happens when two devices picked the same
+// position, the result sorts directly after a. ErrReversed is returned if a
+// sorts after b.
+func Between(a, b string) (string, error) {
+	switch {
+	case a == "" && b == "":
+		return midpoint("", ""), nil
+	case b == "":
+		return after(a), nil
+	case a > b:
+		return "", ErrReversed
+	case a == b:
+		return a + midpoint("", ""), nil
+	case a == "":
+		return before(b), nil
+	}
+	return midpoint(a, b), nil
+}
+
+// after keeps positions short when appending by bumping the first digit that
+// can be bumped instead of halving the remaining space.
+func after(a string) string {
+	for i := 0; i < len(a); i++ {
+		if d := strings.IndexByte(digits, a[i]); d < len(digits)-1 {
+			return a[:i] + string(digits[d+1])
+		}
+	}
+	return a + midpoint("", "")
+}
+
+// before is after for prepending, positions never end in a zero.
+func before(b string) string {
+	for i := 0; i < len(b); i++ {
+		if d := strings.IndexByte(digits, b[i]); d > 1 {
+			return b[:i] + string(digits[d-1])
+		}
+	}
+	return midpoint("", b)
+}
+
+// midpoint returns a position between a and b, which must not end in a zero.
+// An empty b is the end.
+func midpoint(a, b string) string {
+	if b != "" {
+		n := 0
+		for n < len(b) && digitAt(a, n) == strings.IndexByte(digits, b[n]) {
+			n++
+		}
+
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midpoint(rest, b[n:])
+		}
+	}
+
+	digitA := digitAt(a, 0)
+	digitB := len(digits)
+	if b != "" {
+		digitB = strings.IndexByte(digits, b[0])
+	}
+
+	if digitB-digitA > 1 {
+		return string(digits[(digitA+digitB+1)/2])
+	}
+
+	if len(b) > 1 {
+		return b[:1]
+	}
+
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(digits[digitA]) + midpoint(rest, "")
+}
+
+// digitAt returns the value of the digit at i, missing digits are zero.
+func digitAt(p string, i int) int {
+	if i >= len(p) {
+		return 0
+	}
+	return strings.IndexByte(digits, p[i])
+}
@@ -0,0 +1,95 @@
+package position
+
+import "testing"
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"empty bounds", "", ""},
+		{"start", "", "V"},
+		{"end", "V", ""},
+		{"apart", "a", "c"},
+		{"next digits", "a", "b"},
+		{"adjacent keys", "a", "a1"},
+		{"longer first", "a1", "b"},
+		{"shared prefix", "aV", "aW"},
+		{"before the first digit", "", "1"},
+		{"before a leading zero", "", "01"},
+		{"after the last digit", "z", ""},
+		{"after all last digits", "zzz", ""},
+		{"between the ends", "1", "z"},
+		{"up to the last digit", "y", "z"},
+		{"same position", "m", "m"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Between(test.a, test.b)
+			if err != nil {
+				t.Fatalf("Between(%q, %q): %s", test.a, test.b, err)
+			}
+
+			if !Valid(p) {
+				t.Errorf("Between(%q, %q) = %q, which is not valid", test.a, test.b, p)
+			}
+			if p <= test.a || (test.b != "" && test.a != test.b && p >= test.b) {
+				t.Errorf("Between(%q, %q) = %q, which is out of order", test.a, test.b, p)
+			}
+		})
+	}
+}
+
+func TestBetweenReversed(t *testing.T) {
+	for _, bounds := range [][2]string{{"b", "a"}, {"a1", "a"}, {"z", "1"}} {
+		if p, err := Between(bounds[0], bounds[1]); err != ErrReversed {
+			t.Errorf("Between(%q, %q): expected ErrReversed, got %q %v", bounds[0], bounds[1], p, err)
+		}
+	}
+}
+
+// Inserting over and over between the same pair, at either end, has to keep
+// producing positions that sort strictly between them, compared byte by byte
+// like COLLATE "C".
+func TestBetweenRepeated(t *testing.T) {
+	for _, bounds := range [][2]string{{"a", "b"}, {"", "1"}, {"z", ""}, {"a", "a1"}} {
+		lower, upper := bounds[0], bounds[1]
+		for _, towardsUpper := range []bool{true, false} {
+			a, b := lower, upper
+			for i := 0; i < 500; i++ {
+				p, err := Between(a, b)
+				if err != nil {
+					t.Fatalf("Between(%q, %q): %s", a, b, err)
+				}
+				if !Valid(p) || p <= a || (b != "" && p >= b) {
+					t.Fatalf("Between(%q, %q) = %q after %d inserts", a, b, p, i)
+				}
+
+				if towardsUpper {
+					a = p
+				} else {
+					b = p
+				}
+			}
+
+			if len(a) > MaxLength || len(b) > MaxLength {
+				t.Errorf("%q %q: positions grew past MaxLength", lower, upper)
+			}
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, p := range []string{"1", "V", "z", "a1", "0V", "zzzV"} {
+		if !Valid(p) {
+			t.Errorf("%q should be valid", p)
+		}
+	}
+
+	for _, p := range []string{"", "0", "a0", "a-", "a b", string(make([]byte, MaxLength+1))} {
+		if Valid(p) {
+			t.Errorf("%q should not be valid", p)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Package rrule implements the subset of RFC 5545 recurrence rules used for
+// repeating items: DAILY, WEEKLY and MONTHLY frequencies with INTERVAL, BYDAY,
+// BYMONTHDAY, COUNT and UNTIL.
+package rrule
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+)
+
+var ErrInvalidRule = errors.New("Invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// WeekdayNum is a BYDAY entry. N is the occurrence of the weekday within the
+// month for monthly rules, 1 is the first and -1 the last. 0 means every one.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	Count      int
+	Until      time.Time
+}
+
+// Parse parses a rule like FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10. A leading
+// "RRULE:" is allowed.
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return Rule{}, ErrInvalidRule
+	}
+
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return Rule{}, ErrInvalidRule
+		}
+
+		value := kv[1]
+		var err error
+		switch strings.ToUpper(kv[0]) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(value)
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(value)
+			if rule.Interval < 1 {
+				err = ErrInvalidRule
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(value)
+			if rule.Count < 1 {
+				err = ErrInvalidRule
+			}
+		case "UNTIL":
+			rule.Until, err = parseUntil(value)
+		case "BYDAY":
+			rule.ByDay, err = parseByDay(value)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseByMonthDay(value)
+		case "WKST":
+			if strings.ToUpper(value) != "MO" {
+				err = ErrInvalidRule
+			}
+		default:
+			err = ErrInvalidRule
+		}
+
+		if err != nil {
+			return Rule{}, ErrInvalidRule
+		}
+	}
+
+	switch rule.Freq {
+	case Daily:
+		if len(rule.ByDay) > 0 || len(rule.ByMonthDay) > 0 {
+			return Rule{}, ErrInvalidRule
+		}
+	case Weekly:
+		if len(rule.ByMonthDay) > 0 {
+			return Rule{}, ErrInvalidRule
+		}
+		for _, day := range rule.ByDay {
+			if day.N != 0 {
+				return Rule{}, ErrInvalidRule
+			}
+		}
+	case Monthly:
+	default:
+		return Rule{}, ErrInvalidRule
+	}
+
+	if rule.Count != 0 && !rule.Until.IsZero() {
+		return Rule{}, ErrInvalidRule
+	}
+
+	return rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+
+	// a date means the end of that day
+	t, err := time.Parse("20060102", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+	days := make([]WeekdayNum, 0)
+	for _, entry := range strings.Split(strings.ToUpper(value), ",") {
+		if len(entry) < 2 {
+			return nil, ErrInvalidRule
+		}
+
+		day, ok := weekdays[entry[len(entry)-2:]]
+		if !ok {
+			return nil, ErrInvalidRule
+		}
+
+		n := 0
+		if prefix := entry[:len(entry)-2]; prefix != "" {
+			var err error
+			n, err = strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, ErrInvalidRule
+			}
+		}
+		days = append(days, WeekdayNum{N: n, Day: day})
+	}
+	return days, nil
+}
+
+func parseByMonthDay(value string) ([]int, error) {
+	days := make([]int, 0)
+	for _, entry := range strings.Split(value, ",") {
+		day, err := strconv.Atoi(entry)
+		if err != nil || day == 0 || day < -31 || day > 31 {
+			return nil, ErrInvalidRule
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// Next returns the occurrence after t, where t is occurrence number n of the
+// series starting at 1. Occurrences keep the time of day of t in its
+// location. false is returned once COUNT or UNTIL ends the series.
+func (r Rule) Next(t time.Time, n int) (time.Time, bool) {
+	if r.Count != 0 && n >= r.Count {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+	var ok bool
+	switch r.Freq {
+	case Daily:
+		next, ok = t.AddDate(0, 0, r.Interval), true
+	case Weekly:
+		next, ok = r.nextWeekly(t)
+	case Monthly:
+		next, ok = r.nextMonthly(t)
+	}
+
+	if !ok || (!r.Until.IsZero() && next.After(r.Until)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// weeks start on monday
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+func (r Rule) nextWeekly(t time.Time) (time.Time, bool) {
+	if len(r.ByDay) == 0 {
+		return t.AddDate(0, 0, 7*r.Interval), true
+	}
+
+	week := startOfWeek(t)
+	for i := 1; i <= 7*(r.Interval+1); i++ {
+		candidate := t.AddDate(0, 0, i)
+		weeks := int(startOfWeek(candidate).Sub(week).Hours()+12) / (24 * 7)
+		if weeks%r.Interval != 0 {
+			continue
+		}
+
+		for _, day := range r.ByDay {
+			if candidate.Weekday() == day.Day {
+				return candidate, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func (r Rule) nextMonthly(t time.Time) (time.Time, bool) {
+	// months without a matching day are skipped, like the 31st in april
+	for i := 0; i <= 24*r.Interval; i += r.Interval {
+		month := time.Date(t.Year(), t.Month()+time.Month(i), 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+		for _, day := range r.monthDays(month, t.Day()) {
+			candidate := time.Date(month.Year(), month.Month(), day, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+			if candidate.After(t) {
+				return candidate, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// monthDays returns the sorted days of month that match the rule. A rule
+// with both BYMONTHDAY and BYDAY matches the days in both, like friday the
+// 13th. Rules with neither repeat on the fallback day.
+func (r Rule) monthDays(month time.Time, fallback int) []int {
+	length := time.Date(month.Year(), month.Month()+1, 0, 0, 0, 0, 0, month.Location()).Day()
+
+	var byMonthDay, byDay map[int]bool
+	if len(r.ByMonthDay) > 0 {
+		byMonthDay = make(map[int]bool)
+		for _, day := range r.ByMonthDay {
+			if day < 0 {
+				day = length + day + 1
+			}
+			byMonthDay[day] = true
+		}
+	}
+
+	if len(r.ByDay) > 0 {
+		byDay = make(map[int]bool)
+		for _, wd := range r.ByDay {
+			days := make([]int, 0, 5)
+			for day := 1; day <= length; day++ {
+				if time.Date(month.Year(), month.Month(), day, 0, 0, 0, 0, month.Location()).Weekday() == wd.Day {
+					days = append(days, day)
+				}
+			}
+
+			switch {
+			case wd.N == 0:
+				for _, day := range days {
+					byDay[day] = true
+				}
+			case wd.N > 0 && wd.N <= len(days):
+				byDay[days[wd.N-1]] = true
+			case wd.N < 0 && -wd.N <= len(days):
+				byDay[days[len(days)+wd.N]] = true
+			}
+		}
+	}
+
+	days := make([]int, 0)
+	for day := 1; day <= length; day++ {
+		switch {
+		case byMonthDay == nil && byDay == nil:
+			if day == fallback {
+				days = append(days, day)
+			}
+		case (byMonthDay == nil || byMonthDay[day]) && (byDay == nil || byDay[day]):
+			days = append(days, day)
+		}
+	}
+	return days
+}
@@ -0,0 +1,143 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	valid := []string{
+		"FREQ=DAILY",
+		"RRULE:FREQ=DAILY;INTERVAL=2",
+		"freq=weekly;byday=mo,th",
+		"FREQ=WEEKLY;WKST=MO;COUNT=4",
+		"FREQ=MONTHLY;BYMONTHDAY=1,-1",
+		"FREQ=MONTHLY;BYDAY=-1FR",
+		"FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+		"FREQ=MONTHLY;UNTIL=20210101T000000Z",
+	}
+	for _, rule := range valid {
+		if _, err := Parse(rule); err != nil {
+			t.Errorf("%s: %s", rule, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"RRULE:",
+		"FREQ=YEARLY",
+		"FREQ=HOURLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20210101",
+		"FREQ=DAILY;BYDAY=MO",
+		"FREQ=DAILY;BYMONTHDAY=1",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=WEEKLY;WKST=SU",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;UNTIL=tomorrow",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ",
+	}
+	for _, rule := range invalid {
+		if _, err := Parse(rule); err == nil {
+			t.Errorf("%s: expected an error", rule)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	toronto, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	utc := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+	local := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, toronto)
+	}
+
+	tests := []struct {
+		name string
+		rule string
+		from time.Time
+		n    int
+		want time.Time
+	}{
+		{"daily", "FREQ=DAILY", utc(2021, 1, 31), 1, utc(2021, 2, 1)},
+		{"daily interval", "FREQ=DAILY;INTERVAL=3", utc(2021, 2, 27), 1, utc(2021, 3, 2)},
+		{"daily into dst", "FREQ=DAILY", local(2021, 3, 13), 1, local(2021, 3, 14)},
+		{"daily out of dst", "FREQ=DAILY", local(2021, 11, 6), 1, local(2021, 11, 7)},
+
+		{"weekly", "FREQ=WEEKLY", utc(2021, 12, 29), 1, utc(2022, 1, 5)},
+		{"weekly byday in the week", "FREQ=WEEKLY;BYDAY=MO,TH", utc(2021, 3, 1), 1, utc(2021, 3, 4)},
+		{"weekly byday next week", "FREQ=WEEKLY;BYDAY=MO,TH", utc(2021, 3, 4), 1, utc(2021, 3, 8)},
+		{"weekly interval", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", utc(2021, 3, 5), 1, utc(2021, 3, 15)},
+		{"weekly out of dst", "FREQ=WEEKLY;BYDAY=MO", local(2021, 11, 1), 1, local(2021, 11, 8)},
+
+		{"monthly", "FREQ=MONTHLY", utc(2021, 1, 15), 1, utc(2021, 2, 15)},
+		{"monthly skips short months", "FREQ=MONTHLY", utc(2021, 1, 31), 1, utc(2021, 3, 31)},
+		{"monthly 31st", "FREQ=MONTHLY;BYMONTHDAY=31", utc(2021, 3, 31), 1, utc(2021, 5, 31)},
+		{"monthly 31st over february", "FREQ=MONTHLY;BYMONTHDAY=31", utc(2021, 1, 31), 1, utc(2021, 3, 31)},
+		{"monthly last day", "FREQ=MONTHLY;BYMONTHDAY=-1", utc(2021, 1, 31), 1, utc(2021, 2, 28)},
+		{"monthly last day leap year", "FREQ=MONTHLY;BYMONTHDAY=-1", utc(2024, 1, 31), 1, utc(2024, 2, 29)},
+		{"monthly two days", "FREQ=MONTHLY;BYMONTHDAY=1,15", utc(2021, 1, 1), 1, utc(2021, 1, 15)},
+		{"monthly second tuesday", "FREQ=MONTHLY;BYDAY=2TU", utc(2021, 1, 12), 1, utc(2021, 2, 9)},
+		{"monthly last friday", "FREQ=MONTHLY;BYDAY=-1FR", utc(2021, 1, 29), 1, utc(2021, 2, 26)},
+		{"monthly interval", "FREQ=MONTHLY;INTERVAL=2", utc(2021, 11, 10), 1, utc(2022, 1, 10)},
+		{"monthly friday the 13th", "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", utc(2021, 8, 13), 1, utc(2022, 5, 13)},
+		{"monthly into dst", "FREQ=MONTHLY", local(2021, 2, 14), 1, local(2021, 3, 14)},
+
+		{"count", "FREQ=DAILY;COUNT=3", utc(2021, 1, 2), 2, utc(2021, 1, 3)},
+		{"until date", "FREQ=DAILY;UNTIL=20210105", utc(2021, 1, 4), 1, utc(2021, 1, 5)},
+		{"until time", "FREQ=WEEKLY;UNTIL=20210111T093000Z", utc(2021, 1, 4), 1, utc(2021, 1, 11)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := Parse(test.rule)
+			if err != nil {
+				t.Fatalf("%s: %s", test.rule, err)
+			}
+
+			next, ok := rule.Next(test.from, test.n)
+			if !ok || !next.Equal(test.want) {
+				t.Errorf("%s after %s: expected %s, got %s %t", test.rule, test.from, test.want, next, ok)
+			}
+		})
+	}
+}
+
+func TestNextEnds(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		from time.Time
+		n    int
+	}{
+		{"count", "FREQ=DAILY;COUNT=3", time.Date(2021, 1, 3, 9, 0, 0, 0, time.UTC), 3},
+		{"until date", "FREQ=DAILY;UNTIL=20210105", time.Date(2021, 1, 5, 9, 0, 0, 0, time.UTC), 1},
+		{"until time", "FREQ=WEEKLY;UNTIL=20210111T090000Z", time.Date(2021, 1, 4, 9, 30, 0, 0, time.UTC), 1},
+		{"never matches", "FREQ=MONTHLY;BYDAY=1MO;BYMONTHDAY=31", time.Date(2021, 1, 4, 9, 0, 0, 0, time.UTC), 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := Parse(test.rule)
+			if err != nil {
+				t.Fatalf("%s: %s", test.rule, err)
+			}
+
+			if next, ok := rule.Next(test.from, test.n); ok {
+				t.Errorf("%s after %s: expected the series to end, got %s", test.rule, test.from, next)
+			}
+		})
+	}
+}